@@ -0,0 +1,734 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"slices"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/spf13/cobra"
+	k8serrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+)
+
+// A helmAddon is a cluster component installed from a Helm chart
+type helmAddon struct {
+	Name        string
+	Description string
+	Release     string
+	Namespace   string
+	RepoName    string
+	RepoURL     string
+	Chart       string
+	// Addons installed, and ready, before this one
+	DependsOn []string
+	// Values set unless the user overrides them
+	Values []string
+	// CRDs the chart installs; they can outlive the release
+	CRDs []string
+	// Deployments whose readiness shows the addon works
+	Workloads []string
+	// Check tells whether the cluster has what the addon provides. An addon whose check
+	// passes without a release is provided by the cluster and is not installed again.
+	Check func(env *doctorEnv) checkResult
+	// Permissions checked before installing and uninstalling
+	InstallPermissions   func(namespace string) []permission
+	UninstallPermissions func(namespace string) []permission
+}
+
+var metricsServerAddon = helmAddon{
+	Name:                 "metrics-server",
+	Description:          "Resource metrics for health-status and cpu/memory autoscaling",
+	Release:              "metrics-server",
+	Namespace:            "kube-system",
+	RepoName:             "metrics-server",
+	RepoURL:              "https://kubernetes-sigs.github.io/metrics-server/",
+	Chart:                "metrics-server",
+	Workloads:            []string{"metrics-server"},
+	Check:                checkMetricsServer,
+	InstallPermissions:   installAddonPermissions,
+	UninstallPermissions: uninstallAddonPermissions,
+}
+
+var kedaAddon = helmAddon{
+	Name:        "keda",
+	Description: "Event driven autoscaler behind the ScaledObjects of create-deployment",
+	Release:     "keda",
+	Namespace:   "keda",
+	RepoName:    "kedacore",
+	RepoURL:     "https://kedacore.github.io/charts",
+	Chart:       "keda",
+	// The cpu and memory triggers read the metrics API
+	DependsOn: []string{"metrics-server"},
+	CRDs: []string{
+		"scaledobjects.keda.sh",
+		"scaledjobs.keda.sh",
+		"triggerauthentications.keda.sh",
+		"clustertriggerauthentications.keda.sh",
+	},
+	Workloads:            []string{"keda-operator", "keda-operator-metrics-apiserver"},
+	Check:                checkKEDA,
+	InstallPermissions:   installKEDAPermissions,
+	UninstallPermissions: uninstallKEDAPermissions,
+}
+
+var kedaHTTPAddon = helmAddon{
+	Name:        "keda-http-add-on",
+	Description: "Scales HTTP services on request rate, including to zero",
+	Release:     "keda-add-ons-http",
+	Namespace:   "keda",
+	RepoName:    "kedacore",
+	RepoURL:     "https://kedacore.github.io/charts",
+	Chart:       "keda-add-ons-http",
+	DependsOn:   []string{"keda"},
+	CRDs:        []string{"httpscaledobjects.http.keda.sh"},
+	Workloads: []string{
+		"keda-add-ons-http-controller-manager",
+		"keda-add-ons-http-interceptor",
+		"keda-add-ons-http-external-scaler",
+	},
+	Check:                checkKEDAHTTPAddon,
+	InstallPermissions:   installAddonPermissions,
+	UninstallPermissions: uninstallAddonPermissions,
+}
+
+var prometheusAddon = helmAddon{
+	Name:        "prometheus",
+	Description: "Metrics server the prometheus trigger of create-deployment queries",
+	Release:     "prometheus",
+	// Serves prometheusServerAddress
+	Namespace: "monitoring",
+	RepoName:  "prometheus-community",
+	RepoURL:   "https://prometheus-community.github.io/helm-charts",
+	Chart:     "prometheus",
+	Values: []string{
+		"alertmanager.enabled=false",
+		"prometheus-pushgateway.enabled=false",
+	},
+	Workloads:            []string{"prometheus-server"},
+	Check:                checkPrometheus,
+	InstallPermissions:   installAddonPermissions,
+	UninstallPermissions: uninstallAddonPermissions,
+}
+
+var gpuOperatorAddon = helmAddon{
+	Name:                 "gpu-operator",
+	Description:          "NVIDIA drivers and device plugin for GPU nodes",
+	Release:              "gpu-operator",
+	Namespace:            "gpu-operator",
+	RepoName:             "nvidia",
+	RepoURL:              "https://helm.ngc.nvidia.com/nvidia",
+	Chart:                "gpu-operator",
+	CRDs:                 []string{"clusterpolicies.nvidia.com", "nvidiadrivers.nvidia.com"},
+	Workloads:            []string{"gpu-operator"},
+	Check:                checkGPUDevicePlugin,
+	InstallPermissions:   installAddonPermissions,
+	UninstallPermissions: uninstallAddonPermissions,
+}
+
+var ingressNginxAddon = helmAddon{
+	Name:                 "ingress-nginx",
+	Description:          "Ingress controller exposing deployments outside the cluster",
+	Release:              "ingress-nginx",
+	Namespace:            "ingress-nginx",
+	RepoName:             "ingress-nginx",
+	RepoURL:              "https://kubernetes.github.io/ingress-nginx",
+	Chart:                "ingress-nginx",
+	Workloads:            []string{"ingress-nginx-controller"},
+	Check:                checkIngressNginx,
+	InstallPermissions:   installAddonPermissions,
+	UninstallPermissions: uninstallAddonPermissions,
+}
+
+// Addons that can be installed and bundled
+var knownAddons = []helmAddon{
+	metricsServerAddon,
+	kedaAddon,
+	kedaHTTPAddon,
+	prometheusAddon,
+	gpuOperatorAddon,
+	ingressNginxAddon,
+}
+
+func addonByName(name string) (helmAddon, bool) {
+	for _, addon := range knownAddons {
+		if addon.Name == name {
+			return addon, true
+		}
+	}
+	return helmAddon{}, false
+}
+
+// resolveAddons adds the dependencies of the named addons and orders them so every addon comes
+// after the addons it depends on
+func resolveAddons(names []string) ([]helmAddon, error) {
+	var ordered []helmAddon
+	const visiting, done = 1, 2
+	state := map[string]int{}
+	var visit func(name string) error
+	visit = func(name string) error {
+		switch state[name] {
+		case visiting:
+			return fmt.Errorf("addon %s depends on itself", name)
+		case done:
+			return nil
+		}
+		addon, ok := addonByName(name)
+		if !ok {
+			return fmt.Errorf("unknown addon %q, run 'simplismart-cli addons list'", name)
+		}
+		state[name] = visiting
+		for _, dep := range addon.DependsOn {
+			if err := visit(dep); err != nil {
+				return err
+			}
+		}
+		state[name] = done
+		ordered = append(ordered, addon)
+		return nil
+	}
+	for _, name := range names {
+		if err := visit(name); err != nil {
+			return nil, err
+		}
+	}
+	return ordered, nil
+}
+
+// addonOptions are the user inputs shared by install and upgrade
+type addonOptions struct {
+	Version     string
+	ValuesFiles []string
+	Set         []string
+	Wait        bool
+	Timeout     time.Duration
+	// Chart sources other than the upstream repository, at most one is set
+	ChartPath string
+	Registry  string
+	Embedded  bool
+	PlainHTTP bool
+	// Mirror registry every image is pulled from
+	ImageRegistry string
+}
+
+var AddonsCmd = &cobra.Command{
+	Use:   "addons",
+	Short: "Manage cluster addons the CLI depends on",
+}
+
+var AddonsListCmd = &cobra.Command{
+	Use:   "list",
+	Short: "List the addons and whether they are installed",
+	Run: func(cmd *cobra.Command, args []string) {
+		clientset, err := GetK8sClient()
+		if err != nil {
+			fmt.Println("Error creating Kubernetes client:", err)
+			return
+		}
+		h := newHelmClient()
+		env := &doctorEnv{clientset: clientset}
+		tw := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+		fmt.Fprintln(tw, "NAME\tSTATUS\tVERSION\tNAMESPACE\tHEALTH\tDEPENDS ON\tDESCRIPTION")
+		for _, addon := range knownAddons {
+			status, version := "not installed", "-"
+			release, err := h.getRelease(addon.Release, addon.Namespace)
+			if err != nil {
+				status = "unknown"
+			} else if release != nil {
+				status, version = release.Status, release.ChartVersion
+			}
+			health := "-"
+			if addon.Check != nil {
+				health = addon.Check(env).Status
+				if release == nil && err == nil && health == CheckPass {
+					status = "provided"
+				}
+			}
+			fmt.Fprintf(tw, "%s\t%s\t%s\t%s\t%s\t%s\t%s\n", addon.Name, status, version, addon.Namespace, health,
+				orDash(strings.Join(addon.DependsOn, ",")), addon.Description)
+		}
+		tw.Flush()
+	},
+}
+
+var AddonsInstallCmd = &cobra.Command{
+	Use:   "install ADDON...",
+	Short: "Install addons together with the addons they depend on",
+	Args:  cobra.MinimumNArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		var opts addonOptions
+		opts.Wait, _ = cmd.Flags().GetBool("wait")
+		opts.Timeout, _ = cmd.Flags().GetDuration("timeout")
+		chartSourceOptions(cmd, &opts)
+		skipPreflight, _ := cmd.Flags().GetBool("skip-preflight")
+
+		addons, err := resolveAddons(args)
+		if err != nil {
+			fmt.Println(err)
+			return
+		}
+		clientset, err := GetK8sClient()
+		if err != nil {
+			fmt.Println("Error creating Kubernetes client:", err)
+			return
+		}
+		if !skipPreflight {
+			if err := preflightRBAC(clientset, "addons-install", addonsPermissions(addons)); err != nil {
+				fmt.Println(err)
+				return
+			}
+		}
+		h := newHelmClient()
+		h.plainHTTP = opts.PlainHTTP
+		if err := installAddons(h, clientset, addons, opts); err != nil {
+			fmt.Println(err)
+		}
+	},
+}
+
+var AddonsStatusCmd = &cobra.Command{
+	Use:   "status [ADDON...]",
+	Short: "Show the version and health of the addons, all of them by default",
+	Run: func(cmd *cobra.Command, args []string) {
+		addons := knownAddons
+		if len(args) > 0 {
+			addons = nil
+			for _, name := range args {
+				addon, ok := addonByName(name)
+				if !ok {
+					fmt.Printf("Unknown addon %q, run 'simplismart-cli addons list'\n", name)
+					return
+				}
+				addons = append(addons, addon)
+			}
+		}
+		clientset, err := GetK8sClient()
+		if err != nil {
+			fmt.Println("Error creating Kubernetes client:", err)
+			return
+		}
+		h := newHelmClient()
+		for i, addon := range addons {
+			if i > 0 {
+				fmt.Println()
+			}
+			if err := printAddonStatus(h, clientset, addon); err != nil {
+				fmt.Println(err)
+			}
+		}
+	},
+}
+
+// installAddons installs the addons in order, waiting for each one a later addon depends on
+func installAddons(h *helmClient, clientset *kubernetes.Clientset, addons []helmAddon, opts addonOptions) error {
+	for i, addon := range addons {
+		addonOpts := opts
+		for _, later := range addons[i+1:] {
+			if slices.Contains(later.DependsOn, addon.Name) {
+				addonOpts.Wait = true
+			}
+		}
+		if err := installAddon(h, clientset, addon, addonOpts); err != nil {
+			return fmt.Errorf("failed to install %s: %v", addon.Name, err)
+		}
+	}
+	return nil
+}
+
+// newAddonCmd builds the install, upgrade, uninstall and status subcommands of an addon
+func newAddonCmd(addon helmAddon, short string) *cobra.Command {
+	addonCmd := &cobra.Command{
+		Use:   addon.Name,
+		Short: short,
+	}
+
+	installCmd := &cobra.Command{
+		Use:   "install",
+		Short: fmt.Sprintf("Install %s, doing nothing if it is already installed", addon.Name),
+		Run: func(cmd *cobra.Command, args []string) {
+			runAddonCommand(cmd, addon, installAddon)
+		},
+	}
+	upgradeCmd := &cobra.Command{
+		Use:   "upgrade",
+		Short: fmt.Sprintf("Upgrade %s or change its values", addon.Name),
+		Run: func(cmd *cobra.Command, args []string) {
+			runAddonCommand(cmd, addon, upgradeAddon)
+		},
+	}
+	for _, c := range []*cobra.Command{installCmd, upgradeCmd} {
+		c.Flags().String("version", "", "Chart version (defaults to the latest)")
+		c.Flags().StringSliceP("values", "f", []string{}, "Values files for the chart")
+		c.Flags().StringArray("set", []string{}, "Chart values (e.g., image.pullPolicy=Always)")
+		c.Flags().Bool("wait", false, "Wait until the addon is ready")
+		c.Flags().Duration("timeout", 5*time.Minute, "How long to wait with --wait")
+		c.Flags().Bool("skip-preflight", false, "Skip the permission check")
+		c.Flags().String("chart-path", "", "Install from a local chart archive or directory instead of the repository")
+		addChartSourceFlags(c)
+		c.MarkFlagsMutuallyExclusive("chart-path", "registry", "embedded")
+	}
+
+	uninstallCmd := &cobra.Command{
+		Use:   "uninstall",
+		Short: fmt.Sprintf("Uninstall %s", addon.Name),
+		Run: func(cmd *cobra.Command, args []string) {
+			purgeCRDs, _ := cmd.Flags().GetBool("purge-crds")
+			wait, _ := cmd.Flags().GetBool("wait")
+			timeout, _ := cmd.Flags().GetDuration("timeout")
+			skipPreflight, _ := cmd.Flags().GetBool("skip-preflight")
+			clientset, err := GetK8sClient()
+			if err != nil {
+				fmt.Println("Error creating Kubernetes client:", err)
+				return
+			}
+			if !skipPreflight {
+				if err := preflightRBAC(clientset, "addons-"+addon.Name+"-uninstall", addon.UninstallPermissions(addon.Namespace)); err != nil {
+					fmt.Println(err)
+					return
+				}
+			}
+			if err := uninstallAddon(newHelmClient(), clientset, addon, purgeCRDs, wait, timeout); err != nil {
+				fmt.Println(err)
+			}
+		},
+	}
+	uninstallCmd.Flags().Bool("purge-crds", false, "Also delete the CRDs, and with them every custom resource")
+	uninstallCmd.Flags().Bool("wait", false, "Wait until the resources are deleted")
+	uninstallCmd.Flags().Duration("timeout", 5*time.Minute, "How long to wait with --wait")
+	uninstallCmd.Flags().Bool("skip-preflight", false, "Skip the permission check")
+
+	statusCmd := &cobra.Command{
+		Use:   "status",
+		Short: fmt.Sprintf("Show the installed version and health of %s", addon.Name),
+		Run: func(cmd *cobra.Command, args []string) {
+			clientset, err := GetK8sClient()
+			if err != nil {
+				fmt.Println("Error creating Kubernetes client:", err)
+				return
+			}
+			if err := printAddonStatus(newHelmClient(), clientset, addon); err != nil {
+				fmt.Println(err)
+			}
+		},
+	}
+
+	addonCmd.AddCommand(installCmd, upgradeCmd, uninstallCmd, statusCmd)
+	return addonCmd
+}
+
+// addChartSourceFlags adds the flags for installing without access to the upstream repositories
+func addChartSourceFlags(c *cobra.Command) {
+	c.Flags().String("registry", "", "Install from an OCI registry mirror (e.g., oci://registry.internal/charts)")
+	c.Flags().Bool("embedded", false, "Install from the charts embedded in this binary")
+	c.Flags().Bool("plain-http", false, "Use plain HTTP for the OCI registry")
+	c.Flags().String("image-registry", "", "Pull every image from this mirror registry; pass it again on upgrade")
+}
+
+func chartSourceOptions(cmd *cobra.Command, opts *addonOptions) {
+	opts.Registry, _ = cmd.Flags().GetString("registry")
+	opts.Embedded, _ = cmd.Flags().GetBool("embedded")
+	opts.PlainHTTP, _ = cmd.Flags().GetBool("plain-http")
+	opts.ImageRegistry, _ = cmd.Flags().GetString("image-registry")
+}
+
+func runAddonCommand(cmd *cobra.Command, addon helmAddon, action func(*helmClient, *kubernetes.Clientset, helmAddon, addonOptions) error) {
+	var opts addonOptions
+	opts.Version, _ = cmd.Flags().GetString("version")
+	opts.ValuesFiles, _ = cmd.Flags().GetStringSlice("values")
+	opts.Set, _ = cmd.Flags().GetStringArray("set")
+	opts.Wait, _ = cmd.Flags().GetBool("wait")
+	opts.Timeout, _ = cmd.Flags().GetDuration("timeout")
+	opts.ChartPath, _ = cmd.Flags().GetString("chart-path")
+	chartSourceOptions(cmd, &opts)
+	skipPreflight, _ := cmd.Flags().GetBool("skip-preflight")
+
+	clientset, err := GetK8sClient()
+	if err != nil {
+		fmt.Println("Error creating Kubernetes client:", err)
+		return
+	}
+	if !skipPreflight {
+		if err := preflightRBAC(clientset, "addons-"+addon.Name+"-"+cmd.Name(), addon.InstallPermissions(addon.Namespace)); err != nil {
+			fmt.Println(err)
+			return
+		}
+	}
+	h := newHelmClient()
+	h.plainHTTP = opts.PlainHTTP
+	if err := action(h, clientset, addon, opts); err != nil {
+		fmt.Println(err)
+	}
+}
+
+// chartRef picks where the chart comes from: a local archive, an OCI mirror, the charts embedded
+// in the binary, or the upstream repository. cleanup removes any temporary file.
+func (a helmAddon) chartRef(h *helmClient, opts addonOptions) (string, func(), error) {
+	cleanup := func() {}
+	switch {
+	case opts.ChartPath != "":
+		if _, err := os.Stat(opts.ChartPath); err != nil {
+			return "", cleanup, fmt.Errorf("cannot read chart: %v", err)
+		}
+		return opts.ChartPath, cleanup, nil
+	case opts.Registry != "":
+		registry := strings.TrimSuffix(strings.TrimPrefix(opts.Registry, "oci://"), "/")
+		return "oci://" + registry + "/" + a.Chart, cleanup, nil
+	case opts.Embedded:
+		path, err := embeddedChart(a.Chart, opts.Version)
+		if err != nil {
+			return "", cleanup, err
+		}
+		return path, func() { os.Remove(path) }, nil
+	}
+	if err := h.addRepo(a.RepoName, a.RepoURL); err != nil {
+		return "", cleanup, fmt.Errorf("%v\nWithout internet access use --chart-path, --registry or --embedded", err)
+	}
+	return a.RepoName + "/" + a.Chart, cleanup, nil
+}
+
+func (a helmAddon) chartOptions(chart string, opts addonOptions) chartOptions {
+	return chartOptions{
+		Release:         a.Release,
+		Namespace:       a.Namespace,
+		Chart:           chart,
+		Version:         opts.Version,
+		ValuesFiles:     opts.ValuesFiles,
+		Set:             append(append([]string{}, a.Values...), opts.Set...),
+		Wait:            opts.Wait,
+		Timeout:         opts.Timeout,
+		CreateNamespace: true,
+		ImageRegistry:   opts.ImageRegistry,
+	}
+}
+
+// installAddon installs the chart unless the release already exists
+func installAddon(h *helmClient, clientset *kubernetes.Clientset, addon helmAddon, opts addonOptions) error {
+	release, err := h.getRelease(addon.Release, addon.Namespace)
+	if err != nil {
+		return err
+	}
+	if release != nil {
+		installed := release.ChartVersion
+		if opts.Version != "" && opts.Version != installed {
+			return fmt.Errorf("%s %s is already installed, run 'simplismart-cli addons %s upgrade --version %s' to change it", addon.Name, installed, addon.Name, opts.Version)
+		}
+		fmt.Printf("%s %s is already installed (status: %s)\n", addon.Name, installed, release.Status)
+		return nil
+	}
+	if addon.Check != nil {
+		if result := addon.Check(&doctorEnv{clientset: clientset}); result.Status == CheckPass {
+			fmt.Printf("%s is already provided by the cluster: %s\n", addon.Name, result.Message)
+			return nil
+		}
+	}
+
+	// CRDs not owned by a release make helm refuse the install
+	leftovers, err := leftoverCRDs(clientset, addon)
+	if err != nil {
+		return err
+	}
+	if len(leftovers) > 0 {
+		return fmt.Errorf("CRDs from an earlier %s install are left behind: %s\nRemove them with 'simplismart-cli addons %s uninstall --purge-crds'",
+			addon.Name, strings.Join(leftovers, ", "), addon.Name)
+	}
+
+	fmt.Printf("Installing %s...\n", addon.Name)
+	chartRef, cleanup, err := addon.chartRef(h, opts)
+	if err != nil {
+		return err
+	}
+	defer cleanup()
+	if err := h.install(addon.chartOptions(chartRef, opts)); err != nil {
+		return err
+	}
+	release, err = h.getRelease(addon.Release, addon.Namespace)
+	if err != nil || release == nil {
+		return fmt.Errorf("%s was installed but the release cannot be found: %v", addon.Name, err)
+	}
+	fmt.Printf("Installed %s %s (status: %s)\n", addon.Name, release.ChartVersion, release.Status)
+	return nil
+}
+
+func upgradeAddon(h *helmClient, clientset *kubernetes.Clientset, addon helmAddon, opts addonOptions) error {
+	release, err := h.getRelease(addon.Release, addon.Namespace)
+	if err != nil {
+		return err
+	}
+	if release == nil {
+		return fmt.Errorf("%s is not installed, run 'simplismart-cli addons %s install'", addon.Name, addon.Name)
+	}
+	from := release.ChartVersion
+	chartRef, cleanup, err := addon.chartRef(h, opts)
+	if err != nil {
+		return err
+	}
+	defer cleanup()
+	if err := h.upgrade(addon.chartOptions(chartRef, opts)); err != nil {
+		return err
+	}
+	release, err = h.getRelease(addon.Release, addon.Namespace)
+	if err != nil || release == nil {
+		return fmt.Errorf("%s was upgraded but the release cannot be found: %v", addon.Name, err)
+	}
+	fmt.Printf("Upgraded %s from %s to %s (status: %s)\n", addon.Name, from, release.ChartVersion, release.Status)
+	return nil
+}
+
+func uninstallAddon(h *helmClient, clientset *kubernetes.Clientset, addon helmAddon, purgeCRDs, wait bool, timeout time.Duration) error {
+	release, err := h.getRelease(addon.Release, addon.Namespace)
+	if err != nil {
+		return err
+	}
+	if release == nil {
+		fmt.Printf("%s is not installed\n", addon.Name)
+	} else {
+		if err := h.uninstall(addon.Release, addon.Namespace, wait, timeout); err != nil {
+			return err
+		}
+		fmt.Printf("Uninstalled %s %s\n", addon.Name, release.ChartVersion)
+	}
+
+	remaining, err := existingCRDs(clientset, addon)
+	if err != nil {
+		return err
+	}
+	if len(remaining) == 0 {
+		return nil
+	}
+	if !purgeCRDs {
+		fmt.Printf("CRDs left behind: %s\nRun again with --purge-crds to delete them and all their resources\n", strings.Join(remaining, ", "))
+		return nil
+	}
+	for _, name := range remaining {
+		_, err := clientset.RESTClient().Delete().AbsPath("/apis/apiextensions.k8s.io/v1/customresourcedefinitions", name).DoRaw(context.TODO())
+		if err != nil {
+			return fmt.Errorf("failed to delete CRD %s: %v", name, err)
+		}
+		fmt.Printf("Deleted CRD %s\n", name)
+	}
+	return nil
+}
+
+func printAddonStatus(h *helmClient, clientset *kubernetes.Clientset, addon helmAddon) error {
+	release, err := h.getRelease(addon.Release, addon.Namespace)
+	if err != nil {
+		return err
+	}
+	var health *checkResult
+	if addon.Check != nil {
+		result := addon.Check(&doctorEnv{clientset: clientset})
+		result.Name = "health"
+		health = &result
+	}
+	if release == nil && health != nil && health.Status == CheckPass {
+		fmt.Printf("%s: provided by the cluster\n", addon.Name)
+	} else if release == nil {
+		fmt.Printf("%s: not installed\n", addon.Name)
+	} else {
+		fmt.Printf("%s: chart %s, app version %s, revision %d, status %s\n", addon.Name, release.ChartVersion, release.AppVersion, release.Revision, release.Status)
+		if len(release.Images) > 0 {
+			fmt.Printf("Images: %s\n", strings.Join(release.Images, ", "))
+		}
+	}
+
+	crds, err := existingCRDs(clientset, addon)
+	if err != nil {
+		return err
+	}
+	if len(crds) > 0 {
+		label := "CRDs"
+		if release == nil {
+			label = "CRDs left behind"
+		}
+		fmt.Printf("%s: %s\n", label, strings.Join(crds, ", "))
+	}
+
+	for _, name := range addon.Workloads {
+		w, pods, err := getWorkloadPods(clientset, addon.Namespace, KindDeployment, name, false)
+		if err != nil {
+			if release != nil {
+				fmt.Printf("Deployment %s: %v\n", name, err)
+			}
+			continue
+		}
+		ready := 0
+		for _, pod := range pods {
+			for _, c := range pod.Status.Conditions {
+				if c.Type == "Ready" && c.Status == "True" {
+					ready++
+				}
+			}
+		}
+		fmt.Printf("Deployment %s: %d/%d available, %d/%d pods ready\n", w.Name, w.Available, w.Desired, ready, len(pods))
+	}
+	if health != nil {
+		printCheckResults([]checkResult{*health})
+	}
+	return nil
+}
+
+// existingCRDs returns the addon's CRDs present in the cluster
+func existingCRDs(clientset *kubernetes.Clientset, addon helmAddon) ([]string, error) {
+	var found []string
+	for _, name := range addon.CRDs {
+		_, exists, err := crdReleaseOwner(clientset, name)
+		if err != nil {
+			return nil, err
+		}
+		if exists {
+			found = append(found, name)
+		}
+	}
+	return found, nil
+}
+
+// leftoverCRDs returns the addon's CRDs that exist without belonging to its Helm release
+func leftoverCRDs(clientset *kubernetes.Clientset, addon helmAddon) ([]string, error) {
+	var leftovers []string
+	for _, name := range addon.CRDs {
+		owner, exists, err := crdReleaseOwner(clientset, name)
+		if err != nil {
+			return nil, err
+		}
+		if exists && owner != addon.Release {
+			leftovers = append(leftovers, name)
+		}
+	}
+	return leftovers, nil
+}
+
+// crdReleaseOwner reads the Helm release a CRD belongs to from its annotations
+func crdReleaseOwner(clientset *kubernetes.Clientset, name string) (string, bool, error) {
+	data, err := clientset.RESTClient().Get().AbsPath("/apis/apiextensions.k8s.io/v1/customresourcedefinitions", name).DoRaw(context.TODO())
+	if err != nil {
+		if k8serrors.IsNotFound(err) {
+			return "", false, nil
+		}
+		return "", false, fmt.Errorf("failed to get CRD %s: %v", name, err)
+	}
+	var crd struct {
+		Metadata metav1.ObjectMeta `json:"metadata"`
+	}
+	if err := json.Unmarshal(data, &crd); err != nil {
+		return "", true, fmt.Errorf("cannot parse CRD %s: %v", name, err)
+	}
+	return crd.Metadata.Annotations["meta.helm.sh/release-name"], true, nil
+}
+
+func init() {
+	AddonsInstallCmd.Flags().Bool("wait", false, "Wait until the addons are ready")
+	AddonsInstallCmd.Flags().Duration("timeout", 5*time.Minute, "How long to wait for each addon")
+	AddonsInstallCmd.Flags().Bool("skip-preflight", false, "Skip the permission check")
+	addChartSourceFlags(AddonsInstallCmd)
+	AddonsInstallCmd.MarkFlagsMutuallyExclusive("registry", "embedded")
+
+	AddonsCmd.AddCommand(AddonsListCmd, AddonsInstallCmd, AddonsStatusCmd)
+	for _, addon := range knownAddons {
+		AddonsCmd.AddCommand(newAddonCmd(addon, addon.Description))
+	}
+}
@@ -0,0 +1,331 @@
+package main
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"embed"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/Masterminds/semver/v3"
+	"github.com/spf13/cobra"
+	"helm.sh/helm/v3/pkg/release"
+	"sigs.k8s.io/yaml"
+)
+
+// Chart archives placed in charts/ before building are compiled into the binary
+//
+//go:embed charts
+var embeddedCharts embed.FS
+
+// embeddedChart writes the embedded archive of the chart to a temporary file. Without a version
+// the newest one is used.
+func embeddedChart(name, version string) (string, error) {
+	entries, err := fs.ReadDir(embeddedCharts, "charts")
+	if err != nil {
+		return "", err
+	}
+	var best *semver.Version
+	var bestFile string
+	for _, entry := range entries {
+		v, ok := strings.CutPrefix(entry.Name(), name+"-")
+		if !ok {
+			continue
+		}
+		v, ok = strings.CutSuffix(v, ".tgz")
+		if !ok {
+			continue
+		}
+		sv, err := semver.NewVersion(v)
+		if err != nil {
+			continue
+		}
+		if version != "" && v != strings.TrimPrefix(version, "v") {
+			continue
+		}
+		if best == nil || sv.GreaterThan(best) {
+			best, bestFile = sv, entry.Name()
+		}
+	}
+	if bestFile == "" {
+		if version != "" {
+			return "", fmt.Errorf("chart %s %s is not embedded in this build", name, version)
+		}
+		return "", fmt.Errorf("chart %s is not embedded in this build", name)
+	}
+
+	data, err := embeddedCharts.ReadFile("charts/" + bestFile)
+	if err != nil {
+		return "", err
+	}
+	f, err := os.CreateTemp("", name+"-*.tgz")
+	if err != nil {
+		return "", fmt.Errorf("failed to extract embedded chart: %v", err)
+	}
+	defer f.Close()
+	if _, err := f.Write(data); err != nil {
+		os.Remove(f.Name())
+		return "", fmt.Errorf("failed to extract embedded chart: %v", err)
+	}
+	return f.Name(), nil
+}
+
+// imageRewriter is a Helm post-renderer pointing every container image at a mirror registry
+type imageRewriter struct {
+	registry string
+}
+
+func (r imageRewriter) Run(manifests *bytes.Buffer) (*bytes.Buffer, error) {
+	docs, err := splitManifests(manifests.String())
+	if err != nil {
+		return nil, err
+	}
+	out := new(bytes.Buffer)
+	for _, doc := range docs {
+		walkImages(doc, func(image string) string {
+			return rewriteImage(image, r.registry)
+		})
+		data, err := yaml.Marshal(doc)
+		if err != nil {
+			return nil, err
+		}
+		out.WriteString("---\n")
+		out.Write(data)
+	}
+	return out, nil
+}
+
+var documentSeparator = regexp.MustCompile(`(?m)^---\s*$`)
+
+// splitManifests parses a multi-document YAML stream, skipping empty documents
+func splitManifests(manifests string) ([]map[string]interface{}, error) {
+	var docs []map[string]interface{}
+	for _, part := range documentSeparator.Split(manifests, -1) {
+		var doc map[string]interface{}
+		if err := yaml.Unmarshal([]byte(part), &doc); err != nil {
+			return nil, fmt.Errorf("cannot parse rendered manifest: %v", err)
+		}
+		if len(doc) > 0 {
+			docs = append(docs, doc)
+		}
+	}
+	return docs, nil
+}
+
+// walkImages calls fn for the image of every container, init container and ephemeral
+// container in the object, replacing the image with its result
+func walkImages(node interface{}, fn func(string) string) {
+	switch n := node.(type) {
+	case map[string]interface{}:
+		for key, value := range n {
+			if key == "containers" || key == "initContainers" || key == "ephemeralContainers" {
+				if containers, ok := value.([]interface{}); ok {
+					for _, c := range containers {
+						if container, ok := c.(map[string]interface{}); ok {
+							if image, ok := container["image"].(string); ok && image != "" {
+								container["image"] = fn(image)
+							}
+						}
+					}
+				}
+			}
+			walkImages(value, fn)
+		}
+	case []interface{}:
+		for _, item := range n {
+			walkImages(item, fn)
+		}
+	}
+}
+
+// rewriteImage moves an image to the mirror registry, keeping its repository path and tag.
+// Docker Hub images get the "library/" prefix the hub implies.
+func rewriteImage(image, registry string) string {
+	registry = strings.TrimSuffix(registry, "/")
+	if strings.HasPrefix(image, registry+"/") {
+		return image
+	}
+	path := image
+	if host, rest, ok := strings.Cut(image, "/"); ok {
+		if strings.ContainsAny(host, ".:") || host == "localhost" {
+			path = rest
+		}
+	} else {
+		path = "library/" + image
+	}
+	return registry + "/" + path
+}
+
+// manifestImages returns the sorted, distinct images of a rendered manifest
+func manifestImages(manifests ...string) ([]string, error) {
+	seen := map[string]bool{}
+	for _, manifest := range manifests {
+		docs, err := splitManifests(manifest)
+		if err != nil {
+			return nil, err
+		}
+		for _, doc := range docs {
+			walkImages(doc, func(image string) string {
+				seen[image] = true
+				return image
+			})
+		}
+	}
+	images := make([]string, 0, len(seen))
+	for image := range seen {
+		images = append(images, image)
+	}
+	sort.Strings(images)
+	return images, nil
+}
+
+// releaseImages returns the images of a release, hooks included
+func releaseImages(rel *release.Release) []string {
+	manifests := []string{rel.Manifest}
+	for _, hook := range rel.Hooks {
+		manifests = append(manifests, hook.Manifest)
+	}
+	images, _ := manifestImages(manifests...)
+	return images
+}
+
+var AddonsBundleCmd = &cobra.Command{
+	Use:   "bundle [addon...]",
+	Short: "Package addon charts and their images for an air-gapped cluster",
+	Long: `Download the charts of the given addons (all of them by default) and write them to a
+tar.gz archive together with images.txt, the images they pull. Copy the images to your
+mirror registry, move the archive into the air-gapped environment and install with:
+
+  simplismart-cli addons keda install --chart-path charts/keda-<version>.tgz --image-registry <mirror>
+
+Chart archives copied into the charts/ directory of the source tree are embedded into
+the binary at build time and can be installed with --embedded.`,
+	Run: func(cmd *cobra.Command, args []string) {
+		output, _ := cmd.Flags().GetString("output")
+		var opts addonOptions
+		opts.Version, _ = cmd.Flags().GetString("version")
+		opts.ValuesFiles, _ = cmd.Flags().GetStringSlice("values")
+		opts.Set, _ = cmd.Flags().GetStringArray("set")
+		opts.Registry, _ = cmd.Flags().GetString("registry")
+		opts.PlainHTTP, _ = cmd.Flags().GetBool("plain-http")
+		mirror, _ := cmd.Flags().GetString("image-registry")
+
+		addons := knownAddons
+		if len(args) > 0 {
+			addons = nil
+			for _, name := range args {
+				addon, ok := addonByName(name)
+				if !ok {
+					fmt.Printf("Unknown addon %q\n", name)
+					return
+				}
+				addons = append(addons, addon)
+			}
+		}
+		if opts.Version != "" && len(addons) != 1 {
+			fmt.Println("--version needs exactly one addon")
+			return
+		}
+
+		h := newHelmClient()
+		h.plainHTTP = opts.PlainHTTP
+		if err := bundleAddons(h, addons, opts, mirror, output); err != nil {
+			fmt.Println(err)
+			os.Remove(output)
+			return
+		}
+	},
+}
+
+// bundleAddons writes charts/<chart>-<version>.tgz for every addon and images.txt to a tar.gz.
+// With a mirror, images.txt maps every image to its name in the mirror.
+func bundleAddons(h *helmClient, addons []helmAddon, opts addonOptions, mirror, output string) error {
+	f, err := os.Create(output)
+	if err != nil {
+		return fmt.Errorf("failed to create %s: %v", output, err)
+	}
+	defer f.Close()
+	gz := gzip.NewWriter(f)
+	tw := tar.NewWriter(gz)
+
+	var images []string
+	seen := map[string]bool{}
+	for _, addon := range addons {
+		fmt.Printf("Bundling %s...\n", addon.Name)
+		chartRef, cleanup, err := addon.chartRef(h, opts)
+		if err != nil {
+			return err
+		}
+		rel, path, err := h.template(addon.chartOptions(chartRef, opts))
+		cleanup()
+		if err != nil {
+			return err
+		}
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return fmt.Errorf("failed to read chart %s: %v", path, err)
+		}
+		name := fmt.Sprintf("charts/%s-%s.tgz", rel.Chart.Metadata.Name, rel.Chart.Metadata.Version)
+		if err := addToTar(tw, name, data); err != nil {
+			return err
+		}
+		addonImages := releaseImages(rel)
+		fmt.Printf("\t%s (%d images)\n", name, len(addonImages))
+		for _, image := range addonImages {
+			if !seen[image] {
+				seen[image] = true
+				images = append(images, image)
+			}
+		}
+	}
+
+	var list strings.Builder
+	for _, image := range images {
+		if mirror != "" {
+			fmt.Fprintf(&list, "%s %s\n", image, rewriteImage(image, mirror))
+		} else {
+			fmt.Fprintln(&list, image)
+		}
+	}
+	if err := addToTar(tw, "images.txt", []byte(list.String())); err != nil {
+		return err
+	}
+	if err := tw.Close(); err != nil {
+		return fmt.Errorf("failed to write %s: %v", output, err)
+	}
+	if err := gz.Close(); err != nil {
+		return fmt.Errorf("failed to write %s: %v", output, err)
+	}
+	fmt.Printf("Wrote %s\n", output)
+	fmt.Print(list.String())
+	return nil
+}
+
+func addToTar(tw *tar.Writer, name string, data []byte) error {
+	header := &tar.Header{Name: name, Mode: 0644, Size: int64(len(data)), ModTime: time.Now()}
+	if err := tw.WriteHeader(header); err != nil {
+		return fmt.Errorf("failed to write %s: %v", name, err)
+	}
+	if _, err := io.Copy(tw, bytes.NewReader(data)); err != nil {
+		return fmt.Errorf("failed to write %s: %v", name, err)
+	}
+	return nil
+}
+
+func init() {
+	AddonsBundleCmd.Flags().StringP("output", "o", "simplismart-addons.tar.gz", "Archive to write")
+	AddonsBundleCmd.Flags().String("version", "", "Chart version (defaults to the latest)")
+	AddonsBundleCmd.Flags().StringSliceP("values", "f", []string{}, "Values files used to render the image list")
+	AddonsBundleCmd.Flags().StringArray("set", []string{}, "Chart values used to render the image list")
+	AddonsBundleCmd.Flags().String("registry", "", "OCI registry to pull the charts from instead of the upstream repositories")
+	AddonsBundleCmd.Flags().Bool("plain-http", false, "Use plain HTTP for the OCI registry")
+	AddonsBundleCmd.Flags().String("image-registry", "", "Mirror registry; images.txt then lists the source and mirror name of each image")
+	AddonsCmd.AddCommand(AddonsBundleCmd)
+}
@@ -0,0 +1,345 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"math"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/spf13/cobra"
+	k8serrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+)
+
+// KEDA metric types of a trigger. AverageValue divides the query result by the replicas, so
+// queries sum over pods; Value compares it as is, so queries average over pods.
+const (
+	MetricAverageValue = "AverageValue"
+	MetricValue        = "Value"
+)
+
+// A scalingTrigger is one prometheus trigger of the ScaledObject
+type scalingTrigger struct {
+	Name                string
+	Query               string
+	Threshold           string
+	ActivationThreshold string
+	MetricType          string
+}
+
+// A scalingProfile generates a trigger for a deployment. {app} and {namespace} in the query are
+// replaced with the deployment's name and namespace.
+type scalingProfile struct {
+	Name        string
+	Description string
+	Query       string
+	Threshold   string
+	MetricType  string
+}
+
+var scalingProfiles = []scalingProfile{
+	{"latency", "Average request latency in seconds of apps exporting http_request_duration_seconds",
+		`avg(rate(http_request_duration_seconds_sum{app="{app}"}[5m])/rate(http_request_duration_seconds_count{app="{app}"}[5m]))`, "0.5", MetricAverageValue},
+	{"vllm-queue", "vLLM requests waiting for a batch slot, per replica",
+		`sum(vllm:num_requests_waiting{app="{app}"})`, "5", MetricAverageValue},
+	{"vllm-kv-cache", "vLLM GPU KV-cache usage (0-1), per replica",
+		`sum(vllm:gpu_cache_usage_perc{app="{app}"})`, "0.8", MetricAverageValue},
+	{"vllm-tokens", "vLLM generated tokens per second, per replica",
+		`sum(rate(vllm:generation_tokens_total{app="{app}"}[1m]))`, "500", MetricAverageValue},
+	{"triton-queue", "Triton average queue time per request in microseconds",
+		`sum(rate(nv_inference_queue_duration_us{app="{app}"}[1m])) / clamp_min(sum(rate(nv_inference_request_success{app="{app}"}[1m])), 1)`, "50000", MetricValue},
+	{"tgi-queue", "TGI requests waiting in the queue, per replica",
+		`sum(tgi_queue_size{app="{app}"})`, "10", MetricAverageValue},
+	{"tgi-tokens", "TGI generated tokens per second, per replica",
+		`sum(rate(tgi_request_generated_tokens_sum{app="{app}"}[1m]))`, "500", MetricAverageValue},
+	{"torchserve-requests", "TorchServe inference requests per second, per replica",
+		`sum(rate(ts_inference_requests_total{app="{app}"}[2m]))`, "20", MetricAverageValue},
+	// dcgm-exporter labels the GPU's pod, renamed exported_pod when Prometheus scrapes it
+	{"gpu-utilization", "Average DCGM GPU utilization in percent of the deployment's GPUs",
+		`avg(DCGM_FI_DEV_GPU_UTIL{exported_namespace="{namespace}",exported_pod=~"{app}-.*"} or DCGM_FI_DEV_GPU_UTIL{namespace="{namespace}",pod=~"{app}-.*"})`, "80", MetricValue},
+}
+
+func findScalingProfile(name string) (scalingProfile, bool) {
+	for _, p := range scalingProfiles {
+		if p.Name == name {
+			return p, true
+		}
+	}
+	return scalingProfile{}, false
+}
+
+func (p scalingProfile) trigger(app, namespace string) scalingTrigger {
+	query := strings.NewReplacer("{app}", app, "{namespace}", namespace).Replace(p.Query)
+	t := scalingTrigger{Name: p.Name, Query: query, Threshold: p.Threshold, MetricType: p.MetricType}
+	// Kept from before profiles existed
+	if p.Name == "latency" {
+		t.ActivationThreshold = "0.4"
+	}
+	return t
+}
+
+// validateScaleOn checks a comma separated list of PROFILE[=THRESHOLD]
+func validateScaleOn(v string) error {
+	_, err := scalingTriggers(strings.Split(v, ","), "", "")
+	return err
+}
+
+// scalingTriggers builds the triggers of PROFILE[=THRESHOLD] values
+func scalingTriggers(scaleOn []string, app, namespace string) ([]scalingTrigger, error) {
+	var triggers []scalingTrigger
+	for _, v := range scaleOn {
+		name, threshold, hasThreshold := strings.Cut(v, "=")
+		p, ok := findScalingProfile(name)
+		if !ok {
+			return nil, fmt.Errorf("unknown autoscaling profile %q, run 'simplismart-cli autoscale profiles'", name)
+		}
+		t := p.trigger(app, namespace)
+		if hasThreshold {
+			if _, err := strconv.ParseFloat(threshold, 64); err != nil {
+				return nil, fmt.Errorf("invalid threshold %q for %s", threshold, name)
+			}
+			t.Threshold = threshold
+		}
+		triggers = append(triggers, t)
+	}
+	return triggers, nil
+}
+
+// resolveTriggers picks the prometheus triggers of a deployment from --scale-on and
+// --scale-query, the template's profiles, autoscaling.scaleOn, or the request latency
+func resolveTriggers(cmd *cobra.Command, app, namespace string, templateScaleOn []string) ([]scalingTrigger, error) {
+	scaleOn, _ := cmd.Flags().GetStringSlice("scale-on")
+	query, _ := cmd.Flags().GetString("scale-query")
+	threshold, _ := cmd.Flags().GetString("scale-threshold")
+	if (query == "") != (threshold == "") {
+		return nil, fmt.Errorf("--scale-query and --scale-threshold go together")
+	}
+	if len(scaleOn) == 0 && query == "" {
+		scaleOn = templateScaleOn
+		if len(scaleOn) == 0 {
+			if v, _ := configValue("autoscaling.scaleOn"); v != "" {
+				scaleOn = strings.Split(v, ",")
+			} else {
+				scaleOn = []string{"latency"}
+			}
+		}
+	}
+	triggers, err := scalingTriggers(scaleOn, app, namespace)
+	if err != nil {
+		return nil, err
+	}
+	if query != "" {
+		if _, err := strconv.ParseFloat(threshold, 64); err != nil {
+			return nil, fmt.Errorf("invalid --scale-threshold %q", threshold)
+		}
+		triggers = append(triggers, scalingTrigger{Name: "custom", Query: query, Threshold: threshold, MetricType: MetricAverageValue})
+	}
+	return triggers, nil
+}
+
+// prometheusService splits an in-cluster Prometheus address into the service the API server
+// can proxy to
+func prometheusService(address string) (service, namespace, port string, err error) {
+	u, err := url.Parse(address)
+	if err != nil {
+		return "", "", "", err
+	}
+	// <service>.<namespace>.svc.cluster.local
+	parts := strings.Split(u.Hostname(), ".")
+	if len(parts) < 2 {
+		return "", "", "", fmt.Errorf("%s is not an in-cluster service address", address)
+	}
+	port = u.Port()
+	if port == "" {
+		port = "80"
+	}
+	return parts[0], parts[1], port, nil
+}
+
+// queryPrometheus runs an instant query through the API server's service proxy and returns
+// the first sample, false when the query has no result
+func queryPrometheus(clientset *kubernetes.Clientset, address, query string) (float64, bool, error) {
+	service, namespace, port, err := prometheusService(address)
+	if err != nil {
+		return 0, false, err
+	}
+	data, err := clientset.CoreV1().Services(namespace).ProxyGet("http", service, port, "/api/v1/query", map[string]string{"query": query}).DoRaw(context.TODO())
+	if err != nil {
+		return 0, false, fmt.Errorf("failed to query Prometheus: %v", err)
+	}
+	var response struct {
+		Status string `json:"status"`
+		Error  string `json:"error"`
+		Data   struct {
+			Result []struct {
+				Value [2]interface{} `json:"value"`
+			} `json:"result"`
+		} `json:"data"`
+	}
+	if err := json.Unmarshal(data, &response); err != nil {
+		return 0, false, fmt.Errorf("failed to parse the Prometheus response: %v", err)
+	}
+	if response.Status != "success" {
+		return 0, false, fmt.Errorf("prometheus query failed: %s", response.Error)
+	}
+	if len(response.Data.Result) == 0 {
+		return 0, false, nil
+	}
+	s, _ := response.Data.Result[0].Value[1].(string)
+	value, err := strconv.ParseFloat(s, 64)
+	if err != nil {
+		return 0, false, fmt.Errorf("unexpected sample %q", s)
+	}
+	return value, true, nil
+}
+
+// desiredReplicas is what the HPA asks for on one trigger, before min and max apply
+func desiredReplicas(t scalingTrigger, value float64, current int) (int, error) {
+	threshold, err := strconv.ParseFloat(t.Threshold, 64)
+	if err != nil || threshold <= 0 {
+		return 0, fmt.Errorf("invalid threshold %q", t.Threshold)
+	}
+	if t.MetricType == MetricValue {
+		return int(math.Ceil(float64(current) * value / threshold)), nil
+	}
+	return int(math.Ceil(value / threshold)), nil
+}
+
+var AutoscaleCmd = &cobra.Command{
+	Use:   "autoscale",
+	Short: "Inspect the autoscaling of deployments",
+}
+
+var AutoscaleProfilesCmd = &cobra.Command{
+	Use:   "profiles",
+	Short: "List the autoscaling profiles for --scale-on",
+	Run: func(cmd *cobra.Command, args []string) {
+		tw := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+		fmt.Fprintln(tw, "NAME\tTHRESHOLD\tMETRIC TYPE\tDESCRIPTION")
+		for _, p := range scalingProfiles {
+			fmt.Fprintf(tw, "%s\t%s\t%s\t%s\n", p.Name, p.Threshold, p.MetricType, p.Description)
+		}
+		tw.Flush()
+	},
+}
+
+var AutoscaleExplainCmd = &cobra.Command{
+	Use:   "explain",
+	Short: "Print the queries a deployment scales on, their current values and the replicas they ask for",
+	Long: `Print the Prometheus queries of a deployment's ScaledObject, their current values and
+the replicas each asks for, to tune thresholds. With --scale-on, profiles are evaluated
+against the live metrics before being applied.`,
+	Run: func(cmd *cobra.Command, args []string) {
+		name, _ := cmd.Flags().GetString("name")
+		namespace, _ := cmd.Flags().GetString("namespace")
+		if namespace == "" {
+			namespace = contextNamespace()
+		}
+		scaleOn, _ := cmd.Flags().GetStringSlice("scale-on")
+		address := flagOrConfig(cmd, "prometheus-address", "autoscaling.prometheusAddress")
+
+		clientset, err := GetK8sClient()
+		if err != nil {
+			fmt.Println(err)
+			return
+		}
+		var triggers []scalingTrigger
+		var others []string
+		min, max := 0, 0
+		if len(scaleOn) > 0 {
+			if triggers, err = scalingTriggers(scaleOn, name, namespace); err != nil {
+				fmt.Println(err)
+				return
+			}
+		} else {
+			so, err := getScaledObjectState(clientset, namespace, name)
+			if k8serrors.IsNotFound(err) {
+				fmt.Printf("failed to get ScaledObject %s: %v\n", name, err)
+				return
+			}
+			if err != nil {
+				fmt.Println(err)
+				return
+			}
+			triggers, others, min, max = so.Triggers, so.Others, so.MinReplicas, so.MaxReplicas
+		}
+		// A blue/green deployment scales the color serving traffic
+		target, err := liveWorkload(clientset, namespace, KindDeployment, name)
+		if err != nil {
+			fmt.Println(err)
+			return
+		}
+		current := 0
+		if deployment, err := clientset.AppsV1().Deployments(namespace).Get(context.TODO(), target, metav1.GetOptions{}); err == nil && deployment.Spec.Replicas != nil {
+			current = int(*deployment.Spec.Replicas)
+		}
+		if max > 0 {
+			fmt.Printf("ScaledObject %s: %d replicas of %s, min %d, max %d\n", name, current, target, min, max)
+		} else {
+			fmt.Printf("Deployment %s: %d replicas\n", target, current)
+		}
+		for _, o := range others {
+			fmt.Printf("Also scales on %s utilization, see 'health-status' for current usage\n", o)
+		}
+		fmt.Println()
+
+		tw := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+		fmt.Fprintln(tw, "TRIGGER\tMETRIC TYPE\tTHRESHOLD\tVALUE\tREPLICAS")
+		var queries []string
+		wanted := 0
+		for _, t := range triggers {
+			value, replicas := "-", "-"
+			v, ok, err := queryPrometheus(clientset, address, t.Query)
+			switch {
+			case err != nil:
+				value = "error"
+				queries = append(queries, fmt.Sprintf("  error: %v", err))
+			case !ok:
+				value = "no data"
+			default:
+				value = strconv.FormatFloat(v, 'g', 4, 64)
+				n, err := desiredReplicas(t, v, current)
+				if err != nil {
+					replicas = err.Error()
+				} else {
+					replicas = strconv.Itoa(n)
+					if n > wanted {
+						wanted = n
+					}
+				}
+			}
+			fmt.Fprintf(tw, "%s\t%s\t%s\t%s\t%s\n", t.Name, t.MetricType, t.Threshold, value, replicas)
+			queries = append(queries, fmt.Sprintf("%s: %s", t.Name, t.Query))
+		}
+		tw.Flush()
+		if max > 0 && len(triggers) > 0 {
+			clamped := wanted
+			if clamped < min {
+				clamped = min
+			}
+			if clamped > max {
+				clamped = max
+			}
+			fmt.Printf("\nThe highest trigger asks for %d replicas, %d within min and max\n", wanted, clamped)
+		}
+		fmt.Println("\nQueries:")
+		for _, q := range queries {
+			fmt.Println(" ", q)
+		}
+	},
+}
+
+func init() {
+	AutoscaleExplainCmd.Flags().String("name", "", "Name of the deployment")
+	AutoscaleExplainCmd.Flags().String("namespace", "", "Namespace of the deployment (defaults to the context's namespace)")
+	AutoscaleExplainCmd.Flags().StringSlice("scale-on", nil, "Evaluate these autoscaling profiles, as PROFILE[=THRESHOLD], instead of the ScaledObject's triggers")
+	AutoscaleExplainCmd.Flags().String("prometheus-address", "", "Prometheus to query (config autoscaling.prometheusAddress)")
+	AutoscaleExplainCmd.MarkFlagRequired("name")
+	AutoscaleCmd.AddCommand(AutoscaleProfilesCmd, AutoscaleExplainCmd)
+}
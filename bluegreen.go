@@ -0,0 +1,500 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"os/signal"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/spf13/cobra"
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	k8serrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/kubernetes"
+)
+
+// Rollout strategies of create-deployment
+const (
+	StrategyRolling   = "rolling"
+	StrategyBlueGreen = "blue-green"
+)
+
+// Pod label the service selects on to send traffic to one color
+const colorLabel = "color"
+
+const (
+	ColorBlue  = "blue"
+	ColorGreen = "green"
+)
+
+// Annotation on the idle color with the time after which the next create-deployment, switch,
+// scale, restart or health-status of the deployment scales it to zero
+const retireAfterAnnotation = "simplismart.io/retire-after"
+
+// A blueGreenRollout runs two colors of a deployment, <name> being blue and <name>-green, of
+// which the service selects one. Blue keeps the selector app=<name> it was created with, which
+// cannot change and also matches the green pods, so while both run an autoscaler on blue
+// averages CPU and memory over the green pods too. Green's selector includes its color.
+type blueGreenRollout struct {
+	clientset *kubernetes.Clientset
+	namespace string
+	name      string
+}
+
+// colorDeployment is the deployment running the color
+func (b *blueGreenRollout) colorDeployment(color string) string {
+	if color == ColorBlue {
+		return b.name
+	}
+	return b.name + "-" + color
+}
+
+func otherColor(color string) string {
+	if color == ColorBlue {
+		return ColorGreen
+	}
+	return ColorBlue
+}
+
+func validateColor(color string) error {
+	if color != ColorBlue && color != ColorGreen {
+		return fmt.Errorf("invalid color %q, expected blue or green", color)
+	}
+	return nil
+}
+
+// liveColor is the color the service selects, blue before the first switch
+func (b *blueGreenRollout) liveColor() (string, *corev1.Service, error) {
+	service, err := b.clientset.CoreV1().Services(b.namespace).Get(context.TODO(), serviceName(b.name), metav1.GetOptions{})
+	if err != nil {
+		return "", nil, fmt.Errorf("failed to get service %s: %v", serviceName(b.name), err)
+	}
+	if color := service.Spec.Selector[colorLabel]; color != "" {
+		return color, service, nil
+	}
+	return ColorBlue, service, nil
+}
+
+// serviceColor is the color the service of the deployment selects, empty when it has no
+// service or no color
+func serviceColor(clientset *kubernetes.Clientset, namespace, name string) (string, error) {
+	service, err := clientset.CoreV1().Services(namespace).Get(context.TODO(), serviceName(name), metav1.GetOptions{})
+	if k8serrors.IsNotFound(err) {
+		return "", nil
+	}
+	if err != nil {
+		return "", fmt.Errorf("failed to get service %s: %v", serviceName(name), err)
+	}
+	return service.Spec.Selector[colorLabel], nil
+}
+
+// liveWorkload resolves --name to the workload serving traffic, the live color of a blue/green
+// deployment and the workload of that name otherwise
+func liveWorkload(clientset *kubernetes.Clientset, namespace, kind, name string) (string, error) {
+	if kind, err := normalizeKind(kind); err != nil || kind != KindDeployment {
+		return name, nil
+	}
+	color, err := serviceColor(clientset, namespace, name)
+	if err != nil || color == "" {
+		return name, err
+	}
+	return (&blueGreenRollout{name: name}).colorDeployment(color), nil
+}
+
+// deploy runs the template as the color the service does not select and waits until it is
+// ready, returning that color and the live one, empty for a new deployment. A deployment
+// without colors yet is labeled blue first, and the service pinned to it so the new pods get
+// no traffic before the switch.
+func (b *blueGreenRollout) deploy(ctx context.Context, template corev1.PodTemplateSpec, removed, removedVolumes []string, labels map[string]string) (string, string, error) {
+	blue, err := b.clientset.AppsV1().Deployments(b.namespace).Get(context.TODO(), b.name, metav1.GetOptions{})
+	if k8serrors.IsNotFound(err) {
+		fmt.Printf("Deployment %s does not exist yet, creating it as %s\n", b.name, ColorBlue)
+		createDeployment(b.name, b.namespace, template, removed, removedVolumes, labels, b.clientset)
+		if err := b.markBlue(ctx); err != nil {
+			return "", "", err
+		}
+		return ColorBlue, "", waitForRollout(ctx, b.clientset, b.namespace, b.name)
+	}
+	if err != nil {
+		return "", "", fmt.Errorf("failed to get deployment %s: %v", b.name, err)
+	}
+	if blue.Spec.Template.Labels[colorLabel] == "" {
+		if err := b.markBlue(ctx); err != nil {
+			return "", "", err
+		}
+	}
+	live, service, err := b.liveColor()
+	if err != nil {
+		return "", "", err
+	}
+	if service.Spec.Selector[colorLabel] == "" {
+		if err := b.selectColor(service, live); err != nil {
+			return "", "", err
+		}
+	}
+
+	current, err := b.clientset.AppsV1().Deployments(b.namespace).Get(context.TODO(), b.colorDeployment(live), metav1.GetOptions{})
+	if err != nil {
+		return "", "", fmt.Errorf("failed to get deployment %s: %v", b.colorDeployment(live), err)
+	}
+	color := otherColor(live)
+	colorLabels := map[string]string{"app": b.name, colorLabel: color}
+	next := current.Spec.Template.DeepCopy()
+	mergeTemplate(next, template, removed, removedVolumes, labels)
+	next.Labels = withLabels(colorLabels, next.Labels)
+
+	name := b.colorDeployment(color)
+	existing, err := b.clientset.AppsV1().Deployments(b.namespace).Get(context.TODO(), name, metav1.GetOptions{})
+	switch {
+	case k8serrors.IsNotFound(err):
+		deployment := &appsv1.Deployment{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:      name,
+				Namespace: b.namespace,
+				Labels:    withLabels(map[string]string{"app": b.name}, current.Labels),
+			},
+			Spec: appsv1.DeploymentSpec{
+				Replicas: current.Spec.Replicas,
+				Selector: &metav1.LabelSelector{MatchLabels: colorLabels},
+				Template: *next,
+			},
+		}
+		if _, err := b.clientset.AppsV1().Deployments(b.namespace).Create(context.TODO(), deployment, metav1.CreateOptions{}); err != nil {
+			return "", "", fmt.Errorf("failed to create deployment %s: %v", name, err)
+		}
+		fmt.Printf("Created deployment %s\n", name)
+	case err != nil:
+		return "", "", fmt.Errorf("failed to get deployment %s: %v", name, err)
+	default:
+		existing.Labels = withLabels(existing.Labels, labels)
+		existing.Spec.Template = *next
+		existing.Spec.Replicas = current.Spec.Replicas
+		if _, err := b.clientset.AppsV1().Deployments(b.namespace).Update(context.TODO(), existing, metav1.UpdateOptions{}); err != nil {
+			return "", "", fmt.Errorf("failed to update deployment %s: %v", name, err)
+		}
+		fmt.Printf("Updated deployment %s\n", name)
+	}
+	fmt.Printf("Deploying %s as %s while %s serves traffic\n", name, color, live)
+	return color, live, waitForRollout(ctx, b.clientset, b.namespace, name)
+}
+
+// markBlue labels the pods of the deployment without colors blue, keeping its selector
+func (b *blueGreenRollout) markBlue(ctx context.Context) error {
+	patch := fmt.Sprintf(`{"spec":{"template":{"metadata":{"labels":{%q:%q}}}}}`, colorLabel, ColorBlue)
+	_, err := b.clientset.AppsV1().Deployments(b.namespace).Patch(context.TODO(), b.name, types.StrategicMergePatchType, []byte(patch), metav1.PatchOptions{})
+	if err != nil {
+		return fmt.Errorf("failed to label deployment %s %s: %v", b.name, ColorBlue, err)
+	}
+	fmt.Printf("Labeling the pods of %s %s\n", b.name, ColorBlue)
+	return waitForRollout(ctx, b.clientset, b.namespace, b.name)
+}
+
+func (b *blueGreenRollout) selectColor(service *corev1.Service, color string) error {
+	service.Spec.Selector = withLabels(map[string]string{colorLabel: color}, service.Spec.Selector)
+	if _, err := b.clientset.CoreV1().Services(b.namespace).Update(context.TODO(), service, metav1.UpdateOptions{}); err != nil {
+		return fmt.Errorf("failed to update service %s: %v", service.Name, err)
+	}
+	return nil
+}
+
+// verify sends the request to a ready pod of the color before it gets traffic
+func (b *blueGreenRollout) verify(ctx context.Context, color string, r *endpointRequest) error {
+	name := b.colorDeployment(color)
+	service, err := b.clientset.CoreV1().Services(b.namespace).Get(context.TODO(), serviceName(b.name), metav1.GetOptions{})
+	if err != nil {
+		return fmt.Errorf("failed to get service %s: %v", serviceName(b.name), err)
+	}
+	if len(service.Spec.Ports) == 0 {
+		return fmt.Errorf("service %s has no ports", service.Name)
+	}
+	deployment, err := b.clientset.AppsV1().Deployments(b.namespace).Get(context.TODO(), name, metav1.GetOptions{})
+	if err != nil {
+		return fmt.Errorf("failed to get deployment %s: %v", name, err)
+	}
+	var server *modelServer
+	if c := findContainer(&deployment.Spec.Template.Spec, b.name); c != nil {
+		server = modelServerForImage(c.Image)
+	}
+	if r.Format == "" && server != nil {
+		r.Format = server.Protocol
+		if r.Format == FormatRaw && r.Path == "" {
+			r.Path = server.ReadyPath
+		}
+	}
+	if r.Format == "" {
+		r.Format = FormatRaw
+	}
+
+	target := &forwardTarget{clientset: b.clientset, namespace: b.namespace, kind: KindDeployment, name: name}
+	pod, err := target.readyPod()
+	if err != nil {
+		return err
+	}
+	config, err := GetRestConfig()
+	if err != nil {
+		return err
+	}
+	stopCh := make(chan struct{})
+	defer close(stopCh)
+	fw, _, err := forwardPorts(config, b.clientset, b.namespace, pod.Name, []string{"localhost"}, []string{"0:" + servicePortTarget(service.Spec.Ports[0], pod)}, stopCh, os.Stderr)
+	if err != nil {
+		return err
+	}
+	ports, err := fw.GetPorts()
+	if err != nil || len(ports) == 0 {
+		return fmt.Errorf("failed to get the forwarded port: %v", err)
+	}
+	baseURL := fmt.Sprintf("http://localhost:%d", ports[0].Local)
+	client := &http.Client{Timeout: time.Minute}
+	if err := r.prepare(ctx, client, baseURL); err != nil {
+		return err
+	}
+	result, err := r.send(ctx, client, baseURL)
+	if err != nil {
+		return fmt.Errorf("verification of %s failed: %v", name, err)
+	}
+	fmt.Printf("Verified %s: %s %s returned %d in %s\n", name, r.Method, r.Path, result.Status, roundLatency(result.Latency))
+	return nil
+}
+
+// cutover points the service and the ScaledObject at the color in one update each, and gives
+// the previous color until keep before it is scaled to zero
+func (b *blueGreenRollout) cutover(color string, keep time.Duration) error {
+	live, service, err := b.liveColor()
+	if err != nil {
+		return err
+	}
+	selected := service.Spec.Selector[colorLabel] != ""
+	if err := b.selectColor(service, color); err != nil {
+		return err
+	}
+	if selected && live != color {
+		fmt.Printf("Switched service %s from %s to %s\n", service.Name, live, color)
+	} else {
+		fmt.Printf("Service %s selects %s\n", service.Name, color)
+	}
+	if _, err := getScaledObjectState(b.clientset, b.namespace, b.name); err == nil {
+		patch := map[string]interface{}{"spec": map[string]interface{}{
+			"scaleTargetRef": map[string]interface{}{"name": b.colorDeployment(color)},
+		}}
+		if err := patchScaledObject(b.clientset, b.namespace, b.name, patch); err != nil {
+			return err
+		}
+		green, err := b.clientset.AppsV1().Deployments(b.namespace).GetScale(context.TODO(), b.colorDeployment(ColorGreen), metav1.GetOptions{})
+		if color == ColorBlue && err == nil && green.Spec.Replicas > 0 {
+			fmt.Printf("Warning: until %s is scaled to zero, the CPU and memory triggers of ScaledObject %s also count its pods\n", b.colorDeployment(ColorGreen), b.name)
+		}
+	} else if !k8serrors.IsNotFound(err) {
+		return err
+	}
+
+	if err := b.setRetireAfter(color, ""); err != nil {
+		return err
+	}
+	if err := b.setRetireAfter(otherColor(color), time.Now().Add(keep).UTC().Format(time.RFC3339)); err != nil {
+		return err
+	}
+	return b.retireExpired(os.Stdout)
+}
+
+// setRetireAfter sets or, when empty, removes the expiry of the color if it is deployed
+func (b *blueGreenRollout) setRetireAfter(color, after string) error {
+	name := b.colorDeployment(color)
+	var value interface{}
+	if after != "" {
+		value = after
+	}
+	patch, _ := json.Marshal(map[string]interface{}{"metadata": map[string]interface{}{
+		"annotations": map[string]interface{}{retireAfterAnnotation: value},
+	}})
+	_, err := b.clientset.AppsV1().Deployments(b.namespace).Patch(context.TODO(), name, types.MergePatchType, patch, metav1.PatchOptions{})
+	if err != nil && !k8serrors.IsNotFound(err) {
+		return fmt.Errorf("failed to annotate deployment %s: %v", name, err)
+	}
+	return nil
+}
+
+// retireExpired scales the idle color to zero once its --keep-previous window has passed, so
+// nothing has to wait for the window; until then a switch back is instant
+func (b *blueGreenRollout) retireExpired(out io.Writer) error {
+	live, _, err := b.liveColor()
+	if err != nil {
+		return err
+	}
+	color := otherColor(live)
+	name := b.colorDeployment(color)
+	deployment, err := b.clientset.AppsV1().Deployments(b.namespace).Get(context.TODO(), name, metav1.GetOptions{})
+	if k8serrors.IsNotFound(err) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("failed to get deployment %s: %v", name, err)
+	}
+	value := deployment.Annotations[retireAfterAnnotation]
+	if value == "" {
+		return nil
+	}
+	after, err := time.Parse(time.RFC3339, value)
+	if err != nil {
+		return fmt.Errorf("invalid %s annotation on deployment %s: %v", retireAfterAnnotation, name, err)
+	}
+	if time.Now().Before(after) {
+		fmt.Fprintf(out, "Keeping %s (%s) until %s, roll back with 'simplismart-cli switch --name %s --to %s'\n", name, color, after.Local().Format(time.RFC3339), b.name, color)
+		return nil
+	}
+	if deployment.Spec.Replicas == nil || *deployment.Spec.Replicas > 0 {
+		scale, err := b.clientset.AppsV1().Deployments(b.namespace).GetScale(context.TODO(), name, metav1.GetOptions{})
+		if err != nil {
+			return fmt.Errorf("failed to get deployment %s: %v", name, err)
+		}
+		scale.Spec.Replicas = 0
+		if _, err := b.clientset.AppsV1().Deployments(b.namespace).UpdateScale(context.TODO(), name, scale, metav1.UpdateOptions{}); err != nil {
+			return fmt.Errorf("failed to scale deployment %s: %v", name, err)
+		}
+		fmt.Fprintf(out, "Scaled %s (%s) to 0, kept since %s\n", name, color, after.Local().Format(time.RFC3339))
+	}
+	return b.setRetireAfter(color, "")
+}
+
+// retireIdleColor runs retireExpired for the commands following the live color, so the previous
+// color is scaled down once its window passed without another deploy or switch. It is best
+// effort: a user who may not scale the deployment only gets a warning.
+func retireIdleColor(clientset *kubernetes.Clientset, namespace, name string, out io.Writer) {
+	color, err := serviceColor(clientset, namespace, name)
+	if err != nil || color == "" {
+		return
+	}
+	b := &blueGreenRollout{clientset: clientset, namespace: namespace, name: name}
+	if err := b.retireExpired(out); err != nil {
+		fmt.Fprintln(out, "Warning:", err)
+	}
+}
+
+// discard scales a color that failed verification to zero, freeing its GPUs
+func (b *blueGreenRollout) discard(color string) {
+	name := b.colorDeployment(color)
+	scale, err := b.clientset.AppsV1().Deployments(b.namespace).GetScale(context.TODO(), name, metav1.GetOptions{})
+	if err == nil {
+		scale.Spec.Replicas = 0
+		_, err = b.clientset.AppsV1().Deployments(b.namespace).UpdateScale(context.TODO(), name, scale, metav1.UpdateOptions{})
+	}
+	if err != nil {
+		fmt.Printf("Failed to scale %s to 0: %v\n", name, err)
+		return
+	}
+	fmt.Printf("Scaled %s (%s) to 0, traffic stays on %s\n", name, color, otherColor(color))
+}
+
+// blueGreenVerification is the request --verify sends to the new color, nil without --verify
+func blueGreenVerification(cmd *cobra.Command) *endpointRequest {
+	if verify, _ := cmd.Flags().GetBool("verify"); !verify {
+		return nil
+	}
+	r := &endpointRequest{Headers: http.Header{}, Prompt: "Say hello in one sentence.", MaxTokens: 16}
+	r.Path, _ = cmd.Flags().GetString("verify-path")
+	r.Expect, _ = cmd.Flags().GetString("verify-expect")
+	if r.Path != "" {
+		r.Format = FormatRaw
+	}
+	return r
+}
+
+var SwitchCmd = &cobra.Command{
+	Use:   "switch",
+	Short: "Send the traffic of a blue/green deployment to the other color",
+	Long: `Point the service of a deployment made with 'create-deployment --strategy=blue-green'
+at the given color. A color scaled to zero is scaled back to the replicas of the live one and
+waited for first; while the previous color is kept, the switch is instant. The previous color
+is scaled to zero by the first create-deployment, switch, scale, restart or health-status of
+the deployment after --keep-previous; until then they print when that happens. While green
+is kept after a switch to blue, the CPU and memory triggers of blue also count green's pods,
+which blue's selector matches.`,
+	Run: func(cmd *cobra.Command, args []string) {
+		name, _ := cmd.Flags().GetString("name")
+		namespace, _ := cmd.Flags().GetString("namespace")
+		if namespace == "" {
+			namespace = contextNamespace()
+		}
+		to, _ := cmd.Flags().GetString("to")
+		to = strings.ToLower(to)
+		keep, _ := cmd.Flags().GetDuration("keep-previous")
+		skipPreflight, _ := cmd.Flags().GetBool("skip-preflight")
+		if err := validateColor(to); err != nil {
+			fmt.Println(err)
+			return
+		}
+
+		clientset, err := GetK8sClient()
+		if err != nil {
+			fmt.Println(err)
+			return
+		}
+		if !skipPreflight {
+			if err := preflightRBAC(clientset, "switch", switchPermissions(namespace)); err != nil {
+				fmt.Println(err)
+				return
+			}
+		}
+		b := &blueGreenRollout{clientset: clientset, namespace: namespace, name: name}
+		live, _, err := b.liveColor()
+		if err != nil {
+			fmt.Println(err)
+			return
+		}
+		if live == to {
+			fmt.Printf("%s already serves %s\n", serviceName(name), to)
+			if err := b.retireExpired(os.Stdout); err != nil {
+				fmt.Println(err)
+				os.Exit(1)
+			}
+			return
+		}
+		ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+		defer stop()
+
+		target, err := clientset.AppsV1().Deployments(namespace).GetScale(context.TODO(), b.colorDeployment(to), metav1.GetOptions{})
+		if err != nil {
+			fmt.Printf("Cannot get deployment %s: %v\n", b.colorDeployment(to), err)
+			return
+		}
+		if target.Spec.Replicas == 0 {
+			current, err := clientset.AppsV1().Deployments(namespace).GetScale(context.TODO(), b.colorDeployment(live), metav1.GetOptions{})
+			if err != nil {
+				fmt.Printf("Cannot get deployment %s: %v\n", b.colorDeployment(live), err)
+				return
+			}
+			target.Spec.Replicas = current.Spec.Replicas
+			if _, err := clientset.AppsV1().Deployments(namespace).UpdateScale(context.TODO(), b.colorDeployment(to), target, metav1.UpdateOptions{}); err != nil {
+				fmt.Printf("Cannot scale deployment %s: %v\n", b.colorDeployment(to), err)
+				return
+			}
+			fmt.Printf("Scaled %s to %d replicas\n", b.colorDeployment(to), target.Spec.Replicas)
+		}
+		if err := waitForRollout(ctx, clientset, namespace, b.colorDeployment(to)); err != nil {
+			fmt.Println(err)
+			os.Exit(1)
+		}
+		if err := b.cutover(to, keep); err != nil {
+			fmt.Println(err)
+			os.Exit(1)
+		}
+	},
+}
+
+func init() {
+	SwitchCmd.Flags().String("name", "", "Name of the deployment")
+	SwitchCmd.Flags().String("namespace", "", "Namespace of the deployment (defaults to the context's namespace)")
+	SwitchCmd.Flags().String("to", "", "Color to send the traffic to: blue or green")
+	SwitchCmd.Flags().Duration("keep-previous", 30*time.Minute, "How long the previous color is kept before the next command on the deployment scales it to zero")
+	SwitchCmd.Flags().Bool("skip-preflight", false, "Skip the permission check before switching")
+	SwitchCmd.MarkFlagRequired("name")
+	SwitchCmd.MarkFlagRequired("to")
+}
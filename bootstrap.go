@@ -0,0 +1,81 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/spf13/cobra"
+)
+
+// Addons every CLI feature depends on
+var bootstrapAddons = []string{"metrics-server", "keda", "prometheus"}
+
+var BootstrapCmd = &cobra.Command{
+	Use:   "bootstrap",
+	Short: "Install the addons every CLI feature needs on a fresh cluster",
+	Long: `Install metrics-server, KEDA and Prometheus, and any addon given with --with, in
+dependency order, waiting for each one to be ready. Addons already installed or
+provided by the cluster are left alone, so bootstrap can be run again safely.
+Exits with 1 when an addon is not healthy afterwards.`,
+	Run: func(cmd *cobra.Command, args []string) {
+		with, _ := cmd.Flags().GetStringSlice("with")
+		skipPreflight, _ := cmd.Flags().GetBool("skip-preflight")
+		opts := addonOptions{Wait: true}
+		opts.Timeout, _ = cmd.Flags().GetDuration("timeout")
+		chartSourceOptions(cmd, &opts)
+
+		addons, err := resolveAddons(append(append([]string{}, bootstrapAddons...), with...))
+		if err != nil {
+			fmt.Println(err)
+			os.Exit(1)
+		}
+		clientset, err := GetK8sClient()
+		if err != nil {
+			fmt.Println("Error creating Kubernetes client:", err)
+			os.Exit(1)
+		}
+		if !skipPreflight {
+			if err := preflightRBAC(clientset, "bootstrap", addonsPermissions(addons)); err != nil {
+				fmt.Println(err)
+				os.Exit(1)
+			}
+		}
+		h := newHelmClient()
+		h.plainHTTP = opts.PlainHTTP
+		if err := installAddons(h, clientset, addons, opts); err != nil {
+			fmt.Println(err)
+			os.Exit(1)
+		}
+
+		// Check every addon does its job, not only that its pods are ready
+		fmt.Println()
+		env := &doctorEnv{clientset: clientset}
+		var results []checkResult
+		failed := false
+		for _, addon := range addons {
+			if addon.Check == nil {
+				continue
+			}
+			result := addon.Check(env)
+			result.Name = addon.Name
+			results = append(results, result)
+			if result.Status == CheckFail {
+				failed = true
+			}
+		}
+		printCheckResults(results)
+		if failed {
+			os.Exit(1)
+		}
+		fmt.Println("\nThe cluster is ready, run 'simplismart-cli doctor' for the full checklist")
+	},
+}
+
+func init() {
+	BootstrapCmd.Flags().StringSlice("with", []string{}, "Optional addons to install too (e.g., gpu-operator,ingress-nginx,keda-http-add-on)")
+	BootstrapCmd.Flags().Duration("timeout", 10*time.Minute, "How long to wait for each addon")
+	BootstrapCmd.Flags().Bool("skip-preflight", false, "Skip the permission check")
+	addChartSourceFlags(BootstrapCmd)
+	BootstrapCmd.MarkFlagsMutuallyExclusive("registry", "embedded")
+}
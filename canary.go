@@ -0,0 +1,594 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"math"
+	"os"
+	"os/signal"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/spf13/cobra"
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	k8serrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+)
+
+// Label telling canary pods apart from the stable ones, in the pods and their metrics
+const trackLabel = "track"
+
+const (
+	// How often the canary is compared with the stable version within a step
+	canaryCheckInterval = time.Minute
+	// Rate window of the analysis queries
+	canaryQueryWindow = "2m"
+	// Failed checks in a row that abort the canary
+	canaryMaxFailures = 2
+)
+
+const gatewayAPIPath = "/apis/gateway.networking.k8s.io/v1"
+
+// A canaryAnalysis holds the PromQL comparing a canary with the stable version. {selector} and
+// {window} are replaced; the error rate is between 0 and 1, the latency in seconds. Empty
+// queries are not checked.
+type canaryAnalysis struct {
+	ErrorRate string
+	Latency   string
+}
+
+// Metrics of apps instrumented with prometheus_fastapi_instrumentator or similar
+var httpAnalysis = canaryAnalysis{
+	ErrorRate: `sum(rate(http_requests_total{{selector},status=~"5.."}[{window}])) / sum(rate(http_requests_total{{selector}}[{window}]))`,
+	Latency:   `sum(rate(http_request_duration_seconds_sum{{selector}}[{window}])) / sum(rate(http_request_duration_seconds_count{{selector}}[{window}]))`,
+}
+
+type canaryOptions struct {
+	// Traffic percentages of the canary before promotion
+	Steps             []int
+	StepDuration      time.Duration
+	MaxErrorRate      float64
+	MaxLatencyRatio   float64
+	Analysis          canaryAnalysis
+	PrometheusAddress string
+}
+
+// A canaryRollout runs a canary next to the stable deployment, splitting traffic through a
+// Gateway API HTTPRoute that sends to the stable service, or else through replica counts
+// behind the stable service's selector
+type canaryRollout struct {
+	clientset *kubernetes.Clientset
+	namespace string
+	name      string
+	route     map[string]interface{}
+}
+
+func canaryOptionsFromFlags(cmd *cobra.Command, analysis *canaryAnalysis, prometheusAddress string) (*canaryOptions, error) {
+	weight, _ := cmd.Flags().GetInt("weight")
+	steps, _ := cmd.Flags().GetIntSlice("canary-steps")
+	opts := &canaryOptions{Steps: []int{weight}, PrometheusAddress: prometheusAddress, Analysis: httpAnalysis}
+	opts.StepDuration, _ = cmd.Flags().GetDuration("step-duration")
+	opts.MaxErrorRate, _ = cmd.Flags().GetFloat64("max-error-rate")
+	opts.MaxLatencyRatio, _ = cmd.Flags().GetFloat64("max-latency-ratio")
+	if analysis != nil {
+		opts.Analysis = *analysis
+	}
+	for _, s := range steps {
+		if s > opts.Steps[len(opts.Steps)-1] {
+			opts.Steps = append(opts.Steps, s)
+		}
+	}
+	for _, s := range opts.Steps {
+		if s < 1 || s > 99 {
+			return nil, fmt.Errorf("canary weights must be between 1 and 99, got %d", s)
+		}
+	}
+	if opts.StepDuration < canaryCheckInterval {
+		return nil, fmt.Errorf("--step-duration must be at least %s", canaryCheckInterval)
+	}
+	return opts, nil
+}
+
+func newCanaryRollout(clientset *kubernetes.Clientset, namespace, name string) (*canaryRollout, error) {
+	// The service of a blue/green deployment selects only one color, which a canary would not follow
+	color, err := serviceColor(clientset, namespace, name)
+	if err != nil {
+		return nil, err
+	}
+	if color != "" {
+		return nil, fmt.Errorf("%s is deployed blue/green, roll it out with --strategy=blue-green instead of a canary", name)
+	}
+	route, err := findServiceRoute(clientset, namespace, serviceName(name))
+	if err != nil {
+		return nil, err
+	}
+	return &canaryRollout{clientset: clientset, namespace: namespace, name: name, route: route}, nil
+}
+
+func serviceName(name string) string {
+	return fmt.Sprintf("%s-service", name)
+}
+
+func (c *canaryRollout) canaryName() string {
+	return c.name + "-canary"
+}
+
+// canaryApp is the app label of the canary pods, the stable one when they share its service
+func (c *canaryRollout) canaryApp() string {
+	if c.route != nil {
+		return c.canaryName()
+	}
+	return c.name
+}
+
+// run deploys the canary with the template merged into the stable one, moves traffic to it step
+// by step while comparing it with the stable version, and promotes or aborts it
+func (c *canaryRollout) run(ctx context.Context, template corev1.PodTemplateSpec, removed, removedVolumes []string, labels map[string]string, opts *canaryOptions) error {
+	stable, err := c.clientset.AppsV1().Deployments(c.namespace).Get(context.TODO(), c.name, metav1.GetOptions{})
+	if err != nil {
+		if k8serrors.IsNotFound(err) {
+			return fmt.Errorf("deployment %s does not exist yet, create it without --canary first", c.name)
+		}
+		return fmt.Errorf("failed to get deployment %s: %v", c.name, err)
+	}
+	if _, err := c.clientset.AppsV1().Deployments(c.namespace).Get(context.TODO(), c.canaryName(), metav1.GetOptions{}); err == nil {
+		return fmt.Errorf("canary %s is already running, run 'simplismart-cli canary promote' or 'canary abort' first", c.canaryName())
+	}
+	if c.analyzed(opts) {
+		if _, _, err := queryPrometheus(c.clientset, opts.PrometheusAddress, "vector(1)"); err != nil {
+			return fmt.Errorf("the canary analysis needs Prometheus: %v", err)
+		}
+	} else {
+		fmt.Println("Warning: no metrics to compare the canary on, it is promoted once every step passes")
+	}
+
+	canaryLabels := map[string]string{"app": c.canaryApp(), trackLabel: "canary"}
+	canary := &appsv1.Deployment{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      c.canaryName(),
+			Namespace: c.namespace,
+			Labels:    withLabels(canaryLabels, stable.Labels),
+		},
+		Spec: appsv1.DeploymentSpec{
+			Replicas: int32Ptr(c.canaryReplicas(stable, opts.Steps[0])),
+			Selector: &metav1.LabelSelector{MatchLabels: canaryLabels},
+			Template: *stable.Spec.Template.DeepCopy(),
+		},
+	}
+	mergeTemplate(&canary.Spec.Template, template, removed, removedVolumes, labels)
+	canary.Spec.Template.Labels = withLabels(canaryLabels, canary.Spec.Template.Labels)
+	if _, err := c.clientset.AppsV1().Deployments(c.namespace).Create(context.TODO(), canary, metav1.CreateOptions{}); err != nil {
+		return fmt.Errorf("failed to create canary %s: %v", c.canaryName(), err)
+	}
+	fmt.Printf("Created canary deployment %s\n", c.canaryName())
+	if c.route != nil {
+		if err := c.createCanaryService(stable); err != nil {
+			return c.abort(err.Error())
+		}
+	}
+
+	for i, weight := range opts.Steps {
+		if err := c.setWeight(weight); err != nil {
+			return c.abort(err.Error())
+		}
+		if err := waitForRollout(ctx, c.clientset, c.namespace, c.canaryName()); err != nil {
+			return c.interruptedOr(ctx, err)
+		}
+		fmt.Printf("Step %d/%d: %d%% of traffic to the canary for %s\n", i+1, len(opts.Steps), weight, opts.StepDuration)
+		failures := 0
+		deadline := time.Now().Add(opts.StepDuration)
+		for check := 1; time.Now().Before(deadline); check++ {
+			wait := canaryCheckInterval
+			if left := time.Until(deadline); left < wait {
+				wait = left
+			}
+			if !sleepContext(ctx, wait) {
+				return c.interruptedOr(ctx, nil)
+			}
+			if !c.analyzed(opts) {
+				continue
+			}
+			summary, problems := c.compare(opts)
+			if len(problems) == 0 {
+				fmt.Printf("  check %d: %s: ok\n", check, summary)
+				failures = 0
+				continue
+			}
+			failures++
+			fmt.Printf("  check %d: %s: %s\n", check, summary, strings.Join(problems, ", "))
+			if failures >= canaryMaxFailures {
+				return c.abort(strings.Join(problems, ", "))
+			}
+		}
+	}
+	return c.promote(ctx)
+}
+
+func (c *canaryRollout) analyzed(opts *canaryOptions) bool {
+	return opts.Analysis.ErrorRate != "" || opts.Analysis.Latency != ""
+}
+
+// canaryReplicas gives the canary about weight percent of the pods
+func (c *canaryRollout) canaryReplicas(stable *appsv1.Deployment, weight int) int32 {
+	replicas := int32(1)
+	if stable.Spec.Replicas != nil {
+		replicas = *stable.Spec.Replicas
+	}
+	n := int32(math.Round(float64(replicas) * float64(weight) / float64(100-weight)))
+	if n < 1 {
+		n = 1
+	}
+	return n
+}
+
+// setWeight sizes the canary for its share of the traffic and, with an HTTPRoute, sets the
+// route's weights
+func (c *canaryRollout) setWeight(weight int) error {
+	stable, err := c.clientset.AppsV1().Deployments(c.namespace).Get(context.TODO(), c.name, metav1.GetOptions{})
+	if err != nil {
+		return fmt.Errorf("failed to get deployment %s: %v", c.name, err)
+	}
+	replicas := c.canaryReplicas(stable, weight)
+	scale, err := c.clientset.AppsV1().Deployments(c.namespace).GetScale(context.TODO(), c.canaryName(), metav1.GetOptions{})
+	if err != nil {
+		return fmt.Errorf("failed to get canary %s: %v", c.canaryName(), err)
+	}
+	if scale.Spec.Replicas != replicas {
+		scale.Spec.Replicas = replicas
+		if _, err := c.clientset.AppsV1().Deployments(c.namespace).UpdateScale(context.TODO(), c.canaryName(), scale, metav1.UpdateOptions{}); err != nil {
+			return fmt.Errorf("failed to scale canary %s: %v", c.canaryName(), err)
+		}
+	}
+	if c.route == nil {
+		stableReplicas := *stable.Spec.Replicas
+		fmt.Printf("Canary runs %d pods next to %d stable ones, %.0f%% of the traffic\n", replicas, stableReplicas, 100*float64(replicas)/float64(replicas+stableReplicas))
+		return nil
+	}
+	return c.updateRoute(weight)
+}
+
+func (c *canaryRollout) updateRoute(weight int) error {
+	setRouteWeights(c.route, c.namespace, serviceName(c.name), serviceName(c.canaryName()), weight)
+	metadata, _ := c.route["metadata"].(map[string]interface{})
+	routeName, _ := metadata["name"].(string)
+	data, err := json.Marshal(c.route)
+	if err != nil {
+		return err
+	}
+	updated, err := c.clientset.RESTClient().
+		Put().
+		AbsPath(gatewayAPIPath).
+		Namespace(c.namespace).
+		Resource("httproutes").
+		Name(routeName).
+		Body(data).
+		DoRaw(context.Background())
+	if err != nil {
+		return fmt.Errorf("failed to update HTTPRoute %s: %v", routeName, err)
+	}
+	// The next update needs the new resourceVersion
+	if err := json.Unmarshal(updated, &c.route); err != nil {
+		return err
+	}
+	fmt.Printf("HTTPRoute %s sends %d%% of the traffic to the canary\n", routeName, weight)
+	return nil
+}
+
+// createCanaryService gives the HTTPRoute a backend with only the canary pods
+func (c *canaryRollout) createCanaryService(stable *appsv1.Deployment) error {
+	service, err := c.clientset.CoreV1().Services(c.namespace).Get(context.TODO(), serviceName(c.name), metav1.GetOptions{})
+	if err != nil {
+		return fmt.Errorf("failed to get service %s: %v", serviceName(c.name), err)
+	}
+	canaryService := &corev1.Service{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      serviceName(c.canaryName()),
+			Namespace: c.namespace,
+			Labels:    withLabels(map[string]string{trackLabel: "canary"}, service.Labels),
+		},
+		Spec: corev1.ServiceSpec{
+			Selector: map[string]string{"app": c.canaryApp(), trackLabel: "canary"},
+			Ports:    service.Spec.Ports,
+			Type:     corev1.ServiceTypeClusterIP,
+		},
+	}
+	for i := range canaryService.Spec.Ports {
+		canaryService.Spec.Ports[i].NodePort = 0
+	}
+	if _, err := c.clientset.CoreV1().Services(c.namespace).Create(context.TODO(), canaryService, metav1.CreateOptions{}); err != nil && !k8serrors.IsAlreadyExists(err) {
+		return fmt.Errorf("failed to create service %s: %v", canaryService.Name, err)
+	}
+	fmt.Printf("Created service %s\n", canaryService.Name)
+	return nil
+}
+
+// compare queries the canary and the stable metrics, returning a summary and the thresholds the
+// canary breaks
+func (c *canaryRollout) compare(opts *canaryOptions) (string, []string) {
+	stableSelector := fmt.Sprintf(`app="%s",%s!="canary"`, c.name, trackLabel)
+	canarySelector := fmt.Sprintf(`app="%s",%s="canary"`, c.canaryApp(), trackLabel)
+	query := func(q, selector string) (float64, bool, error) {
+		q = strings.NewReplacer("{selector}", selector, "{window}", canaryQueryWindow).Replace(q)
+		v, ok, err := queryPrometheus(c.clientset, opts.PrometheusAddress, q)
+		if ok && (math.IsNaN(v) || math.IsInf(v, 0)) {
+			// No requests in the window
+			ok = false
+		}
+		return v, ok, err
+	}
+	var summary, problems []string
+	if q := opts.Analysis.ErrorRate; q != "" {
+		canary, canaryOK, err := query(q, canarySelector)
+		stable, stableOK, _ := query(q, stableSelector)
+		switch {
+		case err != nil:
+			problems = append(problems, err.Error())
+		case !canaryOK:
+			summary = append(summary, "error rate: no canary traffic")
+		default:
+			summary = append(summary, fmt.Sprintf("error rate %.2f%% (stable %s)", 100*canary, formatOptional(stable, stableOK, "%.2f%%", 100)))
+			if canary > stable+opts.MaxErrorRate {
+				problems = append(problems, fmt.Sprintf("error rate %.2f%% above the stable %.2f%% by more than %.2f%%", 100*canary, 100*stable, 100*opts.MaxErrorRate))
+			}
+		}
+	}
+	if q := opts.Analysis.Latency; q != "" {
+		canary, canaryOK, err := query(q, canarySelector)
+		stable, stableOK, _ := query(q, stableSelector)
+		switch {
+		case err != nil:
+			problems = append(problems, err.Error())
+		case !canaryOK:
+			summary = append(summary, "latency: no canary traffic")
+		default:
+			summary = append(summary, fmt.Sprintf("latency %s (stable %s)", seconds(canary), formatOptional(stable, stableOK, "%s", 0)))
+			if stableOK && stable > 0 && canary > stable*opts.MaxLatencyRatio {
+				problems = append(problems, fmt.Sprintf("latency %.2fx the stable version, more than %.2fx", canary/stable, opts.MaxLatencyRatio))
+			}
+		}
+	}
+	return strings.Join(summary, ", "), problems
+}
+
+func formatOptional(v float64, ok bool, format string, scale float64) string {
+	if !ok {
+		return "no traffic"
+	}
+	if scale == 0 {
+		return seconds(v)
+	}
+	return fmt.Sprintf(format, v*scale)
+}
+
+func seconds(v float64) string {
+	return roundLatency(time.Duration(v * float64(time.Second))).String()
+}
+
+// promote rolls the canary's pod template out to the stable deployment, then removes the canary
+func (c *canaryRollout) promote(ctx context.Context) error {
+	canary, err := c.clientset.AppsV1().Deployments(c.namespace).Get(context.TODO(), c.canaryName(), metav1.GetOptions{})
+	if err != nil {
+		return fmt.Errorf("failed to get canary %s: %v", c.canaryName(), err)
+	}
+	stable, err := c.clientset.AppsV1().Deployments(c.namespace).Get(context.TODO(), c.name, metav1.GetOptions{})
+	if err != nil {
+		return fmt.Errorf("failed to get deployment %s: %v", c.name, err)
+	}
+	stable.Spec.Template.Spec = canary.Spec.Template.Spec
+	stable.Spec.Template.Annotations = canary.Spec.Template.Annotations
+	if _, err := c.clientset.AppsV1().Deployments(c.namespace).Update(context.TODO(), stable, metav1.UpdateOptions{}); err != nil {
+		return fmt.Errorf("failed to promote the canary to %s: %v", c.name, err)
+	}
+	fmt.Printf("Promoting the canary to deployment %s\n", c.name)
+	// The canary keeps serving its share until the stable pods run the new version
+	if err := waitForRollout(ctx, c.clientset, c.namespace, c.name); err != nil {
+		return err
+	}
+	if err := c.cleanup(); err != nil {
+		return err
+	}
+	fmt.Printf("Promoted the canary of %s\n", c.name)
+	return nil
+}
+
+// abort sends all traffic back to the stable version and removes the canary
+func (c *canaryRollout) abort(reason string) error {
+	fmt.Printf("Aborting the canary: %s\n", reason)
+	if err := c.cleanup(); err != nil {
+		return err
+	}
+	return fmt.Errorf("canary of %s aborted: %s", c.name, reason)
+}
+
+func (c *canaryRollout) cleanup() error {
+	if c.route != nil {
+		if err := c.updateRoute(0); err != nil {
+			return err
+		}
+	}
+	err := c.clientset.AppsV1().Deployments(c.namespace).Delete(context.TODO(), c.canaryName(), metav1.DeleteOptions{})
+	if err := reportDeleted("deployment", c.canaryName(), err); err != nil {
+		return err
+	}
+	if c.route != nil {
+		err := c.clientset.CoreV1().Services(c.namespace).Delete(context.TODO(), serviceName(c.canaryName()), metav1.DeleteOptions{})
+		return reportDeleted("service", serviceName(c.canaryName()), err)
+	}
+	return nil
+}
+
+// interruptedOr leaves the canary running when the user stops the command
+func (c *canaryRollout) interruptedOr(ctx context.Context, err error) error {
+	if ctx.Err() == nil {
+		return c.abort(err.Error())
+	}
+	return fmt.Errorf("interrupted, canary %s keeps its traffic; run 'simplismart-cli canary promote --name %s' or 'canary abort --name %s'", c.canaryName(), c.name, c.name)
+}
+
+// findServiceRoute returns the HTTPRoute sending to the service, nil without one or without the
+// Gateway API
+func findServiceRoute(clientset *kubernetes.Clientset, namespace, service string) (map[string]interface{}, error) {
+	if _, err := clientset.Discovery().ServerResourcesForGroupVersion("gateway.networking.k8s.io/v1"); err != nil {
+		return nil, nil
+	}
+	data, err := clientset.RESTClient().
+		Get().
+		AbsPath(gatewayAPIPath).
+		Namespace(namespace).
+		Resource("httproutes").
+		DoRaw(context.Background())
+	if err != nil {
+		return nil, fmt.Errorf("failed to list HTTPRoutes: %v", err)
+	}
+	var list struct {
+		Items []map[string]interface{} `json:"items"`
+	}
+	if err := json.Unmarshal(data, &list); err != nil {
+		return nil, err
+	}
+	for _, route := range list.Items {
+		for _, ref := range routeBackendRefs(route) {
+			if isServiceRef(ref, namespace, service) {
+				return route, nil
+			}
+		}
+	}
+	return nil, nil
+}
+
+func routeBackendRefs(route map[string]interface{}) []map[string]interface{} {
+	var refs []map[string]interface{}
+	spec, _ := route["spec"].(map[string]interface{})
+	rules, _ := spec["rules"].([]interface{})
+	for _, r := range rules {
+		rule, _ := r.(map[string]interface{})
+		backendRefs, _ := rule["backendRefs"].([]interface{})
+		for _, b := range backendRefs {
+			if ref, ok := b.(map[string]interface{}); ok {
+				refs = append(refs, ref)
+			}
+		}
+	}
+	return refs
+}
+
+func isServiceRef(ref map[string]interface{}, namespace, service string) bool {
+	group, _ := ref["group"].(string)
+	kind, _ := ref["kind"].(string)
+	refNamespace, _ := ref["namespace"].(string)
+	return ref["name"] == service && group == "" && (kind == "" || kind == "Service") && (refNamespace == "" || refNamespace == namespace)
+}
+
+// setRouteWeights splits the rules sending to the stable service between it and the canary
+// service; a weight of 0 removes the canary backend
+func setRouteWeights(route map[string]interface{}, namespace, service, canaryService string, weight int) {
+	spec, _ := route["spec"].(map[string]interface{})
+	rules, _ := spec["rules"].([]interface{})
+	for _, r := range rules {
+		rule, _ := r.(map[string]interface{})
+		backendRefs, _ := rule["backendRefs"].([]interface{})
+		var refs []interface{}
+		var stableRef map[string]interface{}
+		for _, b := range backendRefs {
+			ref, _ := b.(map[string]interface{})
+			if ref != nil && isServiceRef(ref, namespace, canaryService) {
+				continue
+			}
+			if ref != nil && isServiceRef(ref, namespace, service) {
+				stableRef = ref
+			}
+			refs = append(refs, b)
+		}
+		if stableRef == nil {
+			continue
+		}
+		if weight > 0 {
+			stableRef["weight"] = 100 - weight
+			refs = append(refs, map[string]interface{}{"name": canaryService, "port": stableRef["port"], "weight": weight})
+		} else {
+			delete(stableRef, "weight")
+		}
+		rule["backendRefs"] = refs
+	}
+}
+
+var CanaryCmd = &cobra.Command{
+	Use:   "canary",
+	Short: "Promote or abort a canary left by an interrupted 'create-deployment --canary'",
+}
+
+var CanaryPromoteCmd = &cobra.Command{
+	Use:   "promote",
+	Short: "Roll the canary out to the stable deployment and remove it",
+	Run: func(cmd *cobra.Command, args []string) {
+		c, ctx, cancel, err := canaryFromFlags(cmd)
+		if err != nil {
+			fmt.Println(err)
+			return
+		}
+		defer cancel()
+		if err := c.promote(ctx); err != nil {
+			fmt.Println(err)
+			os.Exit(1)
+		}
+	},
+}
+
+var CanaryAbortCmd = &cobra.Command{
+	Use:   "abort",
+	Short: "Send all traffic back to the stable deployment and remove the canary",
+	Run: func(cmd *cobra.Command, args []string) {
+		c, _, cancel, err := canaryFromFlags(cmd)
+		if err != nil {
+			fmt.Println(err)
+			return
+		}
+		defer cancel()
+		if err := c.cleanup(); err != nil {
+			fmt.Println(err)
+			os.Exit(1)
+		}
+	},
+}
+
+func canaryFromFlags(cmd *cobra.Command) (*canaryRollout, context.Context, context.CancelFunc, error) {
+	name, _ := cmd.Flags().GetString("name")
+	namespace, _ := cmd.Flags().GetString("namespace")
+	if namespace == "" {
+		namespace = contextNamespace()
+	}
+	timeout, _ := cmd.Flags().GetDuration("timeout")
+	skipPreflight, _ := cmd.Flags().GetBool("skip-preflight")
+	clientset, err := GetK8sClient()
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	if !skipPreflight {
+		if err := preflightRBAC(clientset, "canary", canaryPermissions(namespace)); err != nil {
+			return nil, nil, nil, err
+		}
+	}
+	c, err := newCanaryRollout(clientset, namespace, name)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	return c, ctx, func() { cancel(); stop() }, nil
+}
+
+func init() {
+	for _, c := range []*cobra.Command{CanaryPromoteCmd, CanaryAbortCmd} {
+		c.Flags().String("name", "", "Name of the deployment")
+		c.Flags().String("namespace", "", "Namespace of the deployment (defaults to the context's namespace)")
+		c.Flags().Bool("skip-preflight", false, "Skip the permission check before changing resources")
+		c.MarkFlagRequired("name")
+	}
+	CanaryPromoteCmd.Flags().Duration("timeout", 30*time.Minute, "How long to wait for the stable deployment to roll out")
+	CanaryAbortCmd.Flags().Duration("timeout", time.Minute, "How long the abort may take")
+	CanaryCmd.AddCommand(CanaryPromoteCmd, CanaryAbortCmd)
+}
@@ -0,0 +1,420 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"text/tabwriter"
+
+	"github.com/spf13/cobra"
+	"k8s.io/apimachinery/pkg/api/resource"
+	"k8s.io/apimachinery/pkg/util/validation"
+	"sigs.k8s.io/yaml"
+)
+
+// Set by the global --profile flag
+var profileName string
+
+// cliConfig is the config file: named profiles of defaults and the profile in use
+type cliConfig struct {
+	CurrentProfile string                       `json:"currentProfile,omitempty"`
+	Profiles       map[string]map[string]string `json:"profiles,omitempty"`
+	Presets        map[string]resourcePreset    `json:"presets,omitempty"`
+}
+
+// A configKey is a setting a profile can hold, with the environment variable overriding it
+type configKey struct {
+	Name     string
+	Env      string
+	Default  string
+	Usage    string
+	Validate func(string) error
+}
+
+var configKeys = []configKey{
+	{"context", "SIMPLISMART_CONTEXT", "", "Kubeconfig context", nil},
+	{"namespace", "SIMPLISMART_NAMESPACE", "", "Namespace used without --namespace", validateDNSLabel},
+	{"resources.preset", "SIMPLISMART_PRESET", "", "Resource preset of create-deployment, see 'config preset list'", validatePreset},
+	{"resources.cpuRequest", "SIMPLISMART_CPU_REQUEST", "100m", "CPU request of create-deployment", validateQuantity},
+	{"resources.cpuLimit", "SIMPLISMART_CPU_LIMIT", "500m", "CPU limit of create-deployment", validateQuantity},
+	{"resources.memoryRequest", "SIMPLISMART_MEMORY_REQUEST", "128Mi", "Memory request of create-deployment", validateQuantity},
+	{"resources.memoryLimit", "SIMPLISMART_MEMORY_LIMIT", "512Mi", "Memory limit of create-deployment", validateQuantity},
+	{"resources.gpu", "SIMPLISMART_GPU", "", "GPUs per pod of create-deployment", validateCount},
+	{"autoscaling.minReplicas", "SIMPLISMART_MIN_REPLICAS", "2", "Minimum replicas of the ScaledObject", validateCount},
+	{"autoscaling.maxReplicas", "SIMPLISMART_MAX_REPLICAS", "10", "Maximum replicas of the ScaledObject", validateCount},
+	{"autoscaling.cpuUtilization", "SIMPLISMART_CPU_UTILIZATION", "", "CPU utilization target in percent", validatePercent},
+	{"autoscaling.memoryUtilization", "SIMPLISMART_MEMORY_UTILIZATION", "", "Memory utilization target in percent", validatePercent},
+	{"autoscaling.prometheusAddress", "SIMPLISMART_PROMETHEUS_ADDRESS", prometheusServerAddress, "Prometheus the ScaledObject trigger queries", nil},
+	{"autoscaling.scaleOn", "SIMPLISMART_SCALE_ON", "", "Autoscaling profiles as PROFILE[=THRESHOLD],... (default latency), see 'autoscale profiles'", validateScaleOn},
+	{"registry", "SIMPLISMART_REGISTRY", "", "Registry prefixed to images without one", nil},
+	{"labels", "SIMPLISMART_LABELS", "", "Labels added to created resources (e.g., team=ml,env=dev)", validateLabels},
+	{"output", "SIMPLISMART_OUTPUT", "", "Output format of commands that support it (table, text, json or yaml)", validateOutput},
+}
+
+func findConfigKey(name string) (configKey, bool) {
+	for _, key := range configKeys {
+		if key.Name == name {
+			return key, true
+		}
+	}
+	return configKey{}, false
+}
+
+func validateDNSLabel(v string) error {
+	if errs := validation.IsDNS1123Label(v); len(errs) > 0 {
+		return errors.New(strings.Join(errs, "; "))
+	}
+	return nil
+}
+
+func validateQuantity(v string) error {
+	_, err := resource.ParseQuantity(v)
+	return err
+}
+
+func validateCount(v string) error {
+	if n, err := strconv.Atoi(v); err != nil || n < 0 {
+		return fmt.Errorf("expected a non-negative integer")
+	}
+	return nil
+}
+
+func validatePercent(v string) error {
+	if n, err := strconv.Atoi(v); err != nil || n < 1 || n > 100 {
+		return fmt.Errorf("expected a percentage between 1 and 100")
+	}
+	return nil
+}
+
+func validateLabels(v string) error {
+	_, err := parseLabels(v)
+	return err
+}
+
+func validateOutput(v string) error {
+	switch v {
+	case "table", "text", "json", "yaml":
+		return nil
+	}
+	return fmt.Errorf("expected table, text, json or yaml")
+}
+
+// parseLabels parses "key=value,key=value"
+func parseLabels(v string) (map[string]string, error) {
+	labels := map[string]string{}
+	if v == "" {
+		return labels, nil
+	}
+	for _, pair := range strings.Split(v, ",") {
+		key, value, ok := strings.Cut(pair, "=")
+		if !ok {
+			return nil, fmt.Errorf("label %q is not key=value", pair)
+		}
+		if errs := validation.IsQualifiedName(key); len(errs) > 0 {
+			return nil, fmt.Errorf("invalid label key %q: %s", key, strings.Join(errs, "; "))
+		}
+		if errs := validation.IsValidLabelValue(value); len(errs) > 0 {
+			return nil, fmt.Errorf("invalid label value %q: %s", value, strings.Join(errs, "; "))
+		}
+		labels[key] = value
+	}
+	return labels, nil
+}
+
+// configPath is $SIMPLISMART_CONFIG, else config.yaml next to the state file
+func configPath() (string, error) {
+	if path := os.Getenv("SIMPLISMART_CONFIG"); path != "" {
+		return path, nil
+	}
+	dir, err := configDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(dir, "config.yaml"), nil
+}
+
+// loadConfig reads the config file, empty when there is none
+func loadConfig() (*cliConfig, error) {
+	config := &cliConfig{Profiles: map[string]map[string]string{}}
+	path, err := configPath()
+	if err != nil {
+		return config, err
+	}
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return config, nil
+	}
+	if err != nil {
+		return config, err
+	}
+	if err := yaml.UnmarshalStrict(data, config); err != nil {
+		return config, fmt.Errorf("cannot parse %s: %v", path, err)
+	}
+	if config.Profiles == nil {
+		config.Profiles = map[string]map[string]string{}
+	}
+	return config, nil
+}
+
+func saveConfig(config *cliConfig) error {
+	path, err := configPath()
+	if err != nil {
+		return err
+	}
+	data, err := yaml.Marshal(config)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return fmt.Errorf("failed to save %s: %v", path, err)
+	}
+	if err := os.WriteFile(path, data, 0600); err != nil {
+		return fmt.Errorf("failed to save %s: %v", path, err)
+	}
+	return nil
+}
+
+// loadedConfig is the config file as read once per run; a broken file is reported and ignored
+var loadedConfig = sync.OnceValue(func() *cliConfig {
+	config, err := loadConfig()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Warning: ignoring %v\n", err)
+	}
+	return config
+})
+
+// activeProfile is the profile of --profile, $SIMPLISMART_PROFILE, the nearest .simplismart.yaml
+// or the config file, in that order, with where it was chosen
+func activeProfile() (string, string) {
+	if profileName != "" {
+		return profileName, "--profile"
+	}
+	if name := os.Getenv("SIMPLISMART_PROFILE"); name != "" {
+		return name, "SIMPLISMART_PROFILE"
+	}
+	if path := findProjectFile(); path != "" {
+		if s, err := readSelection(path); err == nil && s.Profile != "" {
+			return s.Profile, path
+		}
+	}
+	if name := loadedConfig().CurrentProfile; name != "" {
+		return name, "config file"
+	}
+	return "", ""
+}
+
+// configValue resolves a setting from its environment variable, then the active profile, then
+// the built-in default, with where the value came from
+func configValue(name string) (string, string) {
+	key, ok := findConfigKey(name)
+	if !ok {
+		return "", ""
+	}
+	if v, ok := os.LookupEnv(key.Env); ok {
+		return v, key.Env
+	}
+	if profile, _ := activeProfile(); profile != "" {
+		if v, ok := loadedConfig().Profiles[profile][name]; ok {
+			return v, "profile " + profile
+		}
+	}
+	return key.Default, "default"
+}
+
+// flagOrConfig returns the flag when it was given, else the setting it defaults to
+func flagOrConfig(cmd *cobra.Command, flag, name string) string {
+	if cmd.Flags().Changed(flag) {
+		v, _ := cmd.Flags().GetString(flag)
+		return v
+	}
+	v, _ := configValue(name)
+	return v
+}
+
+// outputFormat returns -o when given, else the configured output when the command supports it
+func outputFormat(cmd *cobra.Command, supported ...string) string {
+	output, _ := cmd.Flags().GetString("output")
+	if cmd.Flags().Changed("output") {
+		return output
+	}
+	if v, _ := configValue("output"); v != "" {
+		for _, s := range supported {
+			if v == s {
+				return v
+			}
+		}
+	}
+	return output
+}
+
+var ConfigCmd = &cobra.Command{
+	Use:   "config",
+	Short: "Manage profiles of default settings",
+	Long: `Profiles hold defaults for an environment, e.g. dev, staging and prod, in
+~/.config/simplismart/config.yaml ($SIMPLISMART_CONFIG overrides the path).
+
+Settings are resolved in this order:
+  1. command-line flags
+  2. environment variables (SIMPLISMART_NAMESPACE, SIMPLISMART_CPU_REQUEST, ...)
+  3. the active profile
+  4. built-in defaults
+
+The active profile is chosen by --profile, then $SIMPLISMART_PROFILE, then a
+"profile:" line in .simplismart.yaml, then 'config use-profile'. The context and
+namespace chosen with connect, or pinned in .simplismart.yaml, take precedence
+over those of the profile.
+
+Run 'simplismart-cli config view' to see every setting and where it comes from.`,
+}
+
+var ConfigGetCmd = &cobra.Command{
+	Use:   "get KEY",
+	Short: "Print the value of a setting",
+	Args:  cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		if _, ok := findConfigKey(args[0]); !ok {
+			fmt.Printf("Unknown setting %q, run 'simplismart-cli config view' for the list\n", args[0])
+			os.Exit(1)
+		}
+		v, source := configValue(args[0])
+		fmt.Println(v)
+		// Keep stdout to the value for scripts
+		fmt.Fprintf(os.Stderr, "from %s\n", source)
+	},
+}
+
+var ConfigSetCmd = &cobra.Command{
+	Use:   "set KEY VALUE",
+	Short: "Set a setting in the active profile, or the one given with --profile",
+	Long: `Set a setting in the active profile, or the one given with --profile, creating the
+profile when needed. An empty value removes the setting from the profile.`,
+	Args: cobra.ExactArgs(2),
+	Run: func(cmd *cobra.Command, args []string) {
+		name, value := args[0], args[1]
+		key, ok := findConfigKey(name)
+		if !ok {
+			fmt.Printf("Unknown setting %q, run 'simplismart-cli config view' for the list\n", name)
+			return
+		}
+		if value != "" && key.Validate != nil {
+			if err := key.Validate(value); err != nil {
+				fmt.Printf("Invalid value for %s: %v\n", name, err)
+				return
+			}
+		}
+		config, err := loadConfig()
+		if err != nil {
+			fmt.Println(err)
+			return
+		}
+		profile, _ := activeProfile()
+		if profile == "" {
+			profile = "default"
+			config.CurrentProfile = profile
+		}
+		if config.Profiles[profile] == nil {
+			config.Profiles[profile] = map[string]string{}
+		}
+		if value == "" {
+			delete(config.Profiles[profile], name)
+		} else {
+			config.Profiles[profile][name] = value
+		}
+		if err := saveConfig(config); err != nil {
+			fmt.Println(err)
+			return
+		}
+		fmt.Printf("Set %s=%s in profile %s\n", name, value, profile)
+		if _, ok := os.LookupEnv(key.Env); ok {
+			fmt.Printf("Note: $%s overrides it\n", key.Env)
+		}
+	},
+}
+
+var ConfigViewCmd = &cobra.Command{
+	Use:   "view",
+	Short: "Show every setting of the active profile and where its value comes from",
+	Run: func(cmd *cobra.Command, args []string) {
+		raw, _ := cmd.Flags().GetBool("raw")
+		if raw {
+			data, _ := yaml.Marshal(loadedConfig())
+			fmt.Print(string(data))
+			return
+		}
+		profile, source := activeProfile()
+		if profile == "" {
+			fmt.Println("Profile: none")
+		} else {
+			fmt.Printf("Profile: %s (from %s)\n", profile, source)
+		}
+		if names := profileNames(loadedConfig()); len(names) > 0 {
+			fmt.Printf("Profiles: %s\n", strings.Join(names, ", "))
+		}
+		if active, source := activeSelection(); source != "" {
+			fmt.Printf("In use: context %s, namespace %s (from %s)\n", orDash(active.Context), orDash(active.Namespace), source)
+		}
+		fmt.Println()
+		tw := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+		fmt.Fprintln(tw, "KEY\tVALUE\tSOURCE\tENV")
+		for _, key := range configKeys {
+			v, source := configValue(key.Name)
+			fmt.Fprintf(tw, "%s\t%s\t%s\t%s\n", key.Name, orDash(v), source, key.Env)
+		}
+		tw.Flush()
+	},
+}
+
+var ConfigUseProfileCmd = &cobra.Command{
+	Use:   "use-profile NAME",
+	Short: "Make a profile the active one",
+	Args:  cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		name := args[0]
+		config, err := loadConfig()
+		if err != nil {
+			fmt.Println(err)
+			return
+		}
+		if _, ok := config.Profiles[name]; !ok {
+			fmt.Printf("Profile %q not found, create it with 'simplismart-cli config set --profile %s KEY VALUE'\n", name, name)
+			if names := profileNames(config); len(names) > 0 {
+				fmt.Printf("Profiles: %s\n", strings.Join(names, ", "))
+			}
+			return
+		}
+		config.CurrentProfile = name
+		if err := saveConfig(config); err != nil {
+			fmt.Println(err)
+			return
+		}
+		// A context chosen with connect would shadow the one of the profile
+		p := config.Profiles[name]
+		if p["context"] != "" || p["namespace"] != "" {
+			if err := saveState(selection{}); err != nil {
+				fmt.Println(err)
+				return
+			}
+		}
+		fmt.Printf("Using profile %s\n", name)
+		warnProjectPin()
+	},
+}
+
+func profileNames(config *cliConfig) []string {
+	names := make([]string, 0, len(config.Profiles))
+	for name := range config.Profiles {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+func init() {
+	ConfigViewCmd.Flags().Bool("raw", false, "Print the config file instead")
+	ConfigCmd.AddCommand(ConfigGetCmd, ConfigSetCmd, ConfigViewCmd, ConfigUseProfileCmd)
+}
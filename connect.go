@@ -1,67 +1,395 @@
 package main
 
 import (
-	// "context"
+	"context"
 	"fmt"
 	"os"
+	"sort"
+	"strings"
+	"text/tabwriter"
+	"time"
 
 	"github.com/manifoldco/promptui"
 	"github.com/spf13/cobra"
+	k8serrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
 	"k8s.io/client-go/tools/clientcmd"
-	// "k8s.io/client-go/tools/clientcmd/api"
+	clientcmdapi "k8s.io/client-go/tools/clientcmd/api"
 )
 
+// A contextItem is a kubeconfig context as shown in the list and the prompt
+type contextItem struct {
+	Name      string
+	Cluster   string
+	User      string
+	Namespace string
+	Current   bool
+}
+
+// sortedContexts returns the contexts by name, the current one marked
+func sortedContexts(config *clientcmdapi.Config, current string) []contextItem {
+	items := make([]contextItem, 0, len(config.Contexts))
+	for name, c := range config.Contexts {
+		items = append(items, contextItem{Name: name, Cluster: c.Cluster, User: c.AuthInfo, Namespace: c.Namespace, Current: name == current})
+	}
+	sort.Slice(items, func(i, j int) bool { return items[i].Name < items[j].Name })
+	return items
+}
+
+// loadKubeconfig merges every file of $KUBECONFIG, or the --kubeconfig file, for editing
+func loadKubeconfig() (clientcmd.ConfigAccess, *clientcmdapi.Config, error) {
+	access := kubeconfigAccess()
+	config, err := access.GetStartingConfig()
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to load kubeconfig: %v", err)
+	}
+	return access, config, nil
+}
+
+// selectedContext is the context commands run against: the one of --context, .simplismart.yaml or
+// the state file, else the current context of the kubeconfig
+func selectedContext(config *clientcmdapi.Config) (string, error) {
+	active, _ := activeSelection()
+	name := active.Context
+	if name == "" {
+		name = config.CurrentContext
+	}
+	if name == "" {
+		return "", fmt.Errorf("no current context, run 'simplismart-cli connect'")
+	}
+	if _, ok := config.Contexts[name]; !ok {
+		return "", fmt.Errorf("context %q not found", name)
+	}
+	return name, nil
+}
+
+// verifyContext checks the API server of the context answers
+func verifyContext(access clientcmd.ConfigAccess, config *clientcmdapi.Config, name string) error {
+	restConfig, err := clientcmd.NewNonInteractiveClientConfig(*config, name, &clientcmd.ConfigOverrides{}, access).ClientConfig()
+	if err != nil {
+		return fmt.Errorf("invalid context %s: %v", name, err)
+	}
+	restConfig.Timeout = 10 * time.Second
+	clientset, err := kubernetes.NewForConfig(restConfig)
+	if err != nil {
+		return fmt.Errorf("invalid context %s: %v", name, err)
+	}
+	version, err := clientset.Discovery().ServerVersion()
+	if err != nil {
+		return fmt.Errorf("cannot reach %s: %v", restConfig.Host, err)
+	}
+	fmt.Printf("Reached %s (Kubernetes %s)\n", restConfig.Host, version.GitVersion)
+	return nil
+}
+
+// warnProjectPin tells when a .simplismart.yaml overrides what connect just saved
+func warnProjectPin() {
+	path := findProjectFile()
+	if path == "" {
+		return
+	}
+	if pinned, err := readSelection(path); err == nil && pinned.Context != "" {
+		fmt.Printf("Note: %s pins context %s in this directory\n", path, pinned.Context)
+	}
+}
+
+// promptContext lets the user pick a context, starting at the current one
+func promptContext(config *clientcmdapi.Config) (string, error) {
+	current, _ := selectedContext(config)
+	items := sortedContexts(config, current)
+	if len(items) == 0 {
+		return "", fmt.Errorf("the kubeconfig has no contexts")
+	}
+	cursor := 0
+	for i, item := range items {
+		if item.Current {
+			cursor = i
+		}
+	}
+	prompt := promptui.Select{
+		Label: "Select a Kubernetes context (/ to search)",
+		Items: items,
+		Size:  10,
+		Templates: &promptui.SelectTemplates{
+			Active:   `▸ {{ .Name | cyan }}{{ if .Current }} (current){{ end }}  {{ .Cluster | faint }} {{ .User | faint }}`,
+			Inactive: `  {{ .Name }}{{ if .Current }} (current){{ end }}  {{ .Cluster | faint }} {{ .User | faint }}`,
+			Selected: `Context: {{ .Name }}`,
+		},
+		Searcher: func(input string, index int) bool {
+			item := items[index]
+			input = strings.ToLower(input)
+			return strings.Contains(strings.ToLower(item.Name), input) || strings.Contains(strings.ToLower(item.Cluster), input)
+		},
+	}
+	i, _, err := prompt.RunCursorAt(cursor, cursor-cursor%10)
+	if err != nil {
+		return "", err
+	}
+	return items[i].Name, nil
+}
+
 var ConnectCmd = &cobra.Command{
 	Use:   "connect",
 	Short: "Connect to the Kubernetes cluster",
+	Long: `Select the kubeconfig context this CLI uses. The API server of the context is checked
+before it is saved.
+
+The choice is saved in ~/.config/simplismart/state.yaml and does not change the
+kubeconfig, so other shells and tools are not affected. With --global the current
+context of the kubeconfig is changed instead, as kubectl config use-context does;
+edits are written back to the file of $KUBECONFIG that defines each entry.
+
+A .simplismart.yaml in a project directory, or any parent, pins the cluster for
+commands run there and takes precedence over the saved choice:
+
+  context: prod-gpu
+  namespace: serving`,
 	Run: func(cmd *cobra.Command, args []string) {
 		// Retrieve user inputs
 		contextName, _ := cmd.Flags().GetString("context-name")
-		kubeconfigPath := os.Getenv("KUBECONFIG")
-		if kubeconfigPath == "" {
-			kubeconfigPath = clientcmd.RecommendedHomeFile
-		}
+		noVerify, _ := cmd.Flags().GetBool("no-verify")
+		global, _ := cmd.Flags().GetBool("global")
 
-		config, err := clientcmd.LoadFromFile(kubeconfigPath)
+		access, config, err := loadKubeconfig()
 		if err != nil {
-			fmt.Printf("Error loading kubeconfig: %v\n", err)
+			fmt.Println(err)
 			return
 		}
-		if contextName != "" {
-			config.CurrentContext = contextName
-		} else {
-			fmt.Println("Available Kubernetes contexts:")
+		if contextName == "" {
+			contextName, err = promptContext(config)
+			if err != nil {
+				fmt.Printf("Prompt failed %v\n", err)
+				return
+			}
+		}
+		if _, ok := config.Contexts[contextName]; !ok {
+			fmt.Printf("Context %q not found, run 'simplismart-cli connect list'\n", contextName)
+			return
+		}
+		if !noVerify {
+			if err := verifyContext(access, config, contextName); err != nil {
+				fmt.Println(err)
+				fmt.Println("The current context was not changed, use --no-verify to switch anyway")
+				return
+			}
+		}
 
-			// Create a slice to hold context names
-			contextNames := make([]string, 0, len(config.Contexts))
-			for contextName := range config.Contexts {
-				contextNames = append(contextNames, contextName)
+		if global {
+			config.CurrentContext = contextName
+			if err := clientcmd.ModifyConfig(access, *config, true); err != nil {
+				fmt.Printf("Error saving kubeconfig: %v\n", err)
+				return
 			}
+			// A saved choice would still shadow the kubeconfig
+			if err := saveState(selection{}); err != nil {
+				fmt.Println(err)
+				return
+			}
+		} else if err := saveState(selection{Context: contextName}); err != nil {
+			fmt.Println(err)
+			return
+		}
+		fmt.Printf("Current context set to: %s\n", contextName)
+		warnProjectPin()
+	},
+}
 
-			// Prompt for context selection
-			prompt := promptui.Select{
-				Label: "Select a Kubernetes Context",
-				Items: contextNames,
+var ConnectListCmd = &cobra.Command{
+	Use:   "list",
+	Short: "List the kubeconfig contexts",
+	Run: func(cmd *cobra.Command, args []string) {
+		_, config, err := loadKubeconfig()
+		if err != nil {
+			fmt.Println(err)
+			return
+		}
+		current, _ := selectedContext(config)
+		active, _ := activeSelection()
+		tw := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+		fmt.Fprintln(tw, "CURRENT\tNAME\tCLUSTER\tUSER\tNAMESPACE")
+		for _, item := range sortedContexts(config, current) {
+			marker := ""
+			if item.Current {
+				marker = "*"
+				// The namespace saved for this CLI wins over the kubeconfig's
+				if active.Namespace != "" {
+					item.Namespace = active.Namespace
+				}
 			}
+			fmt.Fprintf(tw, "%s\t%s\t%s\t%s\t%s\n", marker, item.Name, item.Cluster, item.User, item.Namespace)
+		}
+		tw.Flush()
+	},
+}
 
-			_, selectedContext, err := prompt.Run()
-			if err != nil {
-				fmt.Printf("Prompt failed %v\n", err)
-				return
+var ConnectCurrentCmd = &cobra.Command{
+	Use:   "current",
+	Short: "Print the context commands run against",
+	Run: func(cmd *cobra.Command, args []string) {
+		_, config, err := loadKubeconfig()
+		if err != nil {
+			fmt.Println(err)
+			os.Exit(1)
+		}
+		name, err := selectedContext(config)
+		if err != nil {
+			fmt.Println(err)
+			os.Exit(1)
+		}
+		fmt.Println(name)
+		// Keep stdout to the name for scripts
+		if _, source := activeSelection(); source != "" {
+			fmt.Fprintf(os.Stderr, "selected by %s\n", source)
+		}
+	},
+}
+
+var ConnectRenameCmd = &cobra.Command{
+	Use:   "rename OLD NEW",
+	Short: "Rename a context",
+	Args:  cobra.ExactArgs(2),
+	Run: func(cmd *cobra.Command, args []string) {
+		oldName, newName := args[0], args[1]
+		access, config, err := loadKubeconfig()
+		if err != nil {
+			fmt.Println(err)
+			return
+		}
+		c, ok := config.Contexts[oldName]
+		if !ok {
+			fmt.Printf("Context %q not found\n", oldName)
+			return
+		}
+		if _, exists := config.Contexts[newName]; exists {
+			fmt.Printf("Context %q already exists\n", newName)
+			return
+		}
+		// The renamed context keeps the file it came from
+		config.Contexts[newName] = c
+		delete(config.Contexts, oldName)
+		if config.CurrentContext == oldName {
+			config.CurrentContext = newName
+		}
+		if err := clientcmd.ModifyConfig(access, *config, true); err != nil {
+			fmt.Printf("Error saving kubeconfig: %v\n", err)
+			return
+		}
+		if state, err := loadState(); err == nil && state.Context == oldName {
+			state.Context = newName
+			if err := saveState(state); err != nil {
+				fmt.Println(err)
 			}
-			// Set the current context to the selected one
-			config.CurrentContext = selectedContext
 		}
-		err = clientcmd.WriteToFile(*config, kubeconfigPath)
+		fmt.Printf("Context %s renamed to %s\n", oldName, newName)
+		if path := findProjectFile(); path != "" {
+			if pinned, err := readSelection(path); err == nil && pinned.Context == oldName {
+				fmt.Printf("Note: %s still pins context %s\n", path, oldName)
+			}
+		}
+	},
+}
+
+var ConnectDeleteCmd = &cobra.Command{
+	Use:   "delete NAME",
+	Short: "Delete a context",
+	Args:  cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		name := args[0]
+		prune, _ := cmd.Flags().GetBool("prune")
+		access, config, err := loadKubeconfig()
 		if err != nil {
+			fmt.Println(err)
+			return
+		}
+		c, ok := config.Contexts[name]
+		if !ok {
+			fmt.Printf("Context %q not found\n", name)
+			return
+		}
+		delete(config.Contexts, name)
+		if prune {
+			// Only drop the cluster and user when no other context uses them
+			clusterUsed, userUsed := false, false
+			for _, other := range config.Contexts {
+				clusterUsed = clusterUsed || other.Cluster == c.Cluster
+				userUsed = userUsed || other.AuthInfo == c.AuthInfo
+			}
+			if !clusterUsed {
+				delete(config.Clusters, c.Cluster)
+			}
+			if !userUsed {
+				delete(config.AuthInfos, c.AuthInfo)
+			}
+		}
+		if config.CurrentContext == name {
+			config.CurrentContext = ""
+			fmt.Println("Deleted the current context, select another with 'simplismart-cli connect'")
+		}
+		if err := clientcmd.ModifyConfig(access, *config, true); err != nil {
 			fmt.Printf("Error saving kubeconfig: %v\n", err)
 			return
 		}
+		if state, err := loadState(); err == nil && state.Context == name {
+			if err := saveState(selection{}); err != nil {
+				fmt.Println(err)
+			}
+		}
+		fmt.Printf("Context %s deleted\n", name)
+	},
+}
+
+var ConnectSetNamespaceCmd = &cobra.Command{
+	Use:   "set-namespace NAMESPACE",
+	Short: "Set the namespace commands use when --namespace is not given",
+	Long: `Set the namespace commands use with the current context, or the context given with
+--context. Like connect, it is saved for this CLI only unless --global is given, which
+sets the namespace of the context in the kubeconfig.`,
+	Args: cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		namespace := args[0]
+		global, _ := cmd.Flags().GetBool("global")
+		access, config, err := loadKubeconfig()
+		if err != nil {
+			fmt.Println(err)
+			return
+		}
+		name, err := selectedContext(config)
+		if err != nil {
+			fmt.Println(err)
+			return
+		}
 
-		fmt.Printf("Current context set to: %s\n", config.CurrentContext)
+		// A missing namespace is only a warning, it may be created later
+		if clientset, err := GetK8sClient(); err == nil {
+			_, err := clientset.CoreV1().Namespaces().Get(context.TODO(), namespace, metav1.GetOptions{})
+			if k8serrors.IsNotFound(err) {
+				fmt.Printf("Warning: namespace %s does not exist\n", namespace)
+			}
+		}
+
+		if global {
+			config.Contexts[name].Namespace = namespace
+			if err := clientcmd.ModifyConfig(access, *config, true); err != nil {
+				fmt.Printf("Error saving kubeconfig: %v\n", err)
+				return
+			}
+		} else if err := saveState(selection{Context: name, Namespace: namespace}); err != nil {
+			fmt.Println(err)
+			return
+		}
+		fmt.Printf("Namespace of context %s set to: %s\n", name, namespace)
+		if _, source := activeSelection(); source != "" && source == findProjectFile() {
+			fmt.Printf("Note: %s takes precedence in this directory\n", source)
+		}
 	},
 }
 
 func init() {
 	ConnectCmd.Flags().String("context-name", "", "Name of the k8s context")
+	ConnectCmd.Flags().Bool("no-verify", false, "Switch without checking the API server is reachable")
+	ConnectCmd.Flags().Bool("global", false, "Change the current context of the kubeconfig, for every tool")
+	ConnectSetNamespaceCmd.Flags().Bool("global", false, "Set the namespace in the kubeconfig, for every tool")
+	ConnectDeleteCmd.Flags().Bool("prune", false, "Also delete the cluster and user when no other context uses them")
+	ConnectCmd.AddCommand(ConnectListCmd, ConnectCurrentCmd, ConnectRenameCmd, ConnectDeleteCmd, ConnectSetNamespaceCmd)
 }
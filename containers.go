@@ -0,0 +1,329 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	"k8s.io/apimachinery/pkg/util/strategicpatch"
+	"k8s.io/apimachinery/pkg/util/validation"
+	"k8s.io/apimachinery/pkg/util/version"
+	"k8s.io/client-go/kubernetes"
+	"sigs.k8s.io/yaml"
+)
+
+// deploymentSpecFile is the file given to create-deployment -f for what flags cannot express.
+// Containers use the Kubernetes container schema; container is merged over the main one.
+//
+//	container:
+//	  env:
+//	    - name: HF_TOKEN
+//	      valueFrom: {secretKeyRef: {name: hf-token, key: token}}
+//	initContainers:
+//	  - name: download-model
+//	    image: amazon/aws-cli:2.17.0
+//	    args: ["s3", "sync", "s3://models/llama", "/models"]
+//	  - name: log-shipper          # native sidecar, Kubernetes 1.29+
+//	    image: fluent/fluent-bit:3.1
+//	    restartPolicy: Always
+//	sidecars:
+//	  - name: exporter
+//	    image: nvidia/dcgm-exporter:3.3.7-3.5.0-ubuntu22.04
+type deploymentSpecFile struct {
+	Container      json.RawMessage    `json:"container,omitempty"`
+	InitContainers []corev1.Container `json:"initContainers,omitempty"`
+	Sidecars       []corev1.Container `json:"sidecars,omitempty"`
+}
+
+func loadSpecFile(path string) (deploymentSpecFile, error) {
+	var spec deploymentSpecFile
+	if path == "" {
+		return spec, nil
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return spec, err
+	}
+	if err := yaml.UnmarshalStrict(data, &spec); err != nil {
+		return spec, fmt.Errorf("cannot parse %s: %v", path, err)
+	}
+	return spec, nil
+}
+
+// overlayContainer applies the container section of the spec file like kubectl patch does,
+// merging lists such as env and ports by key
+func overlayContainer(c corev1.Container, overlay json.RawMessage) (corev1.Container, error) {
+	if len(overlay) == 0 {
+		return c, nil
+	}
+	original, err := json.Marshal(c)
+	if err != nil {
+		return c, err
+	}
+	patched, err := strategicpatch.StrategicMergePatch(original, overlay, corev1.Container{})
+	if err != nil {
+		return c, fmt.Errorf("failed to apply the container section: %v", err)
+	}
+	var result corev1.Container
+	if err := json.Unmarshal(patched, &result); err != nil {
+		return c, fmt.Errorf("failed to apply the container section: %v", err)
+	}
+	if result.Name != c.Name {
+		return c, fmt.Errorf("the container section cannot rename the main container")
+	}
+	return result, nil
+}
+
+// parseContainerFlag parses --sidecar and --init-container values of comma separated
+// key=value pairs: name, image, port (repeatable), command, cpu, memory and, for sidecars,
+// native=true to run it as a native sidecar
+func parseContainerFlag(value string, sidecar bool) (corev1.Container, bool, error) {
+	var c corev1.Container
+	native := false
+	for _, pair := range strings.Split(value, ",") {
+		key, v, ok := strings.Cut(pair, "=")
+		if !ok {
+			return c, false, fmt.Errorf("%q is not key=value in %q", pair, value)
+		}
+		switch key {
+		case "name":
+			c.Name = v
+		case "image":
+			c.Image = v
+		case "port":
+			port, err := strconv.ParseInt(v, 10, 32)
+			if err != nil {
+				return c, false, fmt.Errorf("invalid port %q in %q", v, value)
+			}
+			c.Ports = append(c.Ports, corev1.ContainerPort{ContainerPort: int32(port)})
+		case "command":
+			c.Command = strings.Fields(v)
+		case "cpu", "memory":
+			quantity, err := resource.ParseQuantity(v)
+			if err != nil {
+				return c, false, fmt.Errorf("invalid %s %q in %q: %v", key, v, value, err)
+			}
+			if c.Resources.Requests == nil {
+				c.Resources.Requests, c.Resources.Limits = corev1.ResourceList{}, corev1.ResourceList{}
+			}
+			c.Resources.Requests[corev1.ResourceName(key)] = quantity
+			c.Resources.Limits[corev1.ResourceName(key)] = quantity
+		case "native":
+			if !sidecar {
+				return c, false, fmt.Errorf("native only applies to --sidecar")
+			}
+			b, err := strconv.ParseBool(v)
+			if err != nil {
+				return c, false, fmt.Errorf("invalid native %q in %q", v, value)
+			}
+			native = b
+		default:
+			return c, false, fmt.Errorf("unknown key %q in %q, use the spec file for other fields", key, value)
+		}
+	}
+	if c.Name == "" || c.Image == "" {
+		return c, false, fmt.Errorf("%q needs a name and an image", value)
+	}
+	return c, native, nil
+}
+
+// podContainers combines the main container with the sidecars and init containers of the spec
+// file and flags, prefixing their images with the registry
+func podContainers(main corev1.Container, spec deploymentSpecFile, sidecarFlags, initFlags []string, registry string) ([]corev1.Container, []corev1.Container, error) {
+	containers := append([]corev1.Container{main}, spec.Sidecars...)
+	initContainers := append([]corev1.Container{}, spec.InitContainers...)
+	// Init containers run in order, so the ones given with flags go after the spec file's
+	for _, v := range initFlags {
+		c, _, err := parseContainerFlag(v, false)
+		if err != nil {
+			return nil, nil, fmt.Errorf("invalid --init-container: %v", err)
+		}
+		initContainers = append(initContainers, c)
+	}
+	for _, v := range sidecarFlags {
+		c, native, err := parseContainerFlag(v, true)
+		if err != nil {
+			return nil, nil, fmt.Errorf("invalid --sidecar: %v", err)
+		}
+		if native {
+			always := corev1.ContainerRestartPolicyAlways
+			c.RestartPolicy = &always
+			initContainers = append(initContainers, c)
+		} else {
+			containers = append(containers, c)
+		}
+	}
+
+	seen := map[string]bool{}
+	for _, list := range [][]corev1.Container{containers, initContainers} {
+		for i := range list {
+			c := &list[i]
+			if errs := validation.IsDNS1123Label(c.Name); len(errs) > 0 {
+				return nil, nil, fmt.Errorf("invalid container name %q: %s", c.Name, strings.Join(errs, "; "))
+			}
+			if seen[c.Name] {
+				return nil, nil, fmt.Errorf("container name %q is used twice", c.Name)
+			}
+			seen[c.Name] = true
+			if c.Image == "" {
+				return nil, nil, fmt.Errorf("container %q has no image", c.Name)
+			}
+			c.Image = withRegistry(c.Image, registry)
+		}
+	}
+	return containers, initContainers, nil
+}
+
+// checkNativeSidecars fails when native sidecars are asked of an API server that would drop
+// their restartPolicy and run them as init containers that never finish
+func checkNativeSidecars(clientset *kubernetes.Clientset, initContainers []corev1.Container) error {
+	for _, c := range initContainers {
+		if c.RestartPolicy == nil || *c.RestartPolicy != corev1.ContainerRestartPolicyAlways {
+			continue
+		}
+		info, err := clientset.Discovery().ServerVersion()
+		if err != nil {
+			return fmt.Errorf("failed to get the server version: %v", err)
+		}
+		v, err := version.ParseGeneric(info.GitVersion)
+		if err == nil && !v.AtLeast(version.MajorMinor(1, 29)) {
+			return fmt.Errorf("native sidecar %s needs Kubernetes 1.29 or later, the cluster runs %s", c.Name, info.GitVersion)
+		}
+		return nil
+	}
+	return nil
+}
+
+// mergeContainers updates the existing containers by name, appends new ones and drops the
+// removed ones. Containers added to the deployment by other means are kept.
+func mergeContainers(existing, desired []corev1.Container, removed []string) []corev1.Container {
+	drop := map[string]bool{}
+	for _, name := range removed {
+		drop[name] = true
+	}
+	byName := map[string]corev1.Container{}
+	for _, c := range desired {
+		byName[c.Name] = c
+	}
+	var merged []corev1.Container
+	for _, c := range existing {
+		if drop[c.Name] {
+			continue
+		}
+		if d, ok := byName[c.Name]; ok {
+			c = updateContainer(c, d)
+			delete(byName, c.Name)
+		}
+		merged = append(merged, c)
+	}
+	for _, c := range desired {
+		if _, ok := byName[c.Name]; ok {
+			merged = append(merged, c)
+		}
+	}
+	return merged
+}
+
+// updateContainer applies the desired container over the existing one like overlayContainer,
+// so only the fields this run sets change and args, env or probes set before survive. Env
+// entries and probes it sets replace the existing ones instead of merging with them.
+func updateContainer(existing, desired corev1.Container) corev1.Container {
+	mounts := mergeVolumeMounts(existing.VolumeMounts, desired.VolumeMounts)
+	overlay, err := json.Marshal(desired)
+	if err != nil {
+		desired.VolumeMounts = mounts
+		return desired
+	}
+	c, err := overlayContainer(existing, overlay)
+	if err != nil {
+		desired.VolumeMounts = mounts
+		return desired
+	}
+	env := map[string]corev1.EnvVar{}
+	for _, e := range desired.Env {
+		env[e.Name] = e
+	}
+	for i, e := range c.Env {
+		if d, ok := env[e.Name]; ok {
+			c.Env[i] = d
+		}
+	}
+	if desired.LivenessProbe != nil {
+		c.LivenessProbe = desired.LivenessProbe
+	}
+	if desired.ReadinessProbe != nil {
+		c.ReadinessProbe = desired.ReadinessProbe
+	}
+	if desired.StartupProbe != nil {
+		c.StartupProbe = desired.StartupProbe
+	}
+	c.VolumeMounts = mounts
+	return c
+}
+
+// mergeVolumeMounts keeps the existing mounts of volumes the desired mounts do not mention, so
+// volumes stay mounted across redeploys until removed
+func mergeVolumeMounts(existing, desired []corev1.VolumeMount) []corev1.VolumeMount {
+	mentioned := map[string]bool{}
+	for _, m := range desired {
+		mentioned[m.Name] = true
+	}
+	var merged []corev1.VolumeMount
+	for _, m := range existing {
+		if !mentioned[m.Name] {
+			merged = append(merged, m)
+		}
+	}
+	return append(merged, desired...)
+}
+
+// podRequests is what a pod of these containers counts against quotas: regular containers and
+// native sidecars run together, while plain init containers only need to fit on their own
+func podRequests(containers, initContainers []corev1.Container) corev1.ResourceRequirements {
+	total := corev1.ResourceRequirements{Requests: corev1.ResourceList{}, Limits: corev1.ResourceList{}}
+	running := append([]corev1.Container{}, containers...)
+	var plainInit []corev1.Container
+	for _, c := range initContainers {
+		if c.RestartPolicy != nil && *c.RestartPolicy == corev1.ContainerRestartPolicyAlways {
+			running = append(running, c)
+		} else {
+			plainInit = append(plainInit, c)
+		}
+	}
+	for _, c := range running {
+		requests, limits := containerRequests(c)
+		addResources(total.Requests, requests)
+		addResources(total.Limits, limits)
+	}
+	for _, c := range plainInit {
+		requests, limits := containerRequests(c)
+		maxResources(total.Requests, requests)
+		maxResources(total.Limits, limits)
+	}
+	return total
+}
+
+// containerRequests returns the requests and limits of a container, requests defaulting to
+// the limits like the API server does
+func containerRequests(c corev1.Container) (corev1.ResourceList, corev1.ResourceList) {
+	requests := corev1.ResourceList{}
+	for name, q := range c.Resources.Limits {
+		requests[name] = q
+	}
+	for name, q := range c.Resources.Requests {
+		requests[name] = q
+	}
+	return requests, c.Resources.Limits
+}
+
+func maxResources(total, list corev1.ResourceList) {
+	for name, q := range list {
+		if current, ok := total[name]; !ok || q.Cmp(current) > 0 {
+			total[name] = q
+		}
+	}
+}
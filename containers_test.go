@@ -0,0 +1,78 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	"k8s.io/apimachinery/pkg/util/intstr"
+)
+
+// A deploy-model container redeployed by create-deployment with only a new image
+func TestMergeContainersRedeploy(t *testing.T) {
+	probe := &corev1.Probe{ProbeHandler: corev1.ProbeHandler{HTTPGet: &corev1.HTTPGetAction{Path: "/health", Port: intstr.FromInt32(8000)}}}
+	existing := []corev1.Container{
+		{
+			Name:    "llm",
+			Image:   "vllm/vllm-openai:v0.6.4",
+			Command: []string{"python3", "-m", "vllm.entrypoints.openai.api_server"},
+			Args:    []string{"--model", "meta-llama/Llama-3.1-8B-Instruct"},
+			Ports:   []corev1.ContainerPort{{ContainerPort: 8000}},
+			Env: []corev1.EnvVar{
+				{Name: "HF_TOKEN", ValueFrom: &corev1.EnvVarSource{SecretKeyRef: &corev1.SecretKeySelector{Key: "token"}}},
+				{Name: "LOG_LEVEL", Value: "info"},
+			},
+			Resources: corev1.ResourceRequirements{
+				Limits: corev1.ResourceList{"nvidia.com/gpu": resource.MustParse("1")},
+			},
+			ReadinessProbe: probe,
+			StartupProbe:   probe,
+			VolumeMounts:   []corev1.VolumeMount{{Name: "model-cache", MountPath: "/root/.cache/huggingface"}},
+		},
+		{Name: "exporter", Image: "nvidia/dcgm-exporter:3.3.7-3.5.0-ubuntu22.04"},
+	}
+	desired := []corev1.Container{{
+		Name:  "llm",
+		Image: "vllm/vllm-openai:v0.6.5",
+		Ports: []corev1.ContainerPort{{ContainerPort: 8000}},
+		Env:   []corev1.EnvVar{{Name: "HF_TOKEN", Value: "plain"}},
+		Resources: corev1.ResourceRequirements{
+			Requests: corev1.ResourceList{corev1.ResourceCPU: resource.MustParse("4")},
+		},
+		ReadinessProbe: &corev1.Probe{ProbeHandler: corev1.ProbeHandler{TCPSocket: &corev1.TCPSocketAction{Port: intstr.FromInt32(8000)}}},
+	}}
+
+	merged := mergeContainers(existing, desired, nil)
+	if len(merged) != 2 || merged[1].Name != "exporter" {
+		t.Fatalf("got containers %+v, want llm and exporter", merged)
+	}
+	c := merged[0]
+	if c.Image != "vllm/vllm-openai:v0.6.5" {
+		t.Errorf("got image %s, want the new one", c.Image)
+	}
+	if !reflect.DeepEqual(c.Command, existing[0].Command) || !reflect.DeepEqual(c.Args, existing[0].Args) {
+		t.Errorf("got command %v and args %v, want the deploy-model ones kept", c.Command, c.Args)
+	}
+	wantEnv := []corev1.EnvVar{{Name: "HF_TOKEN", Value: "plain"}, {Name: "LOG_LEVEL", Value: "info"}}
+	if !reflect.DeepEqual(c.Env, wantEnv) {
+		t.Errorf("got env %+v, want %+v", c.Env, wantEnv)
+	}
+	if _, ok := c.Resources.Limits["nvidia.com/gpu"]; !ok || c.Resources.Requests.Cpu().String() != "4" {
+		t.Errorf("got resources %+v, want the GPU limit kept and 4 CPUs requested", c.Resources)
+	}
+	if c.ReadinessProbe.HTTPGet != nil || c.ReadinessProbe.TCPSocket == nil {
+		t.Errorf("got readiness probe %+v, want only the new TCP check", c.ReadinessProbe)
+	}
+	if !reflect.DeepEqual(c.StartupProbe, probe) || len(c.Ports) != 1 {
+		t.Errorf("got startup probe %+v and ports %v, want them kept", c.StartupProbe, c.Ports)
+	}
+	if !reflect.DeepEqual(c.VolumeMounts, existing[0].VolumeMounts) {
+		t.Errorf("got mounts %+v, want the cache mount kept", c.VolumeMounts)
+	}
+
+	merged = mergeContainers(existing, []corev1.Container{{Name: "proxy", Image: "envoyproxy/envoy:v1.31"}}, []string{"exporter"})
+	if len(merged) != 2 || merged[0].Name != "llm" || merged[1].Name != "proxy" {
+		t.Errorf("got containers %+v, want llm and proxy", merged)
+	}
+}
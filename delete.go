@@ -0,0 +1,167 @@
+package main
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/spf13/cobra"
+	k8serrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+)
+
+var DeleteDeploymentCmd = &cobra.Command{
+	Use:     "delete-deployment",
+	Aliases: []string{"delete"},
+	Short:   "Delete a deployment with its service and ScaledObject",
+	Long: `Delete a deployment made by create-deployment with its service and ScaledObject, as
+well as its green color and a canary left behind by an interrupted rollout.
+
+PVCs created for its volumes hold model weights that take long to download again,
+so they are kept unless --purge is given. Even then, claims that pods of other
+workloads mount, such as a shared type=cache volume, are kept.`,
+	Run: func(cmd *cobra.Command, args []string) {
+		name, _ := cmd.Flags().GetString("name")
+		namespace, _ := cmd.Flags().GetString("namespace")
+		if namespace == "" {
+			namespace = contextNamespace()
+		}
+		purge, _ := cmd.Flags().GetBool("purge")
+		skipPreflight, _ := cmd.Flags().GetBool("skip-preflight")
+
+		clientset, err := GetK8sClient()
+		if err != nil {
+			fmt.Println(err)
+			return
+		}
+		if !skipPreflight {
+			perms := deleteDeploymentPermissions(namespace)
+			if purge {
+				perms = append(perms, permissions("", "persistentvolumeclaims", namespace, "delete")...)
+				perms = append(perms, permissions("", "pods", namespace, "list")...)
+			}
+			if err := preflightRBAC(clientset, "delete-deployment", perms); err != nil {
+				fmt.Println(err)
+				return
+			}
+		}
+
+		// The ScaledObject goes first so KEDA does not scale the deployment back up
+		if err := deleteScaledObject(clientset, namespace, name); err != nil {
+			fmt.Println(err)
+			return
+		}
+		err = clientset.AppsV1().Deployments(namespace).Delete(context.TODO(), name, metav1.DeleteOptions{})
+		if err := reportDeleted("deployment", name, err); err != nil {
+			fmt.Println(err)
+			return
+		}
+		// The green color of a blue/green deployment and a canary left by an interrupted rollout
+		for _, extra := range []string{name + "-" + ColorGreen, name + "-canary"} {
+			err = clientset.AppsV1().Deployments(namespace).Delete(context.TODO(), extra, metav1.DeleteOptions{})
+			if err != nil && !k8serrors.IsNotFound(err) {
+				fmt.Printf("Cannot delete deployment %s: %v\n", extra, err)
+			} else if err == nil {
+				fmt.Printf("Deleted deployment %s\n", extra)
+			}
+		}
+		service := fmt.Sprintf("%s-service", name)
+		err = clientset.CoreV1().Services(namespace).Delete(context.TODO(), service, metav1.DeleteOptions{})
+		if err := reportDeleted("service", service, err); err != nil {
+			fmt.Println(err)
+			return
+		}
+		canaryService := serviceName(name + "-canary")
+		err = clientset.CoreV1().Services(namespace).Delete(context.TODO(), canaryService, metav1.DeleteOptions{})
+		if err != nil && !k8serrors.IsNotFound(err) {
+			fmt.Printf("Cannot delete service %s: %v\n", canaryService, err)
+		} else if err == nil {
+			fmt.Printf("Deleted service %s\n", canaryService)
+		}
+
+		claims, err := clientset.CoreV1().PersistentVolumeClaims(namespace).List(context.TODO(), metav1.ListOptions{
+			LabelSelector: fmt.Sprintf("app=%s,%s=%s", name, managedByLabel, managedByValue),
+		})
+		if err != nil {
+			fmt.Printf("Cannot list PVCs: %v\n", err)
+			return
+		}
+		var users map[string]string
+		if purge && len(claims.Items) > 0 {
+			if users, err = claimUsers(clientset, namespace, name); err != nil {
+				fmt.Println(err)
+				return
+			}
+		}
+		for _, claim := range claims.Items {
+			if !purge {
+				fmt.Printf("Kept PVC %s, pass --purge to delete it\n", claim.Name)
+				continue
+			}
+			// A shared cache created here may since be mounted by other deployments
+			if pod, ok := users[claim.Name]; ok {
+				fmt.Printf("Kept PVC %s, pod %s of another workload mounts it\n", claim.Name, pod)
+				continue
+			}
+			err := clientset.CoreV1().PersistentVolumeClaims(namespace).Delete(context.TODO(), claim.Name, metav1.DeleteOptions{})
+			if err := reportDeleted("PVC", claim.Name, err); err != nil {
+				fmt.Println(err)
+				return
+			}
+		}
+	},
+}
+
+// claimUsers maps the claims mounted by pods of other workloads than the deployment to one of
+// those pods
+func claimUsers(clientset *kubernetes.Clientset, namespace, name string) (map[string]string, error) {
+	pods, err := clientset.CoreV1().Pods(namespace).List(context.TODO(), metav1.ListOptions{})
+	if err != nil {
+		return nil, fmt.Errorf("failed to list pods: %v", err)
+	}
+	users := map[string]string{}
+	for _, pod := range pods.Items {
+		// Both colors and the canary of the deployment are deleted with it
+		if app := pod.Labels["app"]; app == name || app == name+"-canary" {
+			continue
+		}
+		for _, v := range pod.Spec.Volumes {
+			if v.PersistentVolumeClaim != nil {
+				users[v.PersistentVolumeClaim.ClaimName] = pod.Name
+			}
+		}
+	}
+	return users, nil
+}
+
+func deleteScaledObject(clientset *kubernetes.Clientset, namespace, name string) error {
+	_, err := clientset.RESTClient().
+		Delete().
+		AbsPath("/apis/keda.sh/v1alpha1").
+		Namespace(namespace).
+		Resource("scaledobjects").
+		Name(name).
+		DoRaw(context.Background())
+	return reportDeleted("ScaledObject", name, err)
+}
+
+// reportDeleted prints the outcome of a delete, treating objects already gone as deleted
+func reportDeleted(kind, name string, err error) error {
+	switch {
+	case err == nil:
+		fmt.Printf("Deleted %s %s\n", kind, name)
+	case k8serrors.IsNotFound(err):
+		fmt.Printf("No %s %s\n", kind, name)
+	default:
+		return fmt.Errorf("failed to delete %s %s: %v", kind, name, err)
+	}
+	return nil
+}
+
+func init() {
+	DeleteDeploymentCmd.Flags().String("name", "", "Name of the deployment")
+	DeleteDeploymentCmd.Flags().String("namespace", "", "Namespace of the deployment (defaults to the context's namespace)")
+	DeleteDeploymentCmd.Flags().Bool("purge", false, "Also delete the PVCs create-deployment created for it that no other workload mounts")
+	DeleteDeploymentCmd.Flags().Bool("skip-preflight", false, "Skip the permission check before deleting resources")
+	DeleteDeploymentCmd.MarkFlagRequired("name")
+}
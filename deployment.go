@@ -4,7 +4,12 @@ import (
 	"context"
 	"encoding/json"
 	"fmt"
+	"os"
+	"os/signal"
 	"strconv"
+	"strings"
+	"syscall"
+	"time"
 
 	k8serrors "k8s.io/apimachinery/pkg/api/errors"
 	"k8s.io/apimachinery/pkg/types"
@@ -12,58 +17,377 @@ import (
 	"github.com/spf13/cobra"
 	appsv1 "k8s.io/api/apps/v1"
 	corev1 "k8s.io/api/core/v1"
-	"k8s.io/apimachinery/pkg/api/resource"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/client-go/kubernetes"
 )
 
+// Prometheus queried by the ScaledObject trigger unless configured otherwise
+const prometheusServerAddress = "http://prometheus-server.monitoring.svc.cluster.local"
+
+// autoscalingPolicy is what the ScaledObject of a deployment scales on
+type autoscalingPolicy struct {
+	MinReplicas       int
+	MaxReplicas       int
+	CPUUtilization    string
+	MemoryUtilization string
+	PrometheusAddress string
+	Triggers          []scalingTrigger
+}
+
 var CreateDeploymentCmd = &cobra.Command{
 	Use:   "create-deployment",
 	Short: "Create a deployment in the Kubernetes cluster",
+	Long: `Create or update a deployment, its LoadBalancer service and its KEDA ScaledObject.
+
+Flags left out are taken from the environment and the active profile, see
+'simplismart-cli config --help'.
+
+Volumes:
+  shm        memory-backed emptyDir at /dev/shm, e.g. type=shm,size=8Gi for PyTorch
+  emptyDir   scratch space, e.g. type=emptyDir,name=scratch,mount=/scratch
+  pvc        PVC named by source, created with size= and storageClass= when missing
+  cache      shared ReadWriteMany PVC mounted read-only, e.g. a model cache
+  hostPath   node directory named by source
+  configMap  ConfigMap named by source
+  secret     Secret named by source
+
+Volumes mount into the main container unless containers= lists others, separated by ';'.
+PVCs outlive the deployment, 'delete-deployment --purge' removes the ones created here.
+
+Canary:
+  --canary deploys the change to an existing deployment as <name>-canary. Traffic moves to
+  it through --weight and --canary-steps, by weights of the HTTPRoute sending to
+  <name>-service when there is one, else by replicas behind the same service. Every minute
+  its error rate and latency are compared with the stable pods in Prometheus, which needs
+  pod labels on the metrics; two failed checks in a row abort it, and it is promoted after
+  the last step. Interrupted canaries are finished with 'canary promote' or 'canary abort'.
+
+Blue/green:
+  --strategy=blue-green runs the change as the color the service does not send to, <name>
+  being blue and <name>-green, and waits until it is ready and, with --verify, answers a
+  test request. The service selector and the ScaledObject then move to it at once. The
+  previous color is kept for --keep-previous, in which 'switch --to COLOR' rolls back
+  instantly; the first create-deployment, switch, scale, restart or health-status after that
+  scales it to zero. A rolling
+  update of a blue/green deployment changes its live color, a canary is refused.
+  Blue's selector, app=<name>, also matches the green pods: while blue is live and green
+  is kept, CPU and memory autoscaling of blue averages over both colors.`,
 	Run: func(cmd *cobra.Command, args []string) {
-		// Retrieve user inputs
-		name, _ := cmd.Flags().GetString("name")
-		image, _ := cmd.Flags().GetString("image")
-		namespace, _ := cmd.Flags().GetString("namespace")
-		cpuRequest, _ := cmd.Flags().GetString("cpu-request")
-		cpuLimit, _ := cmd.Flags().GetString("cpu-limit")
-		ramRequest, _ := cmd.Flags().GetString("ram-request")
-		ramLimit, _ := cmd.Flags().GetString("ram-limit")
-		ports, _ := cmd.Flags().GetStringSlice("ports")
-		cpuTarget, _ := cmd.Flags().GetString("cpu-utilization")
-		memoryTarget, _ := cmd.Flags().GetString("memory-utilization")
-
-		clientset, err := GetK8sClient()
+		runDeployment(cmd, deploymentDefaults{})
+	},
+}
+
+// deploymentDefaults are what a deploy-model template fills in; flags and the spec file
+// override them
+type deploymentDefaults struct {
+	Image          string
+	Ports          []string
+	Preset         string
+	Command        []string
+	Args           []string
+	Env            []corev1.EnvVar
+	Volumes        []string
+	LivenessProbe  *corev1.Probe
+	ReadinessProbe *corev1.Probe
+	StartupProbe   *corev1.Probe
+	Annotations    map[string]string
+	ScaleOn        []string
+	Canary         *canaryAnalysis
+}
+
+// runDeployment creates or updates the deployment, service and ScaledObject from the flags
+func runDeployment(cmd *cobra.Command, defaults deploymentDefaults) {
+	// Retrieve user inputs
+	name, _ := cmd.Flags().GetString("name")
+	image, _ := cmd.Flags().GetString("image")
+	if image == "" {
+		image = defaults.Image
+	}
+	namespace, _ := cmd.Flags().GetString("namespace")
+	if namespace == "" {
+		namespace = contextNamespace()
+	}
+	ports, _ := cmd.Flags().GetStringSlice("ports")
+	if len(ports) == 0 {
+		ports = defaults.Ports
+	}
+	if image == "" || len(ports) == 0 {
+		fmt.Println("An image and at least one port are required")
+		return
+	}
+	policy := autoscalingPolicy{
+		CPUUtilization:    flagOrConfig(cmd, "cpu-utilization", "autoscaling.cpuUtilization"),
+		MemoryUtilization: flagOrConfig(cmd, "memory-utilization", "autoscaling.memoryUtilization"),
+		PrometheusAddress: flagOrConfig(cmd, "prometheus-address", "autoscaling.prometheusAddress"),
+	}
+	var err error
+	if policy.Triggers, err = resolveTriggers(cmd, name, namespace, defaults.ScaleOn); err != nil {
+		fmt.Println(err)
+		return
+	}
+	if policy.MinReplicas, err = strconv.Atoi(flagOrConfig(cmd, "min-replicas", "autoscaling.minReplicas")); err != nil {
+		fmt.Println("Invalid minimum replicas:", err)
+		return
+	}
+	if policy.MaxReplicas, err = strconv.Atoi(flagOrConfig(cmd, "max-replicas", "autoscaling.maxReplicas")); err != nil {
+		fmt.Println("Invalid maximum replicas:", err)
+		return
+	}
+	if policy.MinReplicas > policy.MaxReplicas {
+		fmt.Printf("Minimum replicas %d is above the maximum %d\n", policy.MinReplicas, policy.MaxReplicas)
+		return
+	}
+	labels, err := parseLabels(flagOrConfig(cmd, "labels", "labels"))
+	if err != nil {
+		fmt.Println(err)
+		return
+	}
+	preset, err := resolveResources(cmd, defaults.Preset)
+	if err != nil {
+		fmt.Println(err)
+		return
+	}
+	resources, err := preset.requirements()
+	if err != nil {
+		fmt.Println(err)
+		return
+	}
+	specFile, _ := cmd.Flags().GetString("file")
+	spec, err := loadSpecFile(specFile)
+	if err != nil {
+		fmt.Println(err)
+		return
+	}
+	sidecars, _ := cmd.Flags().GetStringArray("sidecar")
+	initContainers, _ := cmd.Flags().GetStringArray("init-container")
+	removed, _ := cmd.Flags().GetStringSlice("remove-container")
+	containerPorts, err := parseContainerPorts(ports)
+	if err != nil {
+		fmt.Println(err)
+		return
+	}
+	registry, _ := configValue("registry")
+	mainContainer := corev1.Container{
+		Name:           name,
+		Image:          image,
+		Ports:          containerPorts,
+		Resources:      resources,
+		Command:        defaults.Command,
+		Args:           defaults.Args,
+		Env:            defaults.Env,
+		LivenessProbe:  defaults.LivenessProbe,
+		ReadinessProbe: defaults.ReadinessProbe,
+		StartupProbe:   defaults.StartupProbe,
+	}
+	if mainContainer, err = overlayContainer(mainContainer, spec.Container); err != nil {
+		fmt.Println(err)
+		return
+	}
+	template := corev1.PodTemplateSpec{Spec: corev1.PodSpec{NodeSelector: preset.NodeSelector}}
+	template.Annotations = defaults.Annotations
+	pod := &template.Spec
+	pod.Containers, pod.InitContainers, err = podContainers(mainContainer, spec, sidecars, initContainers, registry)
+	if err != nil {
+		fmt.Println(err)
+		return
+	}
+	volumeFlags, _ := cmd.Flags().GetStringArray("volume")
+	var volumes []volumeOption
+	for _, v := range append(append([]string{}, defaults.Volumes...), volumeFlags...) {
+		volume, err := parseVolumeFlag(v)
 		if err != nil {
-			panic(err.Error())
+			fmt.Println("Invalid --volume:", err)
+			return
+		}
+		volumes = withVolume(volumes, volume)
+	}
+	if err := addVolumes(pod, name, volumes); err != nil {
+		fmt.Println(err)
+		return
+	}
+	removedVolumes, _ := cmd.Flags().GetStringSlice("remove-volume")
+	for _, w := range volumeWarnings(volumes, policy.MaxReplicas) {
+		fmt.Println("Warning:", w)
+	}
+	for _, r := range removed {
+		for _, c := range append(append([]corev1.Container{}, pod.Containers...), pod.InitContainers...) {
+			if c.Name == r {
+				fmt.Printf("Container %s is both removed and deployed\n", r)
+				return
+			}
 		}
+	}
 
-		// Create or update the deployment
-		deployment := createDeployment(name, namespace, image, ports, cpuRequest, cpuLimit, ramRequest, ramLimit, clientset)
-		// Create Service
-		service := createService(name, namespace, ports, clientset)
+	skipPreflight, _ := cmd.Flags().GetBool("skip-preflight")
+	canary, _ := cmd.Flags().GetBool("canary")
+	strategy, _ := cmd.Flags().GetString("strategy")
+	keepPrevious, _ := cmd.Flags().GetDuration("keep-previous")
+	verification := blueGreenVerification(cmd)
+	switch {
+	case strategy != StrategyRolling && strategy != StrategyBlueGreen:
+		fmt.Printf("Invalid strategy %q, expected %s or %s\n", strategy, StrategyRolling, StrategyBlueGreen)
+		return
+	case strategy == StrategyBlueGreen && canary:
+		fmt.Println("--canary cannot be combined with --strategy=blue-green")
+		return
+	case verification != nil && strategy != StrategyBlueGreen:
+		fmt.Println("--verify requires --strategy=blue-green")
+		return
+	}
+	var canaryOpts *canaryOptions
+	if canary {
+		if canaryOpts, err = canaryOptionsFromFlags(cmd, defaults.Canary, policy.PrometheusAddress); err != nil {
+			fmt.Println(err)
+			return
+		}
+	}
+
+	clientset, err := GetK8sClient()
+	if err != nil {
+		panic(err.Error())
+	}
+	if err := checkNativeSidecars(clientset, pod.InitContainers); err != nil {
+		fmt.Println(err)
+		return
+	}
+	// A rolling update of a blue/green deployment goes to its live color
+	liveColor, err := serviceColor(clientset, namespace, name)
+	if err != nil {
+		fmt.Println(err)
+		return
+	}
+	if !skipPreflight {
+		permissions := append(createDeploymentPermissions(namespace), volumePermissions(namespace, volumes)...)
+		if canary {
+			permissions = append(permissions, canaryPermissions(namespace)...)
+		}
+		if strategy == StrategyBlueGreen || liveColor != "" {
+			permissions = append(permissions, blueGreenPermissions(namespace, verification != nil)...)
+		}
+		if err := preflightRBAC(clientset, "create-deployment", permissions); err != nil {
+			fmt.Println(err)
+			return
+		}
+		results := preflightQuota(clientset, namespace, name, pod.Containers, pod.InitContainers, policy)
+		printCheckResults(results)
+		for _, result := range results {
+			if result.Status == CheckFail {
+				return
+			}
+		}
+	}
 
-		// Create HPA
-		err = createScaleObject(name, namespace, cpuTarget, memoryTarget, clientset)
+	if err := ensureClaims(clientset, namespace, name, volumes); err != nil {
+		fmt.Println(err)
+		return
+	}
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	// The deployment the service sends to and the ScaledObject scales
+	target := name
+	blueGreen := &blueGreenRollout{clientset: clientset, namespace: namespace, name: name}
+	var color, previous string
+	switch {
+	case canary:
+		rollout, err := newCanaryRollout(clientset, namespace, name)
 		if err != nil {
-			fmt.Printf("Error creating KEDA Scale Object: %v", err)
+			fmt.Println(err)
+			return
 		}
-		// Print deployment and service details
-		fmt.Printf("Deployment Name: %s\n", deployment.Name)
-		fmt.Printf("Service Name: %s\n", service.Name)
-		fmt.Printf("Service IP: %s\n", service.Spec.LoadBalancerIP) // Print service IP
-	},
+		if err := rollout.run(ctx, template, removed, removedVolumes, labels, canaryOpts); err != nil {
+			fmt.Println(err)
+			os.Exit(1)
+		}
+	case strategy == StrategyBlueGreen:
+		if color, previous, err = blueGreen.deploy(ctx, template, removed, removedVolumes, labels); err != nil {
+			fmt.Println(err)
+			os.Exit(1)
+		}
+		target = blueGreen.colorDeployment(color)
+	default:
+		if liveColor != "" {
+			target = blueGreen.colorDeployment(liveColor)
+		}
+		// Create or update the deployment
+		createDeployment(target, namespace, template, removed, removedVolumes, labels, clientset)
+	}
+	// Create Service
+	service := createService(name, namespace, ports, labels, clientset)
+
+	if strategy == StrategyBlueGreen {
+		if verification != nil {
+			if err := blueGreen.verify(ctx, color, verification); err != nil {
+				fmt.Println(err)
+				if previous != "" {
+					blueGreen.discard(color)
+				}
+				os.Exit(1)
+			}
+		}
+		if err := blueGreen.cutover(color, keepPrevious); err != nil {
+			fmt.Println(err)
+			os.Exit(1)
+		}
+	} else if liveColor != "" && !canary {
+		if err := blueGreen.retireExpired(os.Stdout); err != nil {
+			fmt.Println(err)
+			os.Exit(1)
+		}
+	}
+
+	// Create HPA
+	err = createScaleObject(name, namespace, target, policy, labels, clientset)
+	if err != nil {
+		fmt.Printf("Error creating KEDA Scale Object: %v", err)
+	}
+	// Print deployment and service details
+	fmt.Printf("Deployment Name: %s\n", target)
+	fmt.Printf("Service Name: %s\n", service.Name)
+	fmt.Printf("Service IP: %s\n", service.Spec.LoadBalancerIP) // Print service IP
 }
 
-func createDeployment(name, namespace, image string, ports []string, cpuReq, cpuLimit, ramReq, ramLimit string, clientset *kubernetes.Clientset) *appsv1.Deployment {
-	// Check if the deployment already exists
+// withRegistry prefixes images that name no registry, e.g. "vllm/vllm-openai" but not "ghcr.io/org/app"
+func withRegistry(image, registry string) string {
+	if registry == "" {
+		return image
+	}
+	if host, _, ok := strings.Cut(image, "/"); ok && (strings.ContainsAny(host, ".:") || host == "localhost") {
+		return image
+	}
+	return strings.TrimSuffix(registry, "/") + "/" + image
+}
+
+// withLabels adds the labels to base; base wins on conflicts so selectors keep matching
+func withLabels(base, labels map[string]string) map[string]string {
+	result := map[string]string{}
+	for k, v := range labels {
+		result[k] = v
+	}
+	for k, v := range base {
+		result[k] = v
+	}
+	return result
+}
+
+func parseContainerPorts(ports []string) ([]corev1.ContainerPort, error) {
 	containerPorts := []corev1.ContainerPort{}
 	for _, p := range ports {
-		port, _ := strconv.ParseInt(p, 10, 32)
+		port, err := strconv.ParseInt(p, 10, 32)
+		if err != nil {
+			return nil, fmt.Errorf("invalid port value '%s': %v", p, err)
+		}
 		containerPorts = append(containerPorts, corev1.ContainerPort{
 			ContainerPort: int32(port),
 		})
 	}
+	return containerPorts, nil
+}
+
+// createDeployment creates the deployment or updates the containers and volumes of an existing
+// one by name, keeping those it does not know about unless they are removed
+func createDeployment(name, namespace string, template corev1.PodTemplateSpec, removed, removedVolumes []string, labels map[string]string, clientset *kubernetes.Clientset) *appsv1.Deployment {
+	pod := template.Spec
+	// Check if the deployment already exists
 	existingDeployment, err := clientset.AppsV1().Deployments(namespace).Get(context.TODO(), name, metav1.GetOptions{})
 	if err != nil {
 		if k8serrors.IsNotFound(err) {
@@ -72,6 +396,7 @@ func createDeployment(name, namespace, image string, ports []string, cpuReq, cpu
 				ObjectMeta: metav1.ObjectMeta{
 					Name:      name,
 					Namespace: namespace,
+					Labels:    withLabels(map[string]string{"app": name}, labels),
 				},
 				Spec: appsv1.DeploymentSpec{
 					Replicas: int32Ptr(1),
@@ -80,27 +405,10 @@ func createDeployment(name, namespace, image string, ports []string, cpuReq, cpu
 					},
 					Template: corev1.PodTemplateSpec{
 						ObjectMeta: metav1.ObjectMeta{
-							Labels: map[string]string{"app": name},
-						},
-						Spec: corev1.PodSpec{
-							Containers: []corev1.Container{
-								{
-									Name:  name,
-									Image: image,
-									Ports: containerPorts,
-									Resources: corev1.ResourceRequirements{
-										Requests: corev1.ResourceList{
-											corev1.ResourceCPU:    resource.MustParse(cpuReq),
-											corev1.ResourceMemory: resource.MustParse(ramReq),
-										},
-										Limits: corev1.ResourceList{
-											corev1.ResourceCPU:    resource.MustParse(cpuLimit),
-											corev1.ResourceMemory: resource.MustParse(ramLimit),
-										},
-									},
-								},
-							},
+							Labels:      withLabels(map[string]string{"app": name}, labels),
+							Annotations: template.Annotations,
 						},
+						Spec: pod,
 					},
 				},
 			}
@@ -114,11 +422,8 @@ func createDeployment(name, namespace, image string, ports []string, cpuReq, cpu
 		panic(fmt.Errorf("failed to get deployment: %v", err))
 	} else {
 		// Deployment exists, update it
-		existingDeployment.Spec.Template.Spec.Containers[0].Image = image
-		existingDeployment.Spec.Template.Spec.Containers[0].Resources.Requests[corev1.ResourceCPU] = resource.MustParse(cpuReq)
-		existingDeployment.Spec.Template.Spec.Containers[0].Resources.Requests[corev1.ResourceMemory] = resource.MustParse(ramReq)
-		existingDeployment.Spec.Template.Spec.Containers[0].Resources.Limits[corev1.ResourceCPU] = resource.MustParse(cpuLimit)
-		existingDeployment.Spec.Template.Spec.Containers[0].Resources.Limits[corev1.ResourceMemory] = resource.MustParse(ramLimit)
+		existingDeployment.Labels = withLabels(existingDeployment.Labels, labels)
+		mergeTemplate(&existingDeployment.Spec.Template, template, removed, removedVolumes, labels)
 		_, err = clientset.AppsV1().Deployments(namespace).Update(context.TODO(), existingDeployment, metav1.UpdateOptions{})
 		if err != nil {
 			panic(fmt.Errorf("failed to update deployment: %v", err))
@@ -128,7 +433,24 @@ func createDeployment(name, namespace, image string, ports []string, cpuReq, cpu
 	}
 }
 
-func createService(name, namespace string, ports []string, clientset *kubernetes.Clientset) *corev1.Service {
+// mergeTemplate applies the desired pod template to an existing one, keeping the containers and
+// volumes it does not know about unless they are removed
+func mergeTemplate(existing *corev1.PodTemplateSpec, template corev1.PodTemplateSpec, removed, removedVolumes []string, labels map[string]string) {
+	pod := template.Spec
+	existing.Labels = withLabels(existing.Labels, labels)
+	existing.Annotations = withLabels(template.Annotations, existing.Annotations)
+	existing.Spec.Containers = mergeContainers(existing.Spec.Containers, pod.Containers, removed)
+	existing.Spec.InitContainers = mergeContainers(existing.Spec.InitContainers, pod.InitContainers, removed)
+	existing.Spec.Volumes = mergeVolumes(existing.Spec.Volumes, pod.Volumes, removedVolumes)
+	dropVolumeMounts(existing.Spec.Containers, removedVolumes)
+	dropVolumeMounts(existing.Spec.InitContainers, removedVolumes)
+	// Only a preset with a node pool moves the pods, keeping one set by hand otherwise
+	if len(pod.NodeSelector) > 0 {
+		existing.Spec.NodeSelector = pod.NodeSelector
+	}
+}
+
+func createService(name, namespace string, ports []string, labels map[string]string, clientset *kubernetes.Clientset) *corev1.Service {
 	servicePorts := make([]corev1.ServicePort, 0, len(ports)) // Preallocate slice
 	for i, portStr := range ports {
 		port, err := strconv.ParseInt(portStr, 10, 32)
@@ -150,6 +472,7 @@ func createService(name, namespace string, ports []string, clientset *kubernetes
 				ObjectMeta: metav1.ObjectMeta{
 					Name:      fmt.Sprintf("%s-service", name),
 					Namespace: namespace,
+					Labels:    labels,
 				},
 				Spec: corev1.ServiceSpec{
 					Selector: map[string]string{"app": name},
@@ -168,6 +491,7 @@ func createService(name, namespace string, ports []string, clientset *kubernetes
 	}
 
 	// Service exists, patch it
+	existingService.Labels = withLabels(existingService.Labels, labels)
 	existingService.Spec.Ports = servicePorts
 	updatedService, err := clientset.CoreV1().Services(namespace).Update(context.TODO(), existingService, metav1.UpdateOptions{})
 	if err != nil {
@@ -176,36 +500,48 @@ func createService(name, namespace string, ports []string, clientset *kubernetes
 	fmt.Printf("Updated service %s\n", updatedService.Name)
 	return updatedService
 }
-func createScaleObject(name, namespace, cpuTarget, memoryTarget string, clientset *kubernetes.Clientset) error {
+
+// createScaleObject creates or updates the ScaledObject of the deployment, scaling target, the
+// live color of a blue/green deployment
+func createScaleObject(name, namespace, target string, policy autoscalingPolicy, labels map[string]string, clientset *kubernetes.Clientset) error {
+	cpuTarget, memoryTarget := policy.CPUUtilization, policy.MemoryUtilization
+	var triggers []map[string]interface{}
+	for _, t := range policy.Triggers {
+		metadata := map[string]interface{}{
+			"serverAddress": policy.PrometheusAddress,
+			"query":         t.Query,
+			"threshold":     t.Threshold,
+			"queryValue":    "value",
+		}
+		if t.ActivationThreshold != "" {
+			metadata["activationThreshold"] = t.ActivationThreshold
+		}
+		triggers = append(triggers, map[string]interface{}{
+			"type":       "prometheus",
+			"name":       t.Name,
+			"metricType": t.MetricType,
+			"metadata":   metadata,
+		})
+	}
 	scaledObject := map[string]interface{}{
 		"apiVersion": "keda.sh/v1alpha1",
 		"kind":       "ScaledObject",
 		"metadata": map[string]interface{}{
 			"name":      name,
 			"namespace": namespace,
+			"labels":    labels,
 		},
 		"spec": map[string]interface{}{
 			"scaleTargetRef": map[string]interface{}{
 				"apiVersion": "apps/v1",
 				"kind":       "Deployment",
-				"name":       name,
+				"name":       target,
 			},
 			"pollingInterval": 15,
 			"cooldownPeriod":  300,
-			"minReplicaCount": 2,
-			"maxReplicaCount": 10,
-			"triggers": []map[string]interface{}{
-				{
-					"type": "prometheus",
-					"metadata": map[string]interface{}{
-						"serverAddress":       "http://prometheus-server.monitoring.svc.cluster.local",
-						"query":               fmt.Sprintf(`avg(rate(http_request_duration_seconds_sum{app="%s"}[5m])/rate(http_request_duration_seconds_count{app="%s"}[5m]))`, name, name),
-						"threshold":           "0.5",
-						"activationThreshold": "0.4",
-						"queryValue":          "value",
-					},
-				},
-			},
+			"minReplicaCount": policy.MinReplicas,
+			"maxReplicaCount": policy.MaxReplicas,
+			"triggers":        triggers,
 		},
 	}
 	if cpuTarget != "" { // Check if cpuTarget is provided
@@ -225,7 +561,7 @@ func createScaleObject(name, namespace, cpuTarget, memoryTarget string, clientse
 			"metricType": "Utilization", // Allowed types are 'Utilization' or 'AverageValue'
 			"metadata": map[string]interface{}{
 				"type":  "Utilization", // Deprecated in favor of trigger.metricType; allowed types are 'Utilization' or 'AverageValue'
-				"value": cpuTarget,
+				"value": memoryTarget,
 			},
 		})
 	}
@@ -291,19 +627,52 @@ func createScaleObject(name, namespace, cpuTarget, memoryTarget string, clientse
 	return nil
 }
 func int32Ptr(i int32) *int32 { return &i }
+
+// addDeploymentFlags registers the flags create-deployment and deploy-model share
+func addDeploymentFlags(cmd *cobra.Command) {
+	cmd.Flags().String("name", "", "Name of the deployment")
+	cmd.Flags().String("image", "", "Docker image and tag (e.g., nginx:latest)")
+	cmd.Flags().String("namespace", "", "Namespace of the Deployment (defaults to the context's namespace)")
+	cmd.Flags().String("preset", "", "Resource preset, see 'config preset list'; the flags below override it (config resources.preset)")
+	cmd.Flags().String("cpu-request", "", "CPU request for the deployment (config resources.cpuRequest, default 100m)")
+	cmd.Flags().String("cpu-limit", "", "CPU limit for the deployment (config resources.cpuLimit, default 500m)")
+	cmd.Flags().String("ram-request", "", "RAM request for the deployment (config resources.memoryRequest, default 128Mi)")
+	cmd.Flags().String("ram-limit", "", "RAM limit for the deployment (config resources.memoryLimit, default 512Mi)")
+	cmd.Flags().String("gpus", "", "Number of nvidia.com/gpu per pod (config resources.gpu)")
+	cmd.Flags().StringSlice("ports", []string{}, "Ports to expose (e.g., 80,443)")
+	cmd.Flags().String("cpu-utilization", "", "HPA target metric cpu (config autoscaling.cpuUtilization)")
+	cmd.Flags().String("memory-utilization", "", "HPA target metric memory (config autoscaling.memoryUtilization)")
+	cmd.Flags().String("min-replicas", "", "Minimum replicas of the ScaledObject (config autoscaling.minReplicas, default 2)")
+	cmd.Flags().String("max-replicas", "", "Maximum replicas of the ScaledObject (config autoscaling.maxReplicas, default 10)")
+	cmd.Flags().String("prometheus-address", "", "Prometheus queried by the ScaledObject (config autoscaling.prometheusAddress)")
+	cmd.Flags().StringSlice("scale-on", nil, "Autoscaling profiles as PROFILE[=THRESHOLD], see 'autoscale profiles' (config autoscaling.scaleOn, default latency)")
+	cmd.Flags().String("scale-query", "", "Custom PromQL trigger, scaled on with --scale-threshold per replica")
+	cmd.Flags().String("scale-threshold", "", "Value of --scale-query per replica")
+	cmd.Flags().String("labels", "", "Labels added to every resource, e.g. team=ml,env=dev (config labels)")
+	cmd.Flags().StringP("file", "f", "", "Spec file with container, initContainers and sidecars sections in the Kubernetes container schema")
+	cmd.Flags().StringArray("sidecar", nil, "Sidecar container as name=NAME,image=IMAGE[,port=PORT][,command=CMD][,cpu=CPU][,memory=MEMORY][,native=true] (repeatable)")
+	cmd.Flags().StringArray("init-container", nil, "Init container as name=NAME,image=IMAGE[,command=CMD][,cpu=CPU][,memory=MEMORY] (repeatable)")
+	cmd.Flags().StringSlice("remove-container", nil, "Names of sidecars or init containers to remove from an existing deployment")
+	cmd.Flags().StringArray("volume", nil, "Volume as type=TYPE[,name=NAME][,source=SOURCE][,mount=PATH][,subPath=PATH][,readOnly=true][,size=SIZE][,storageClass=CLASS][,accessMode=MODE][,containers=A;B] "+
+		"with TYPE emptyDir, shm, pvc, cache, hostPath, configMap or secret (repeatable, see 'create-deployment --help')")
+	cmd.Flags().StringSlice("remove-volume", nil, "Names of volumes to remove from an existing deployment")
+	cmd.Flags().Bool("skip-preflight", false, "Skip the permission, LimitRange and ResourceQuota checks before creating resources")
+	cmd.Flags().String("strategy", StrategyRolling, "How an existing deployment is updated: rolling, or blue-green to run the new version as the other color and switch the service to it once ready")
+	cmd.Flags().Duration("keep-previous", 30*time.Minute, "How long blue-green keeps the previous color for 'switch' before the next command on the deployment scales it to zero")
+	cmd.Flags().Bool("verify", false, "With blue-green, send a test-endpoint request to the new color before switching to it")
+	cmd.Flags().String("verify-path", "", "Path --verify sends a GET to instead of the model server's request")
+	cmd.Flags().String("verify-expect", "", "Text the --verify response must contain")
+	cmd.Flags().Bool("canary", false, "Deploy as <name>-canary next to the running version, promoting or aborting it from Prometheus metrics")
+	cmd.Flags().Int("weight", 10, "Percentage of the traffic the canary starts with")
+	cmd.Flags().IntSlice("canary-steps", []int{25, 50}, "Further traffic percentages of the canary before promotion")
+	cmd.Flags().Duration("step-duration", 5*time.Minute, "How long the canary runs at each step")
+	cmd.Flags().Float64("max-error-rate", 0.01, "Error rate the canary may have above the stable version, 0.01 being 1%")
+	cmd.Flags().Float64("max-latency-ratio", 1.2, "Latency of the canary relative to the stable version above which it fails")
+}
+
 func init() {
-	CreateDeploymentCmd.Flags().String("name", "", "Name of the deployment")
-	CreateDeploymentCmd.Flags().String("image", "", "Docker image and tag (e.g., nginx:latest)")
-	CreateDeploymentCmd.Flags().String("namespace", "", "Namespace of the Deployment")
-	CreateDeploymentCmd.Flags().String("cpu-request", "100m", "CPU request for the deployment")
-	CreateDeploymentCmd.Flags().String("cpu-limit", "500m", "CPU limit for the deployment")
-	CreateDeploymentCmd.Flags().String("ram-request", "128Mi", "RAM request for the deployment")
-	CreateDeploymentCmd.Flags().String("ram-limit", "512Mi", "RAM limit for the deployment")
-	CreateDeploymentCmd.Flags().StringSlice("ports", []string{}, "Ports to expose (e.g., 80,443)")
-	CreateDeploymentCmd.Flags().String("cpu-utilization", "", "HPA target metric cpu")
-	CreateDeploymentCmd.Flags().String("memory-utilization", "", "HPA target metric memory")
+	addDeploymentFlags(CreateDeploymentCmd)
 	CreateDeploymentCmd.MarkFlagRequired("image")
 	CreateDeploymentCmd.MarkFlagRequired("name")
-	CreateDeploymentCmd.MarkFlagRequired("namespace")
 	CreateDeploymentCmd.MarkFlagRequired("ports")
 }
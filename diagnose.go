@@ -0,0 +1,161 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/fields"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/kubernetes"
+)
+
+// A diagnosis explains why a workload is unhealthy and how to fix it
+type diagnosis struct {
+	Object  string `json:"object"`
+	Reason  string `json:"reason"`
+	Message string `json:"message"`
+	Fix     string `json:"fix"`
+}
+
+var insufficientResource = regexp.MustCompile(`Insufficient ([\w./-]+)`)
+
+// diagnosePod inspects container states and restarts of a pod
+func diagnosePod(pod *corev1.Pod) []diagnosis {
+	var diagnoses []diagnosis
+	images := map[string]string{}
+	for _, c := range pod.Spec.InitContainers {
+		images[c.Name] = c.Image
+	}
+	for _, c := range pod.Spec.Containers {
+		images[c.Name] = c.Image
+	}
+	statuses := append([]corev1.ContainerStatus{}, pod.Status.InitContainerStatuses...)
+	statuses = append(statuses, pod.Status.ContainerStatuses...)
+	for _, cs := range statuses {
+		object := fmt.Sprintf("pod/%s container/%s", pod.Name, cs.Name)
+		if waiting := cs.State.Waiting; waiting != nil {
+			switch waiting.Reason {
+			case "ImagePullBackOff", "ErrImagePull", "InvalidImageName":
+				diagnoses = append(diagnoses, diagnosis{
+					Object:  object,
+					Reason:  waiting.Reason,
+					Message: fmt.Sprintf("cannot pull image %s: %s", images[cs.Name], waiting.Message),
+					Fix:     "Check the image name and tag exist and that the namespace has an imagePullSecret for the registry",
+				})
+			case "CrashLoopBackOff":
+				message := fmt.Sprintf("container restarted %d times", cs.RestartCount)
+				if last := cs.LastTerminationState.Terminated; last != nil {
+					message += fmt.Sprintf(", last exit code %d (%s)", last.ExitCode, last.Reason)
+				}
+				diagnoses = append(diagnoses, diagnosis{
+					Object:  object,
+					Reason:  waiting.Reason,
+					Message: message,
+					Fix:     "Inspect the crash with 'simplismart-cli logs --previous' and fix the startup error",
+				})
+			case "CreateContainerConfigError", "CreateContainerError":
+				diagnoses = append(diagnoses, diagnosis{
+					Object:  object,
+					Reason:  waiting.Reason,
+					Message: waiting.Message,
+					Fix:     "Make sure every referenced ConfigMap, Secret and key exists in the namespace",
+				})
+			}
+		}
+		if last := cs.LastTerminationState.Terminated; last != nil && last.Reason == "OOMKilled" {
+			diagnoses = append(diagnoses, diagnosis{
+				Object:  object,
+				Reason:  "OOMKilled",
+				Message: fmt.Sprintf("container was killed for exceeding its memory limit (restarts: %d)", cs.RestartCount),
+				Fix:     "Raise --ram-limit or reduce the memory footprint of the model",
+			})
+		}
+	}
+	return diagnoses
+}
+
+// diagnoseEvents turns known warning events into diagnoses
+func diagnoseEvents(events []corev1.Event) []diagnosis {
+	var diagnoses []diagnosis
+	seen := map[string]bool{}
+	for _, event := range events {
+		object := fmt.Sprintf("%s/%s", strings.ToLower(event.InvolvedObject.Kind), event.InvolvedObject.Name)
+		var d *diagnosis
+		switch event.Reason {
+		case "FailedScheduling":
+			fix := "Check node selectors, taints and tolerations"
+			if matches := insufficientResource.FindAllStringSubmatch(event.Message, -1); len(matches) > 0 {
+				resources := []string{}
+				for _, m := range matches {
+					resources = append(resources, m[1])
+				}
+				fix = fmt.Sprintf("Cluster has insufficient %s; lower the requests or add nodes with more capacity", strings.Join(resources, ", "))
+			}
+			d = &diagnosis{Object: object, Reason: event.Reason, Message: event.Message, Fix: fix}
+		case "Unhealthy":
+			d = &diagnosis{Object: object, Reason: "ProbeFailed", Message: event.Message,
+				Fix: "Check the probe path and port, or raise initialDelaySeconds for slow model loading"}
+		case "FailedMount", "FailedAttachVolume":
+			d = &diagnosis{Object: object, Reason: event.Reason, Message: event.Message,
+				Fix: "Make sure the volume, PVC or Secret exists and is available in the pod's zone"}
+		case "FailedCreate":
+			fix := "Check the pod template against namespace policies"
+			if strings.Contains(event.Message, "exceeded quota") {
+				fix = "Namespace ResourceQuota is exhausted; lower the requests or raise the quota"
+			}
+			d = &diagnosis{Object: object, Reason: event.Reason, Message: event.Message, Fix: fix}
+		case "Evicted":
+			d = &diagnosis{Object: object, Reason: event.Reason, Message: event.Message,
+				Fix: "Node ran out of resources; set requests closer to real usage"}
+		}
+		if d != nil && !seen[d.Object+d.Reason] {
+			seen[d.Object+d.Reason] = true
+			diagnoses = append(diagnoses, *d)
+		}
+	}
+	return diagnoses
+}
+
+// getWorkloadWarningEvents returns recent Warning events for the workload, its ReplicaSets and pods
+func getWorkloadWarningEvents(clientset *kubernetes.Clientset, w *workload, pods []corev1.Pod) ([]corev1.Event, error) {
+	objects := map[types.UID]bool{w.UID: true}
+	for uid := range w.owners {
+		objects[uid] = true
+	}
+	for _, pod := range pods {
+		objects[pod.UID] = true
+	}
+
+	events, err := clientset.CoreV1().Events(w.Namespace).List(context.TODO(), metav1.ListOptions{
+		FieldSelector: fields.OneTermEqualSelector("type", corev1.EventTypeWarning).String(),
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to list events: %v", err)
+	}
+	var result []corev1.Event
+	for _, event := range events.Items {
+		if objects[event.InvolvedObject.UID] {
+			result = append(result, event)
+		}
+	}
+	sort.Slice(result, func(i, j int) bool {
+		return eventTime(result[i]).After(eventTime(result[j]))
+	})
+	return result, nil
+}
+
+func eventTime(event corev1.Event) time.Time {
+	if !event.LastTimestamp.IsZero() {
+		return event.LastTimestamp.Time
+	}
+	if !event.EventTime.IsZero() {
+		return event.EventTime.Time
+	}
+	return event.CreationTimestamp.Time
+}
@@ -1,32 +1,476 @@
 package main
+
 import (
-	// "context"
+	"context"
+	"encoding/json"
 	"fmt"
 	"os"
 	"os/exec"
+	"runtime/debug"
+	"strconv"
+	"strings"
+	"time"
 
-	// "k8s.io/client-go/tools/clientcmd"
 	"github.com/spf13/cobra"
-	// "k8s.io/client-go/tools/clientcmd/api"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/version"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/tools/clientcmd"
+)
+
+// Check outcomes, in order of severity
+const (
+	CheckPass = "pass"
+	CheckWarn = "warn"
+	CheckFail = "fail"
 )
 
-// New doctor command to check if Helm is installed
+type checkResult struct {
+	Name    string       `json:"name"`
+	Status  string       `json:"status"`
+	Message string       `json:"message"`
+	Hint    string       `json:"hint,omitempty"`
+	Missing []permission `json:"missing,omitempty"`
+}
+
+type doctorReport struct {
+	Status string        `json:"status"`
+	Checks []checkResult `json:"checks"`
+	RBAC   string        `json:"rbac,omitempty"`
+}
+
+// doctorEnv is shared by the checks; clientset is nil when the cluster cannot be reached
+type doctorEnv struct {
+	clientset     *kubernetes.Clientset
+	serverVersion *version.Version
+}
+
+type doctorCheck struct {
+	name string
+	run  func(env *doctorEnv) checkResult
+}
+
+var doctorChecks = []doctorCheck{
+	{"kubeconfig", checkKubeconfig},
+	{"api-server", checkAPIServer},
+	{"helm-releases", checkHelmReleases},
+	{"version-skew", checkVersionSkew},
+	{"metrics-server", checkMetricsServer},
+	{"keda", checkKEDA},
+	{"prometheus", checkPrometheus},
+	{"storage-class", checkDefaultStorageClass},
+	{"gpu-device-plugin", checkGPUDevicePlugin},
+	{"load-balancer", checkLoadBalancer},
+}
+
+// Doctor command to check the cluster is ready for every CLI feature
 var DoctorCmd = &cobra.Command{
 	Use:   "doctor",
-	Short: "Check if Helm is installed",
+	Short: "Check the local tools and the cluster are ready",
 	Run: func(cmd *cobra.Command, args []string) {
-		// Logic to check if Helm is installed
-		if _, err := exec.LookPath("helm"); err != nil {
-			fmt.Println("Helm is not installed.")
+		output := outputFormat(cmd, "text", "json")
+		rbac, _ := cmd.Flags().GetBool("rbac")
+		namespace, _ := cmd.Flags().GetString("namespace")
+		if namespace == "" {
+			namespace = contextNamespace()
+		}
+		if output != "text" && output != "json" {
+			fmt.Printf("Invalid output format %q, expected text or json\n", output)
 			os.Exit(1)
 		}
-		fmt.Println("Helm is installed.")
-		versionCmd := exec.Command("helm", "version", "--short")
-		versionOutput, err := versionCmd.Output()
-		if err != nil {
-			fmt.Println("Error retrieving Helm version:", err)
+
+		var report doctorReport
+		if rbac {
+			report = checkRBAC(namespace)
+		} else {
+			env := &doctorEnv{}
+			for _, check := range doctorChecks {
+				result := check.run(env)
+				result.Name = check.name
+				report.Checks = append(report.Checks, result)
+			}
+		}
+		report.Status = CheckPass
+		for _, result := range report.Checks {
+			if result.Status == CheckFail || (result.Status == CheckWarn && report.Status == CheckPass) {
+				report.Status = result.Status
+			}
+		}
+
+		if output == "json" {
+			data, _ := json.MarshalIndent(report, "", "  ")
+			fmt.Println(string(data))
+		} else {
+			printCheckResults(report.Checks)
+			if report.RBAC != "" {
+				fmt.Println("\nAsk a cluster admin to apply:")
+				fmt.Print(report.RBAC)
+			}
+		}
+		if report.Status == CheckFail {
 			os.Exit(1)
 		}
-		fmt.Printf("Helm version: %s\n", string(versionOutput))
 	},
-}
\ No newline at end of file
+}
+
+func printCheckResults(checks []checkResult) {
+	labels := map[string]string{CheckPass: "[PASS]", CheckWarn: "[WARN]", CheckFail: "[FAIL]"}
+	for _, c := range checks {
+		fmt.Printf("%s %s: %s\n", labels[c.Status], c.Name, c.Message)
+		if c.Hint != "" && c.Status != CheckPass {
+			fmt.Printf("       Hint: %s\n", c.Hint)
+		}
+	}
+}
+
+// checkRBAC verifies the current user holds every permission each command needs
+func checkRBAC(namespace string) doctorReport {
+	var report doctorReport
+	clientset, err := GetK8sClient()
+	if err != nil {
+		report.Checks = append(report.Checks, checkResult{Name: "rbac", Status: CheckFail, Message: err.Error(), Hint: "Fix the kubeconfig first"})
+		return report
+	}
+	var allMissing []permission
+	for _, c := range commandPermissions {
+		missing, err := missingPermissions(clientset, c.permissions(namespace))
+		if err != nil {
+			report.Checks = append(report.Checks, checkResult{Name: c.command, Status: CheckFail, Message: err.Error()})
+			continue
+		}
+		if len(missing) == 0 {
+			report.Checks = append(report.Checks, checkResult{Name: c.command, Status: CheckPass, Message: "all permissions granted"})
+			continue
+		}
+		names := make([]string, 0, len(missing))
+		for _, p := range missing {
+			names = append(names, p.String())
+		}
+		report.Checks = append(report.Checks, checkResult{Name: c.command, Status: CheckFail, Missing: missing,
+			Message: "missing " + strings.Join(names, ", "), Hint: "Apply the generated Role and RoleBinding"})
+		allMissing = append(allMissing, missing...)
+	}
+	if len(allMissing) > 0 {
+		report.RBAC = generateRBACYAML(clientset, "user", dedupePermissions(allMissing))
+	}
+	return report
+}
+
+func dedupePermissions(perms []permission) []permission {
+	seen := map[permission]bool{}
+	var result []permission
+	for _, p := range perms {
+		if !seen[p] {
+			seen[p] = true
+			result = append(result, p)
+		}
+	}
+	return result
+}
+
+// checkHelmReleases flags releases stuck in a failed or pending state
+func checkHelmReleases(env *doctorEnv) checkResult {
+	if env.clientset == nil {
+		return checkResult{Status: CheckWarn, Message: "skipped, cluster is unreachable"}
+	}
+	releases, err := newHelmClient().listReleases()
+	if err != nil {
+		return checkResult{Status: CheckWarn, Message: err.Error(), Hint: "Listing releases needs permission to list secrets"}
+	}
+	var broken []string
+	for _, r := range releases {
+		if r.Status != "deployed" {
+			broken = append(broken, fmt.Sprintf("%s/%s (%s)", r.Namespace, r.Name, r.Status))
+		}
+	}
+	if len(broken) > 0 {
+		return checkResult{Status: CheckWarn, Message: "releases not deployed: " + strings.Join(broken, ", "),
+			Hint: "Upgrade or uninstall them, e.g. 'simplismart-cli addons keda upgrade'"}
+	}
+	return checkResult{Status: CheckPass, Message: fmt.Sprintf("%d releases deployed", len(releases))}
+}
+
+func checkKubeconfig(env *doctorEnv) checkResult {
+	config, err := kubeClientConfig().RawConfig()
+	if err != nil {
+		return checkResult{Status: CheckFail, Message: fmt.Sprintf("cannot load kubeconfig: %v", err),
+			Hint: "Create the kubeconfig with your cluster provider's CLI"}
+	}
+	active, source := activeSelection()
+	if active.Context != "" {
+		config.CurrentContext = active.Context
+	}
+	if err := clientcmd.Validate(config); err != nil {
+		return checkResult{Status: CheckFail, Message: fmt.Sprintf("kubeconfig is invalid: %v", err),
+			Hint: "Fix the kubeconfig or select another context with 'simplismart-cli connect'"}
+	}
+	message := fmt.Sprintf("%s, context %s", kubeconfigPath(), config.CurrentContext)
+	if source != "" {
+		message += " selected by " + source
+	}
+	return checkResult{Status: CheckPass, Message: message}
+}
+
+func checkAPIServer(env *doctorEnv) checkResult {
+	config, err := GetRestConfig()
+	if err != nil {
+		return checkResult{Status: CheckFail, Message: err.Error(), Hint: "Fix the kubeconfig first"}
+	}
+	config.Timeout = 10 * time.Second
+	clientset, err := kubernetes.NewForConfig(config)
+	if err != nil {
+		return checkResult{Status: CheckFail, Message: err.Error(), Hint: "Fix the kubeconfig first"}
+	}
+	start := time.Now()
+	info, err := clientset.Discovery().ServerVersion()
+	latency := time.Since(start)
+	if err != nil {
+		return checkResult{Status: CheckFail, Message: fmt.Sprintf("%s is unreachable: %v", config.Host, err),
+			Hint: "Check VPN or network access to the cluster and that your credentials have not expired"}
+	}
+	env.clientset = clientset
+	env.serverVersion, _ = version.ParseGeneric(info.GitVersion)
+	result := checkResult{Status: CheckPass, Message: fmt.Sprintf("%s reachable in %s", config.Host, latency.Round(time.Millisecond))}
+	if latency > time.Second {
+		result.Status = CheckWarn
+		result.Hint = "The API server is slow to answer; commands may time out"
+	}
+	return result
+}
+
+func checkVersionSkew(env *doctorEnv) checkResult {
+	if env.serverVersion == nil {
+		return checkResult{Status: CheckWarn, Message: "skipped, cluster is unreachable"}
+	}
+	clientName, clientVersion := "client-go", clientGoVersion()
+	if out, err := exec.Command("kubectl", "version", "--client", "-o", "json").Output(); err == nil {
+		var kubectl struct {
+			ClientVersion struct {
+				GitVersion string `json:"gitVersion"`
+			} `json:"clientVersion"`
+		}
+		if json.Unmarshal(out, &kubectl) == nil {
+			if v, err := version.ParseGeneric(kubectl.ClientVersion.GitVersion); err == nil {
+				clientName, clientVersion = "kubectl", v
+			}
+		}
+	}
+	if clientVersion == nil {
+		return checkResult{Status: CheckWarn, Message: "cannot determine the client version"}
+	}
+	skew := int(clientVersion.Minor()) - int(env.serverVersion.Minor())
+	message := fmt.Sprintf("server v%s, %s v%s", env.serverVersion, clientName, clientVersion)
+	if clientVersion.Major() != env.serverVersion.Major() || skew > 1 || skew < -1 {
+		return checkResult{Status: CheckWarn, Message: message + " are more than one minor version apart",
+			Hint: "Install a " + clientName + " within one minor version of the server"}
+	}
+	return checkResult{Status: CheckPass, Message: message}
+}
+
+// clientGoVersion maps the compiled-in client-go v0.X.Y to Kubernetes 1.X.Y
+func clientGoVersion() *version.Version {
+	info, ok := debug.ReadBuildInfo()
+	if !ok {
+		return nil
+	}
+	for _, dep := range info.Deps {
+		if dep.Path == "k8s.io/client-go" {
+			v, err := version.ParseGeneric(strings.Replace(dep.Version, "v0.", "v1.", 1))
+			if err == nil {
+				return v
+			}
+		}
+	}
+	return nil
+}
+
+func checkMetricsServer(env *doctorEnv) checkResult {
+	if env.clientset == nil {
+		return checkResult{Status: CheckWarn, Message: "skipped, cluster is unreachable"}
+	}
+	hint := "Run 'simplismart-cli addons metrics-server install'"
+	if _, err := env.clientset.Discovery().ServerResourcesForGroupVersion("metrics.k8s.io/v1beta1"); err != nil {
+		return checkResult{Status: CheckWarn, Message: "metrics.k8s.io API is not available, health-status will not show usage", Hint: hint}
+	}
+	_, err := env.clientset.RESTClient().Get().AbsPath("/apis/metrics.k8s.io/v1beta1/nodes").DoRaw(context.TODO())
+	if err != nil {
+		return checkResult{Status: CheckWarn, Message: fmt.Sprintf("metrics.k8s.io API is registered but not serving: %v", err),
+			Hint: "Check the metrics-server pods in kube-system"}
+	}
+	return checkResult{Status: CheckPass, Message: "metrics.k8s.io API is serving"}
+}
+
+func checkKEDA(env *doctorEnv) checkResult {
+	if env.clientset == nil {
+		return checkResult{Status: CheckWarn, Message: "skipped, cluster is unreachable"}
+	}
+	resources, err := env.clientset.Discovery().ServerResourcesForGroupVersion("keda.sh/v1alpha1")
+	if err != nil {
+		return checkResult{Status: CheckFail, Message: "KEDA CRDs are not installed, create-deployment cannot create ScaledObjects",
+			Hint: "Run 'simplismart-cli addons keda install'"}
+	}
+	found := false
+	for _, r := range resources.APIResources {
+		if r.Name == "scaledobjects" {
+			found = true
+		}
+	}
+	if !found {
+		return checkResult{Status: CheckFail, Message: "keda.sh/v1alpha1 does not serve scaledobjects",
+			Hint: "Reinstall KEDA with 'simplismart-cli addons keda upgrade'"}
+	}
+	operator, err := env.clientset.AppsV1().Deployments("keda").Get(context.TODO(), "keda-operator", metav1.GetOptions{})
+	if err != nil {
+		return checkResult{Status: CheckFail, Message: fmt.Sprintf("KEDA CRDs are installed but the operator was not found: %v", err),
+			Hint: "Run 'simplismart-cli addons keda install'"}
+	}
+	if operator.Status.AvailableReplicas == 0 {
+		return checkResult{Status: CheckFail, Message: "KEDA operator has no available replicas",
+			Hint: "Run 'simplismart-cli health-status --name keda-operator --namespace keda'"}
+	}
+	return checkResult{Status: CheckPass, Message: fmt.Sprintf("KEDA CRDs installed, operator %d/%d available", operator.Status.AvailableReplicas, operator.Status.Replicas)}
+}
+
+// checkPrometheus makes sure the service behind the configured Prometheus address has ready endpoints
+func checkPrometheus(env *doctorEnv) checkResult {
+	if env.clientset == nil {
+		return checkResult{Status: CheckWarn, Message: "skipped, cluster is unreachable"}
+	}
+	address, _ := configValue("autoscaling.prometheusAddress")
+	service, namespace, port, err := prometheusService(address)
+	if err != nil {
+		return checkResult{Status: CheckWarn, Message: fmt.Sprintf("cannot check Prometheus: %v", err)}
+	}
+	hint := fmt.Sprintf("Run 'simplismart-cli addons prometheus install', the ScaledObject trigger queries service %s in namespace %s", service, namespace)
+	if _, err := env.clientset.CoreV1().Services(namespace).Get(context.TODO(), service, metav1.GetOptions{}); err != nil {
+		return checkResult{Status: CheckFail, Message: fmt.Sprintf("service %s/%s not found", namespace, service), Hint: hint}
+	}
+	endpoints, err := env.clientset.CoreV1().Endpoints(namespace).Get(context.TODO(), service, metav1.GetOptions{})
+	if err != nil || !hasReadyAddresses(endpoints) {
+		return checkResult{Status: CheckFail, Message: fmt.Sprintf("service %s/%s has no ready endpoints", namespace, service),
+			Hint: fmt.Sprintf("Check the Prometheus pods in namespace %s", namespace)}
+	}
+	_, err = env.clientset.CoreV1().Services(namespace).ProxyGet("http", service, port, "/-/ready", nil).DoRaw(context.TODO())
+	if err != nil {
+		return checkResult{Status: CheckWarn, Message: fmt.Sprintf("Prometheus at %s is not ready: %v", address, err),
+			Hint: fmt.Sprintf("Check the Prometheus pods in namespace %s", namespace)}
+	}
+	return checkResult{Status: CheckPass, Message: fmt.Sprintf("Prometheus at %s is ready", address)}
+}
+
+func checkKEDAHTTPAddon(env *doctorEnv) checkResult {
+	if env.clientset == nil {
+		return checkResult{Status: CheckWarn, Message: "skipped, cluster is unreachable"}
+	}
+	hint := "Run 'simplismart-cli addons keda-http-add-on install'"
+	if _, err := env.clientset.Discovery().ServerResourcesForGroupVersion("http.keda.sh/v1alpha1"); err != nil {
+		return checkResult{Status: CheckWarn, Message: "HTTPScaledObject CRD is not installed", Hint: hint}
+	}
+	interceptor, err := env.clientset.AppsV1().Deployments("keda").Get(context.TODO(), "keda-add-ons-http-interceptor", metav1.GetOptions{})
+	if err != nil {
+		return checkResult{Status: CheckFail, Message: fmt.Sprintf("HTTPScaledObject CRD is installed but the interceptor was not found: %v", err), Hint: hint}
+	}
+	if interceptor.Status.AvailableReplicas == 0 {
+		return checkResult{Status: CheckFail, Message: "HTTP add-on interceptor has no available replicas",
+			Hint: "Run 'simplismart-cli health-status --name keda-add-ons-http-interceptor --namespace keda'"}
+	}
+	return checkResult{Status: CheckPass, Message: fmt.Sprintf("HTTP add-on interceptor %d/%d available", interceptor.Status.AvailableReplicas, interceptor.Status.Replicas)}
+}
+
+func checkIngressNginx(env *doctorEnv) checkResult {
+	if env.clientset == nil {
+		return checkResult{Status: CheckWarn, Message: "skipped, cluster is unreachable"}
+	}
+	classes, err := env.clientset.NetworkingV1().IngressClasses().List(context.TODO(), metav1.ListOptions{})
+	if err != nil {
+		return checkResult{Status: CheckWarn, Message: fmt.Sprintf("cannot list ingress classes: %v", err)}
+	}
+	for _, class := range classes.Items {
+		if class.Spec.Controller == "k8s.io/ingress-nginx" {
+			return checkResult{Status: CheckPass, Message: fmt.Sprintf("ingress class %s is served by ingress-nginx", class.Name)}
+		}
+	}
+	return checkResult{Status: CheckWarn, Message: "no ingress class is served by ingress-nginx",
+		Hint: "Run 'simplismart-cli addons ingress-nginx install'"}
+}
+
+func hasReadyAddresses(endpoints *corev1.Endpoints) bool {
+	for _, subset := range endpoints.Subsets {
+		if len(subset.Addresses) > 0 {
+			return true
+		}
+	}
+	return false
+}
+
+func checkDefaultStorageClass(env *doctorEnv) checkResult {
+	if env.clientset == nil {
+		return checkResult{Status: CheckWarn, Message: "skipped, cluster is unreachable"}
+	}
+	classes, err := env.clientset.StorageV1().StorageClasses().List(context.TODO(), metav1.ListOptions{})
+	if err != nil {
+		return checkResult{Status: CheckWarn, Message: fmt.Sprintf("cannot list storage classes: %v", err)}
+	}
+	for _, sc := range classes.Items {
+		if sc.Annotations["storageclass.kubernetes.io/is-default-class"] == "true" {
+			return checkResult{Status: CheckPass, Message: fmt.Sprintf("default storage class %s (%s)", sc.Name, sc.Provisioner)}
+		}
+	}
+	return checkResult{Status: CheckWarn, Message: fmt.Sprintf("no default storage class among %d classes", len(classes.Items)),
+		Hint: "Mark one with the storageclass.kubernetes.io/is-default-class=true annotation so PVCs bind without a class"}
+}
+
+func checkGPUDevicePlugin(env *doctorEnv) checkResult {
+	if env.clientset == nil {
+		return checkResult{Status: CheckWarn, Message: "skipped, cluster is unreachable"}
+	}
+	nodes, err := env.clientset.CoreV1().Nodes().List(context.TODO(), metav1.ListOptions{})
+	if err != nil {
+		return checkResult{Status: CheckWarn, Message: fmt.Sprintf("cannot list nodes: %v", err)}
+	}
+	gpuNodes, gpus := 0, int64(0)
+	for _, node := range nodes.Items {
+		if quantity, ok := node.Status.Allocatable["nvidia.com/gpu"]; ok && !quantity.IsZero() {
+			gpuNodes++
+			gpus += quantity.Value()
+		}
+	}
+	if gpuNodes == 0 {
+		return checkResult{Status: CheckWarn, Message: "no node advertises nvidia.com/gpu",
+			Hint: "Run 'simplismart-cli addons gpu-operator install' on clusters with GPU nodes to schedule GPU models"}
+	}
+	return checkResult{Status: CheckPass, Message: fmt.Sprintf("%d GPUs allocatable on %d nodes", gpus, gpuNodes)}
+}
+
+// checkLoadBalancer looks for evidence that Services of type LoadBalancer get an address
+func checkLoadBalancer(env *doctorEnv) checkResult {
+	if env.clientset == nil {
+		return checkResult{Status: CheckWarn, Message: "skipped, cluster is unreachable"}
+	}
+	services, err := env.clientset.CoreV1().Services("").List(context.TODO(), metav1.ListOptions{})
+	if err != nil {
+		return checkResult{Status: CheckWarn, Message: fmt.Sprintf("cannot list services: %v", err)}
+	}
+	pending := 0
+	for _, svc := range services.Items {
+		if svc.Spec.Type != corev1.ServiceTypeLoadBalancer {
+			continue
+		}
+		if len(svc.Status.LoadBalancer.Ingress) > 0 {
+			return checkResult{Status: CheckPass, Message: fmt.Sprintf("service %s/%s has a load balancer address", svc.Namespace, svc.Name)}
+		}
+		pending++
+	}
+	hint := "Install a load balancer controller (cloud provider or MetalLB); create-deployment exposes services as LoadBalancer"
+	if pending > 0 {
+		return checkResult{Status: CheckWarn, Message: strconv.Itoa(pending) + " LoadBalancer services are waiting for an address", Hint: hint}
+	}
+	return checkResult{Status: CheckWarn, Message: "no LoadBalancer service found to confirm support", Hint: hint}
+}
+
+func init() {
+	DoctorCmd.Flags().StringP("output", "o", "text", "Output format (text or json)")
+	DoctorCmd.Flags().Bool("rbac", false, "Only check the permissions every command needs")
+	DoctorCmd.Flags().String("namespace", "", "Namespace to check permissions in with --rbac (defaults to the context's namespace)")
+}
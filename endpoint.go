@@ -0,0 +1,844 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"os"
+	"os/signal"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/spf13/cobra"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// Request formats of test-endpoint
+const (
+	FormatOpenAI = "openai"
+	FormatKServe = "kserve"
+	FormatRaw    = "raw"
+)
+
+// An endpointRequest is the request test-endpoint sends and how it checks the response. prepare
+// fills in what it can ask the server for: the model and the KServe inputs.
+type endpointRequest struct {
+	Format       string
+	Method       string
+	Path         string
+	Model        string
+	Prompt       string
+	MaxTokens    int
+	Stream       bool
+	Body         []byte
+	Headers      http.Header
+	ExpectStatus int
+	Expect       string
+}
+
+type endpointResult struct {
+	Status  int
+	Latency time.Duration
+	// Time to the first streamed token, zero without --stream
+	TTFT   time.Duration
+	Tokens int
+	Output string
+}
+
+type latencyPercentiles struct {
+	P50 time.Duration
+	P95 time.Duration
+	P99 time.Duration
+}
+
+type benchmarkReport struct {
+	Requests          int
+	Failures          int
+	Concurrency       int
+	Duration          time.Duration
+	RequestsPerSecond float64
+	TokensPerSecond   float64
+	Latency           latencyPercentiles
+	TTFT              *latencyPercentiles
+	// Failures by error message
+	Errors map[string]int
+}
+
+var TestEndpointCmd = &cobra.Command{
+	Use:   "test-endpoint",
+	Short: "Send a request to a deployment and report whether and how fast it answers",
+	Long: `Send a request to the service of a deployment, check the response and report its latency.
+
+The service is reached through its load balancer, or through a port-forward to a ready
+pod when it has none or with --port-forward. --url sends to any server instead.
+
+Formats:
+  openai  OpenAI-compatible /v1/chat/completions, or /v1/completions with --path;
+          the model defaults to the first one /v1/models lists
+  kserve  KServe v2 / Triton inference with zero-filled inputs from the model metadata;
+          the model defaults to the first ready one in the repository
+  raw     any HTTP request, checked with --expect-status and --expect
+
+The format defaults to the one of the deploy-model template the deployment runs, raw
+otherwise. --benchmark sends --requests requests from --concurrency workers and reports
+requests/sec and latency percentiles, and time to first token with --stream.
+The command exits non-zero when a request fails.`,
+	Run: func(cmd *cobra.Command, args []string) {
+		name, _ := cmd.Flags().GetString("name")
+		namespace, _ := cmd.Flags().GetString("namespace")
+		if namespace == "" {
+			namespace = contextNamespace()
+		}
+		baseURL, _ := cmd.Flags().GetString("url")
+		port, _ := cmd.Flags().GetInt32("port")
+		forward, _ := cmd.Flags().GetBool("port-forward")
+		timeout, _ := cmd.Flags().GetDuration("timeout")
+		benchmark, _ := cmd.Flags().GetBool("benchmark")
+		requests, _ := cmd.Flags().GetInt("requests")
+		concurrency, _ := cmd.Flags().GetInt("concurrency")
+		output := outputFormat(cmd, "text", "json")
+		if output != "text" && output != "json" {
+			fmt.Printf("Invalid output format %q, expected text or json\n", output)
+			return
+		}
+		if name == "" && baseURL == "" {
+			fmt.Println("Either --name or --url is required")
+			return
+		}
+		if benchmark && (requests < 1 || concurrency < 1) {
+			fmt.Println("--requests and --concurrency must be at least 1")
+			return
+		}
+		req, err := endpointRequestFromFlags(cmd)
+		if err != nil {
+			fmt.Println(err)
+			return
+		}
+
+		ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+		defer stop()
+		if baseURL == "" {
+			var server *modelServer
+			var closeForward func()
+			baseURL, server, closeForward, err = deploymentEndpoint(namespace, name, port, forward)
+			if err != nil {
+				fmt.Println(err)
+				return
+			}
+			defer closeForward()
+			if req.Format == "" && server != nil {
+				req.Format = server.Protocol
+				if req.Format == FormatRaw && req.Path == "" {
+					req.Path = server.ReadyPath
+				}
+			}
+		}
+		if req.Format == "" {
+			req.Format = FormatRaw
+		}
+		baseURL = strings.TrimSuffix(baseURL, "/")
+
+		client := &http.Client{
+			Timeout:   timeout,
+			Transport: &http.Transport{Proxy: http.ProxyFromEnvironment, MaxIdleConnsPerHost: concurrency},
+		}
+		if err := req.prepare(ctx, client, baseURL); err != nil {
+			fmt.Println(err)
+			os.Exit(1)
+		}
+
+		if benchmark {
+			report := runBenchmark(ctx, client, baseURL, req, requests, concurrency)
+			printBenchmark(report, output)
+			if report.Failures > 0 {
+				os.Exit(1)
+			}
+			return
+		}
+		result, err := req.send(ctx, client, baseURL)
+		printEndpointResult(req, baseURL, result, err, output)
+		if err != nil {
+			os.Exit(1)
+		}
+	},
+}
+
+func endpointRequestFromFlags(cmd *cobra.Command) (*endpointRequest, error) {
+	req := &endpointRequest{Headers: http.Header{}}
+	req.Format, _ = cmd.Flags().GetString("format")
+	req.Method, _ = cmd.Flags().GetString("method")
+	req.Path, _ = cmd.Flags().GetString("path")
+	req.Model, _ = cmd.Flags().GetString("model")
+	req.Prompt, _ = cmd.Flags().GetString("prompt")
+	req.MaxTokens, _ = cmd.Flags().GetInt("max-tokens")
+	req.Stream, _ = cmd.Flags().GetBool("stream")
+	req.ExpectStatus, _ = cmd.Flags().GetInt("expect-status")
+	req.Expect, _ = cmd.Flags().GetString("expect")
+	switch req.Format {
+	case "", FormatOpenAI, FormatKServe, FormatRaw:
+	default:
+		return nil, fmt.Errorf("invalid format %q, expected openai, kserve or raw", req.Format)
+	}
+	if req.Stream && req.Format != "" && req.Format != FormatOpenAI {
+		return nil, fmt.Errorf("--stream is only supported with the openai format")
+	}
+	if data, _ := cmd.Flags().GetString("data"); data != "" {
+		if strings.HasPrefix(data, "@") {
+			body, err := os.ReadFile(strings.TrimPrefix(data, "@"))
+			if err != nil {
+				return nil, fmt.Errorf("failed to read %s: %v", data[1:], err)
+			}
+			req.Body = body
+		} else {
+			req.Body = []byte(data)
+		}
+	}
+	headers, _ := cmd.Flags().GetStringArray("header")
+	for _, h := range headers {
+		key, value, ok := strings.Cut(h, ":")
+		if !ok {
+			return nil, fmt.Errorf("invalid header %q, expected KEY: VALUE", h)
+		}
+		req.Headers.Add(strings.TrimSpace(key), strings.TrimSpace(value))
+	}
+	return req, nil
+}
+
+// deploymentEndpoint returns the base URL of the deployment's service and the template it runs,
+// if any. closeForward stops the port-forward made when the service has no load balancer.
+func deploymentEndpoint(namespace, name string, port int32, forward bool) (string, *modelServer, func(), error) {
+	clientset, err := GetK8sClient()
+	if err != nil {
+		return "", nil, nil, err
+	}
+	// A blue/green deployment is tested on the color serving traffic
+	live, err := liveWorkload(clientset, namespace, KindDeployment, name)
+	if err != nil {
+		return "", nil, nil, err
+	}
+	var server *modelServer
+	if deployment, err := clientset.AppsV1().Deployments(namespace).Get(context.TODO(), live, metav1.GetOptions{}); err == nil {
+		if c := findContainer(&deployment.Spec.Template.Spec, name); c != nil {
+			server = modelServerForImage(c.Image)
+		}
+	}
+	serviceName := fmt.Sprintf("%s-service", name)
+	service, err := clientset.CoreV1().Services(namespace).Get(context.TODO(), serviceName, metav1.GetOptions{})
+	if err != nil {
+		return "", nil, nil, fmt.Errorf("failed to get service %s: %v", serviceName, err)
+	}
+	if len(service.Spec.Ports) == 0 {
+		return "", nil, nil, fmt.Errorf("service %s has no ports", serviceName)
+	}
+	servicePort := service.Spec.Ports[0]
+	if port != 0 {
+		found := false
+		for _, p := range service.Spec.Ports {
+			if p.Port == port {
+				servicePort, found = p, true
+			}
+		}
+		if !found {
+			return "", nil, nil, fmt.Errorf("service %s has no port %d", serviceName, port)
+		}
+	}
+
+	if !forward {
+		for _, ingress := range service.Status.LoadBalancer.Ingress {
+			host := ingress.IP
+			if host == "" {
+				host = ingress.Hostname
+			}
+			if host != "" {
+				return fmt.Sprintf("http://%s", net.JoinHostPort(host, strconv.Itoa(int(servicePort.Port)))), server, func() {}, nil
+			}
+		}
+	}
+
+	target := &forwardTarget{clientset: clientset, namespace: namespace, kind: KindDeployment, name: live, service: service}
+	pod, err := target.readyPod()
+	if err != nil {
+		return "", nil, nil, err
+	}
+	targetPort := servicePortTarget(servicePort, pod)
+	config, err := GetRestConfig()
+	if err != nil {
+		return "", nil, nil, err
+	}
+	stopCh := make(chan struct{})
+	fw, _, err := forwardPorts(config, clientset, namespace, pod.Name, []string{"localhost"}, []string{"0:" + targetPort}, stopCh, io.Discard)
+	if err != nil {
+		return "", nil, nil, err
+	}
+	ports, err := fw.GetPorts()
+	if err != nil || len(ports) == 0 {
+		close(stopCh)
+		return "", nil, nil, fmt.Errorf("failed to get the forwarded port: %v", err)
+	}
+	fmt.Fprintf(os.Stderr, "Forwarding localhost:%d to pod %s port %s\n", ports[0].Local, pod.Name, targetPort)
+	return fmt.Sprintf("http://localhost:%d", ports[0].Local), server, func() { close(stopCh) }, nil
+}
+
+// modelServerForImage finds the deploy-model template whose image the container runs,
+// whatever the registry prefix and tag
+func modelServerForImage(image string) *modelServer {
+	repository := func(image string) string {
+		if i := strings.LastIndex(image, ":"); i > strings.LastIndex(image, "/") {
+			return image[:i]
+		}
+		return image
+	}
+	for i := range modelServers {
+		if strings.HasSuffix(repository(image), repository(modelServers[i].Image)) {
+			return &modelServers[i]
+		}
+	}
+	return nil
+}
+
+// prepare asks the server for the model and KServe inputs the flags leave out
+func (r *endpointRequest) prepare(ctx context.Context, client *http.Client, baseURL string) error {
+	switch r.Format {
+	case FormatOpenAI:
+		if r.Path == "" {
+			r.Path = "/v1/chat/completions"
+		}
+		if r.Model == "" && r.Body == nil {
+			var models struct {
+				Data []struct {
+					ID string `json:"id"`
+				} `json:"data"`
+			}
+			if err := getJSON(ctx, client, http.MethodGet, baseURL+"/v1/models", r.Headers, nil, &models); err != nil {
+				return fmt.Errorf("failed to list models, pass --model: %v", err)
+			}
+			if len(models.Data) == 0 {
+				return fmt.Errorf("the server lists no models")
+			}
+			r.Model = models.Data[0].ID
+		}
+	case FormatKServe:
+		if r.Model == "" {
+			var index []struct {
+				Name  string `json:"name"`
+				State string `json:"state"`
+			}
+			if err := getJSON(ctx, client, http.MethodPost, baseURL+"/v2/repository/index", r.Headers, []byte("{}"), &index); err != nil {
+				return fmt.Errorf("failed to list models, pass --model: %v", err)
+			}
+			for _, m := range index {
+				if m.State == "READY" {
+					r.Model = m.Name
+					break
+				}
+			}
+			if r.Model == "" {
+				return fmt.Errorf("the repository has no ready models")
+			}
+		}
+		if r.Path == "" {
+			r.Path = fmt.Sprintf("/v2/models/%s/infer", r.Model)
+		}
+		if r.Body == nil {
+			var metadata kserveMetadata
+			if err := getJSON(ctx, client, http.MethodGet, fmt.Sprintf("%s/v2/models/%s", baseURL, r.Model), r.Headers, nil, &metadata); err != nil {
+				return fmt.Errorf("failed to get the metadata of model %s: %v", r.Model, err)
+			}
+			body, err := json.Marshal(metadata.request())
+			if err != nil {
+				return err
+			}
+			r.Body = body
+		}
+	case FormatRaw:
+		if r.Path == "" {
+			r.Path = "/"
+		}
+	}
+	if r.Method == "" {
+		r.Method = http.MethodGet
+		if r.Format != FormatRaw || r.Body != nil {
+			r.Method = http.MethodPost
+		}
+	}
+	if r.Body == nil && r.Format == FormatOpenAI {
+		r.Body = r.openAIBody()
+	}
+	return nil
+}
+
+func (r *endpointRequest) chat() bool {
+	return !strings.HasSuffix(r.Path, "/v1/completions")
+}
+
+func (r *endpointRequest) openAIBody() []byte {
+	body := map[string]interface{}{
+		"model":      r.Model,
+		"max_tokens": r.MaxTokens,
+		"stream":     r.Stream,
+	}
+	if r.chat() {
+		body["messages"] = []map[string]string{{"role": "user", "content": r.Prompt}}
+	} else {
+		body["prompt"] = r.Prompt
+	}
+	if r.Stream {
+		body["stream_options"] = map[string]bool{"include_usage": true}
+	}
+	data, _ := json.Marshal(body)
+	return data
+}
+
+type kserveTensor struct {
+	Name     string        `json:"name"`
+	Datatype string        `json:"datatype"`
+	Shape    []int64       `json:"shape"`
+	Data     []interface{} `json:"data,omitempty"`
+}
+
+type kserveMetadata struct {
+	Inputs []kserveTensor `json:"inputs"`
+}
+
+// request builds an inference request with one element in every variable dimension
+func (m kserveMetadata) request() map[string]interface{} {
+	var inputs []kserveTensor
+	for _, in := range m.Inputs {
+		shape := make([]int64, len(in.Shape))
+		size := int64(1)
+		for i, d := range in.Shape {
+			if d < 1 {
+				d = 1
+			}
+			shape[i] = d
+			size *= d
+		}
+		var zero interface{} = 0
+		switch in.Datatype {
+		case "BYTES":
+			zero = "test"
+		case "BOOL":
+			zero = false
+		}
+		data := make([]interface{}, size)
+		for i := range data {
+			data[i] = zero
+		}
+		inputs = append(inputs, kserveTensor{Name: in.Name, Datatype: in.Datatype, Shape: shape, Data: data})
+	}
+	return map[string]interface{}{"inputs": inputs}
+}
+
+// send makes the request once and checks the response
+func (r *endpointRequest) send(ctx context.Context, client *http.Client, baseURL string) (endpointResult, error) {
+	var result endpointResult
+	req, err := http.NewRequestWithContext(ctx, r.Method, baseURL+r.Path, bytes.NewReader(r.Body))
+	if err != nil {
+		return result, err
+	}
+	for k, v := range r.Headers {
+		req.Header[k] = v
+	}
+	if r.Body != nil && req.Header.Get("Content-Type") == "" {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	start := time.Now()
+	resp, err := client.Do(req)
+	if err != nil {
+		return result, err
+	}
+	defer resp.Body.Close()
+	result.Status = resp.StatusCode
+
+	if r.Stream && resp.StatusCode < 300 {
+		err = r.readStream(resp.Body, start, &result)
+		result.Latency = time.Since(start)
+		if err != nil {
+			return result, err
+		}
+		return result, r.expect(result.Output)
+	}
+	body, err := io.ReadAll(resp.Body)
+	result.Latency = time.Since(start)
+	if err != nil {
+		return result, err
+	}
+	if r.ExpectStatus != 0 && resp.StatusCode != r.ExpectStatus {
+		return result, fmt.Errorf("status %d, expected %d: %s", resp.StatusCode, r.ExpectStatus, truncate(string(body), 200))
+	}
+	if r.ExpectStatus == 0 && (resp.StatusCode < 200 || resp.StatusCode > 299) {
+		return result, fmt.Errorf("status %d: %s", resp.StatusCode, truncate(string(body), 200))
+	}
+
+	switch r.Format {
+	case FormatOpenAI:
+		var completion struct {
+			Choices []struct {
+				Text    string `json:"text"`
+				Message struct {
+					Content string `json:"content"`
+				} `json:"message"`
+			} `json:"choices"`
+			Usage struct {
+				CompletionTokens int `json:"completion_tokens"`
+			} `json:"usage"`
+		}
+		if err := json.Unmarshal(body, &completion); err != nil {
+			return result, fmt.Errorf("invalid completion: %v", err)
+		}
+		if len(completion.Choices) == 0 {
+			return result, fmt.Errorf("the completion has no choices")
+		}
+		result.Output = completion.Choices[0].Message.Content + completion.Choices[0].Text
+		result.Tokens = completion.Usage.CompletionTokens
+		if strings.TrimSpace(result.Output) == "" {
+			return result, fmt.Errorf("the completion is empty")
+		}
+	case FormatKServe:
+		var inference struct {
+			Outputs []kserveTensor `json:"outputs"`
+		}
+		if err := json.Unmarshal(body, &inference); err != nil {
+			return result, fmt.Errorf("invalid inference response: %v", err)
+		}
+		if len(inference.Outputs) == 0 {
+			return result, fmt.Errorf("the inference response has no outputs")
+		}
+		var outputs []string
+		for _, o := range inference.Outputs {
+			outputs = append(outputs, fmt.Sprintf("%s %s%v", o.Name, o.Datatype, o.Shape))
+		}
+		result.Output = strings.Join(outputs, ", ")
+		if r.Expect != "" {
+			return result, r.expect(string(body))
+		}
+	default:
+		result.Output = string(body)
+	}
+	return result, r.expect(result.Output)
+}
+
+// readStream reads server-sent completion chunks, timing the first one with content
+func (r *endpointRequest) readStream(body io.Reader, start time.Time, result *endpointResult) error {
+	var output strings.Builder
+	chunks := 0
+	scanner := bufio.NewScanner(body)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		data, ok := strings.CutPrefix(scanner.Text(), "data:")
+		if !ok {
+			continue
+		}
+		data = strings.TrimSpace(data)
+		if data == "[DONE]" {
+			break
+		}
+		var chunk struct {
+			Choices []struct {
+				Text  string `json:"text"`
+				Delta struct {
+					Content string `json:"content"`
+				} `json:"delta"`
+			} `json:"choices"`
+			Usage *struct {
+				CompletionTokens int `json:"completion_tokens"`
+			} `json:"usage"`
+		}
+		if err := json.Unmarshal([]byte(data), &chunk); err != nil {
+			return fmt.Errorf("invalid stream chunk: %v", err)
+		}
+		if chunk.Usage != nil {
+			result.Tokens = chunk.Usage.CompletionTokens
+		}
+		for _, c := range chunk.Choices {
+			content := c.Delta.Content + c.Text
+			if content == "" {
+				continue
+			}
+			if chunks == 0 {
+				result.TTFT = time.Since(start)
+			}
+			chunks++
+			output.WriteString(content)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return err
+	}
+	result.Output = output.String()
+	// Servers that leave out usage send about one token per chunk
+	if result.Tokens == 0 {
+		result.Tokens = chunks
+	}
+	if chunks == 0 {
+		return fmt.Errorf("the stream has no tokens")
+	}
+	return nil
+}
+
+func (r *endpointRequest) expect(output string) error {
+	if r.Expect != "" && !strings.Contains(output, r.Expect) {
+		return fmt.Errorf("the response does not contain %q: %s", r.Expect, truncate(output, 200))
+	}
+	return nil
+}
+
+func getJSON(ctx context.Context, client *http.Client, method, url string, headers http.Header, body []byte, v interface{}) error {
+	req, err := http.NewRequestWithContext(ctx, method, url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	for k, v := range headers {
+		req.Header[k] = v
+	}
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	data, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return err
+	}
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("status %d: %s", resp.StatusCode, truncate(string(data), 200))
+	}
+	return json.Unmarshal(data, v)
+}
+
+// runBenchmark sends the requests from concurrent workers until they are done or ctx is cancelled
+func runBenchmark(ctx context.Context, client *http.Client, baseURL string, r *endpointRequest, requests, concurrency int) benchmarkReport {
+	report := benchmarkReport{Concurrency: concurrency, Errors: map[string]int{}}
+	jobs := make(chan struct{})
+	var mu sync.Mutex
+	var latencies, ttfts []time.Duration
+	tokens := 0
+
+	var wg sync.WaitGroup
+	start := time.Now()
+	for i := 0; i < concurrency; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for range jobs {
+				result, err := r.send(ctx, client, baseURL)
+				mu.Lock()
+				report.Requests++
+				if err != nil {
+					report.Failures++
+					report.Errors[err.Error()]++
+				} else {
+					latencies = append(latencies, result.Latency)
+					if result.TTFT > 0 {
+						ttfts = append(ttfts, result.TTFT)
+					}
+					tokens += result.Tokens
+				}
+				mu.Unlock()
+			}
+		}()
+	}
+send:
+	for i := 0; i < requests; i++ {
+		select {
+		case jobs <- struct{}{}:
+		case <-ctx.Done():
+			break send
+		}
+	}
+	close(jobs)
+	wg.Wait()
+
+	report.Duration = time.Since(start)
+	if seconds := report.Duration.Seconds(); seconds > 0 {
+		report.RequestsPerSecond = float64(report.Requests-report.Failures) / seconds
+		report.TokensPerSecond = float64(tokens) / seconds
+	}
+	report.Latency = percentiles(latencies)
+	if len(ttfts) > 0 {
+		p := percentiles(ttfts)
+		report.TTFT = &p
+	}
+	return report
+}
+
+// percentiles uses the nearest rank
+func percentiles(durations []time.Duration) latencyPercentiles {
+	if len(durations) == 0 {
+		return latencyPercentiles{}
+	}
+	sort.Slice(durations, func(i, j int) bool { return durations[i] < durations[j] })
+	rank := func(p float64) time.Duration {
+		i := int(float64(len(durations))*p+0.999999) - 1
+		if i < 0 {
+			i = 0
+		}
+		return durations[i]
+	}
+	return latencyPercentiles{P50: rank(0.50), P95: rank(0.95), P99: rank(0.99)}
+}
+
+func printEndpointResult(r *endpointRequest, baseURL string, result endpointResult, err error, output string) {
+	if output == "json" {
+		report := map[string]interface{}{
+			"url":       baseURL + r.Path,
+			"format":    r.Format,
+			"status":    result.Status,
+			"latencyMs": milliseconds(result.Latency),
+		}
+		if r.Model != "" {
+			report["model"] = r.Model
+		}
+		if result.TTFT > 0 {
+			report["ttftMs"] = milliseconds(result.TTFT)
+		}
+		if result.Tokens > 0 {
+			report["tokens"] = result.Tokens
+		}
+		if err != nil {
+			report["error"] = err.Error()
+		} else {
+			report["output"] = result.Output
+		}
+		data, _ := json.MarshalIndent(report, "", "  ")
+		fmt.Println(string(data))
+		return
+	}
+	target := fmt.Sprintf("%s %s%s (%s", r.Method, baseURL, r.Path, r.Format)
+	if r.Model != "" {
+		target += ", model " + r.Model
+	}
+	fmt.Println(target + ")")
+	if result.Status != 0 {
+		fmt.Printf("Status:   %d %s\n", result.Status, http.StatusText(result.Status))
+		fmt.Printf("Latency:  %s\n", roundLatency(result.Latency))
+	}
+	if result.TTFT > 0 {
+		fmt.Printf("TTFT:     %s\n", roundLatency(result.TTFT))
+	}
+	if result.Tokens > 0 {
+		fmt.Printf("Tokens:   %d (%.1f tokens/s)\n", result.Tokens, float64(result.Tokens)/result.Latency.Seconds())
+	}
+	if err != nil {
+		fmt.Printf("FAIL: %v\n", err)
+		return
+	}
+	fmt.Printf("Response: %s\n", truncate(strings.TrimSpace(result.Output), 200))
+	fmt.Println("OK")
+}
+
+func printBenchmark(report benchmarkReport, output string) {
+	if output == "json" {
+		percentilesJSON := func(p latencyPercentiles) map[string]float64 {
+			return map[string]float64{"p50": milliseconds(p.P50), "p95": milliseconds(p.P95), "p99": milliseconds(p.P99)}
+		}
+		data := map[string]interface{}{
+			"requests":          report.Requests,
+			"failures":          report.Failures,
+			"concurrency":       report.Concurrency,
+			"durationMs":        milliseconds(report.Duration),
+			"requestsPerSecond": report.RequestsPerSecond,
+			"latencyMs":         percentilesJSON(report.Latency),
+			"errors":            report.Errors,
+		}
+		if report.TokensPerSecond > 0 {
+			data["tokensPerSecond"] = report.TokensPerSecond
+		}
+		if report.TTFT != nil {
+			data["ttftMs"] = percentilesJSON(*report.TTFT)
+		}
+		out, _ := json.MarshalIndent(data, "", "  ")
+		fmt.Println(string(out))
+		return
+	}
+	round := roundLatency
+	fmt.Printf("Requests:     %d (%d failed)\n", report.Requests, report.Failures)
+	fmt.Printf("Concurrency:  %d\n", report.Concurrency)
+	fmt.Printf("Duration:     %s\n", round(report.Duration))
+	throughput := fmt.Sprintf("%.1f requests/s", report.RequestsPerSecond)
+	if report.TokensPerSecond > 0 {
+		throughput += fmt.Sprintf(", %.1f tokens/s", report.TokensPerSecond)
+	}
+	fmt.Printf("Throughput:   %s\n", throughput)
+	if report.Requests > report.Failures {
+		l := report.Latency
+		fmt.Printf("Latency:      p50 %s  p95 %s  p99 %s\n", round(l.P50), round(l.P95), round(l.P99))
+	}
+	if t := report.TTFT; t != nil {
+		fmt.Printf("TTFT:         p50 %s  p95 %s  p99 %s\n", round(t.P50), round(t.P95), round(t.P99))
+	}
+	if len(report.Errors) > 0 {
+		fmt.Println("Errors:")
+		for _, e := range sortedCountKeys(report.Errors) {
+			fmt.Printf("  %4d  %s\n", report.Errors[e], e)
+		}
+	}
+}
+
+// sortedCountKeys orders by count, most frequent first
+func sortedCountKeys(counts map[string]int) []string {
+	keys := make([]string, 0, len(counts))
+	for k := range counts {
+		keys = append(keys, k)
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		if counts[keys[i]] != counts[keys[j]] {
+			return counts[keys[i]] > counts[keys[j]]
+		}
+		return keys[i] < keys[j]
+	})
+	return keys
+}
+
+// roundLatency shows microseconds below a millisecond, as for a local server
+func roundLatency(d time.Duration) time.Duration {
+	if d < time.Millisecond {
+		return d.Round(time.Microsecond)
+	}
+	return d.Round(time.Millisecond)
+}
+
+func milliseconds(d time.Duration) float64 {
+	return float64(d.Microseconds()) / 1000
+}
+
+func truncate(s string, n int) string {
+	if len(s) <= n {
+		return s
+	}
+	return s[:n] + "..."
+}
+
+func init() {
+	TestEndpointCmd.Flags().String("name", "", "Name of the deployment")
+	TestEndpointCmd.Flags().String("namespace", "", "Namespace of the deployment (defaults to the context's namespace)")
+	TestEndpointCmd.Flags().String("url", "", "Base URL to send to instead of the deployment's service")
+	TestEndpointCmd.Flags().Int32("port", 0, "Service port (defaults to the first)")
+	TestEndpointCmd.Flags().Bool("port-forward", false, "Port-forward to a pod even when the service has a load balancer")
+	TestEndpointCmd.Flags().String("format", "", "Request format: openai, kserve or raw (defaults to the deployment's model server)")
+	TestEndpointCmd.Flags().String("method", "", "HTTP method (defaults to POST, or GET for raw requests without --data)")
+	TestEndpointCmd.Flags().String("path", "", "Request path (defaults to the format's endpoint)")
+	TestEndpointCmd.Flags().String("model", "", "Model name (defaults to the first the server lists)")
+	TestEndpointCmd.Flags().String("prompt", "Say hello in one sentence.", "Prompt of openai requests")
+	TestEndpointCmd.Flags().Int("max-tokens", 32, "Maximum tokens of openai completions")
+	TestEndpointCmd.Flags().Bool("stream", false, "Stream openai completions and measure the time to first token")
+	TestEndpointCmd.Flags().String("data", "", "Request body, or @FILE, replacing the generated one")
+	TestEndpointCmd.Flags().StringArray("header", nil, "Request header as 'KEY: VALUE' (repeatable)")
+	TestEndpointCmd.Flags().Int("expect-status", 0, "Expected status code (defaults to any 2xx)")
+	TestEndpointCmd.Flags().String("expect", "", "Text the response must contain")
+	TestEndpointCmd.Flags().Duration("timeout", time.Minute, "Timeout of each request")
+	TestEndpointCmd.Flags().Bool("benchmark", false, "Send --requests requests from --concurrency workers and report throughput and latency percentiles")
+	TestEndpointCmd.Flags().Int("requests", 100, "Requests sent by --benchmark")
+	TestEndpointCmd.Flags().Int("concurrency", 4, "Concurrent requests of --benchmark")
+	TestEndpointCmd.Flags().StringP("output", "o", "text", "Output format (text or json)")
+}
@@ -0,0 +1,241 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// newModelServer fakes the OpenAI, KServe v2 and plain endpoints test-endpoint talks to
+func newModelServer(t *testing.T) (*httptest.Server, *atomic.Int32) {
+	t.Helper()
+	var requests atomic.Int32
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /v1/models", func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, `{"data":[{"id":"demo-model"}]}`)
+	})
+	mux.HandleFunc("POST /v1/chat/completions", func(w http.ResponseWriter, r *http.Request) {
+		requests.Add(1)
+		var body struct {
+			Model    string `json:"model"`
+			Stream   bool   `json:"stream"`
+			Messages []struct {
+				Content string `json:"content"`
+			} `json:"messages"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&body); err != nil || body.Model != "demo-model" || len(body.Messages) != 1 {
+			http.Error(w, "bad request", http.StatusBadRequest)
+			return
+		}
+		if !body.Stream {
+			fmt.Fprint(w, `{"choices":[{"message":{"content":"Hello there"}}],"usage":{"completion_tokens":2}}`)
+			return
+		}
+		w.Header().Set("Content-Type", "text/event-stream")
+		flusher := w.(http.Flusher)
+		// The first token comes late, the rest right after it
+		time.Sleep(50 * time.Millisecond)
+		for _, token := range []string{"Hel", "lo", " there"} {
+			fmt.Fprintf(w, "data: {\"choices\":[{\"delta\":{\"content\":%q}}]}\n\n", token)
+			flusher.Flush()
+		}
+		fmt.Fprint(w, "data: [DONE]\n\n")
+	})
+	mux.HandleFunc("POST /v2/repository/index", func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, `[{"name":"loading","state":"LOADING"},{"name":"resnet","state":"READY"}]`)
+	})
+	mux.HandleFunc("GET /v2/models/resnet", func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, `{"inputs":[{"name":"pixels","datatype":"FP32","shape":[-1,3]},{"name":"label","datatype":"BYTES","shape":[1]}]}`)
+	})
+	mux.HandleFunc("POST /v2/models/resnet/infer", func(w http.ResponseWriter, r *http.Request) {
+		var body struct {
+			Inputs []kserveTensor `json:"inputs"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&body); err != nil || len(body.Inputs) != 2 || len(body.Inputs[0].Data) != 3 {
+			http.Error(w, "bad inputs", http.StatusBadRequest)
+			return
+		}
+		fmt.Fprint(w, `{"outputs":[{"name":"scores","datatype":"FP32","shape":[1,10],"data":[0,1,2,3,4,5,6,7,8,9]}]}`)
+	})
+	mux.HandleFunc("GET /ping", func(w http.ResponseWriter, r *http.Request) {
+		requests.Add(1)
+		fmt.Fprint(w, `{"status":"Healthy"}`)
+	})
+	mux.HandleFunc("GET /unavailable", func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "loading", http.StatusServiceUnavailable)
+	})
+	server := httptest.NewServer(mux)
+	t.Cleanup(server.Close)
+	return server, &requests
+}
+
+func TestEndpointOpenAI(t *testing.T) {
+	server, _ := newModelServer(t)
+	r := &endpointRequest{Format: FormatOpenAI, Prompt: "Say hello", MaxTokens: 8, Expect: "Hello"}
+	if err := r.prepare(context.Background(), server.Client(), server.URL); err != nil {
+		t.Fatalf("prepare: %v", err)
+	}
+	if r.Model != "demo-model" || r.Path != "/v1/chat/completions" || r.Method != http.MethodPost {
+		t.Errorf("prepare got model %q, path %q, method %q", r.Model, r.Path, r.Method)
+	}
+	result, err := r.send(context.Background(), server.Client(), server.URL)
+	if err != nil {
+		t.Fatalf("send: %v", err)
+	}
+	if result.Status != http.StatusOK || result.Output != "Hello there" || result.Tokens != 2 || result.TTFT != 0 {
+		t.Errorf("send got %+v", result)
+	}
+
+	r.Expect = "Goodbye"
+	if _, err := r.send(context.Background(), server.Client(), server.URL); err == nil {
+		t.Error("send passed although the output lacks the expected text")
+	}
+}
+
+func TestEndpointOpenAIStream(t *testing.T) {
+	server, _ := newModelServer(t)
+	r := &endpointRequest{Format: FormatOpenAI, Prompt: "Say hello", MaxTokens: 8, Stream: true}
+	if err := r.prepare(context.Background(), server.Client(), server.URL); err != nil {
+		t.Fatalf("prepare: %v", err)
+	}
+	result, err := r.send(context.Background(), server.Client(), server.URL)
+	if err != nil {
+		t.Fatalf("send: %v", err)
+	}
+	if result.Output != "Hello there" {
+		t.Errorf("got output %q, want %q", result.Output, "Hello there")
+	}
+	// Without usage every chunk counts as a token
+	if result.Tokens != 3 {
+		t.Errorf("got %d tokens, want 3", result.Tokens)
+	}
+	if result.TTFT < 50*time.Millisecond || result.TTFT > result.Latency {
+		t.Errorf("got TTFT %s with latency %s, want at least 50ms and at most the latency", result.TTFT, result.Latency)
+	}
+}
+
+func TestEndpointReadStream(t *testing.T) {
+	stream := strings.Join([]string{
+		`: keep-alive`,
+		`data: {"choices":[{"delta":{"content":""}}]}`,
+		`data: {"choices":[{"delta":{"content":"a"}}]}`,
+		`data: {"choices":[{"text":"b"}]}`,
+		`data: {"choices":[],"usage":{"completion_tokens":7}}`,
+		`data: [DONE]`,
+		`data: {"choices":[{"delta":{"content":"ignored"}}]}`,
+	}, "\n")
+	var result endpointResult
+	r := &endpointRequest{}
+	if err := r.readStream(strings.NewReader(stream), time.Now(), &result); err != nil {
+		t.Fatalf("readStream: %v", err)
+	}
+	if result.Output != "ab" || result.Tokens != 7 || result.TTFT <= 0 {
+		t.Errorf("got %+v, want output ab, 7 tokens and a TTFT", result)
+	}
+
+	if err := r.readStream(strings.NewReader("data: [DONE]\n"), time.Now(), &endpointResult{}); err == nil {
+		t.Error("readStream passed a stream without tokens")
+	}
+}
+
+func TestEndpointKServe(t *testing.T) {
+	server, _ := newModelServer(t)
+	r := &endpointRequest{Format: FormatKServe}
+	if err := r.prepare(context.Background(), server.Client(), server.URL); err != nil {
+		t.Fatalf("prepare: %v", err)
+	}
+	if r.Model != "resnet" || r.Path != "/v2/models/resnet/infer" {
+		t.Errorf("prepare got model %q and path %q", r.Model, r.Path)
+	}
+	var body struct {
+		Inputs []kserveTensor `json:"inputs"`
+	}
+	if err := json.Unmarshal(r.Body, &body); err != nil {
+		t.Fatal(err)
+	}
+	want := []kserveTensor{
+		{Name: "pixels", Datatype: "FP32", Shape: []int64{1, 3}, Data: []interface{}{0.0, 0.0, 0.0}},
+		{Name: "label", Datatype: "BYTES", Shape: []int64{1}, Data: []interface{}{"test"}},
+	}
+	if !reflect.DeepEqual(body.Inputs, want) {
+		t.Errorf("generated inputs %+v, want %+v", body.Inputs, want)
+	}
+	result, err := r.send(context.Background(), server.Client(), server.URL)
+	if err != nil {
+		t.Fatalf("send: %v", err)
+	}
+	if result.Output != "scores FP32[1 10]" {
+		t.Errorf("got output %q", result.Output)
+	}
+}
+
+func TestEndpointRaw(t *testing.T) {
+	server, _ := newModelServer(t)
+	r := &endpointRequest{Format: FormatRaw, Path: "/ping", Expect: "Healthy"}
+	if err := r.prepare(context.Background(), server.Client(), server.URL); err != nil {
+		t.Fatalf("prepare: %v", err)
+	}
+	if r.Method != http.MethodGet {
+		t.Errorf("raw request without a body got method %s, want GET", r.Method)
+	}
+	if _, err := r.send(context.Background(), server.Client(), server.URL); err != nil {
+		t.Errorf("send: %v", err)
+	}
+
+	r = &endpointRequest{Format: FormatRaw, Path: "/unavailable", Method: http.MethodGet}
+	if _, err := r.send(context.Background(), server.Client(), server.URL); err == nil || !strings.Contains(err.Error(), "503") {
+		t.Errorf("got %v, want a 503 error", err)
+	}
+	r.ExpectStatus = http.StatusServiceUnavailable
+	if _, err := r.send(context.Background(), server.Client(), server.URL); err != nil {
+		t.Errorf("send with the expected status: %v", err)
+	}
+}
+
+func TestRunBenchmark(t *testing.T) {
+	server, requests := newModelServer(t)
+	r := &endpointRequest{Format: FormatOpenAI, Prompt: "Say hello", MaxTokens: 8}
+	if err := r.prepare(context.Background(), server.Client(), server.URL); err != nil {
+		t.Fatalf("prepare: %v", err)
+	}
+	report := runBenchmark(context.Background(), server.Client(), server.URL, r, 20, 4)
+	if report.Requests != 20 || report.Failures != 0 || int(requests.Load()) != 20 {
+		t.Errorf("got %d requests and %d failures, server saw %d, want 20, 0 and 20", report.Requests, report.Failures, requests.Load())
+	}
+	if report.Latency.P50 <= 0 || report.Latency.P50 > report.Latency.P99 || report.RequestsPerSecond <= 0 || report.TokensPerSecond <= 0 {
+		t.Errorf("got %+v", report)
+	}
+	if report.TTFT != nil {
+		t.Errorf("non-streaming benchmark reported TTFT %+v", report.TTFT)
+	}
+
+	failing := &endpointRequest{Format: FormatRaw, Path: "/unavailable", Method: http.MethodGet}
+	report = runBenchmark(context.Background(), server.Client(), server.URL, failing, 5, 2)
+	if report.Failures != 5 || len(report.Errors) != 1 {
+		t.Errorf("got %d failures with errors %v, want 5 with one message", report.Failures, report.Errors)
+	}
+}
+
+func TestPercentiles(t *testing.T) {
+	var durations []time.Duration
+	for i := 100; i >= 1; i-- {
+		durations = append(durations, time.Duration(i)*time.Millisecond)
+	}
+	want := latencyPercentiles{P50: 50 * time.Millisecond, P95: 95 * time.Millisecond, P99: 99 * time.Millisecond}
+	if got := percentiles(durations); got != want {
+		t.Errorf("got %+v, want %+v", got, want)
+	}
+	if got := percentiles([]time.Duration{7 * time.Millisecond}); got.P50 != 7*time.Millisecond || got.P99 != 7*time.Millisecond {
+		t.Errorf("one sample got %+v", got)
+	}
+	if got := percentiles(nil); got != (latencyPercentiles{}) {
+		t.Errorf("no samples got %+v", got)
+	}
+}
@@ -0,0 +1,461 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/spf13/cobra"
+	"golang.org/x/term"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/fields"
+	utilrand "k8s.io/apimachinery/pkg/util/rand"
+	"k8s.io/apimachinery/pkg/util/version"
+	"k8s.io/apimachinery/pkg/watch"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/kubernetes/scheme"
+	"k8s.io/client-go/rest"
+	"k8s.io/client-go/tools/remotecommand"
+	utilexec "k8s.io/client-go/util/exec"
+)
+
+// Annotation kubectl reads the container to exec into from
+const defaultContainerAnnotation = "kubectl.kubernetes.io/default-container"
+
+// Runs bash where the image has it
+var defaultShell = []string{"sh", "-c", "command -v bash >/dev/null && exec bash || exec sh"}
+
+var ExecCmd = &cobra.Command{
+	Use:   "exec --name NAME [-- COMMAND [ARG...]]",
+	Short: "Run a command or a shell in a pod of a workload",
+	Long: `Run a command in a pod of a workload, or an interactive shell without one.
+
+The pod is picked like health-status finds pods: a ready pod of the current revision,
+or --pod. The container defaults to the main container create-deployment made.
+
+  simplismart-cli exec --name llm -- nvidia-smi
+  simplismart-cli exec --name llm -- ls -lh /root/.cache/huggingface
+  simplismart-cli exec --name llm`,
+	Run: func(cmd *cobra.Command, args []string) {
+		name, _ := cmd.Flags().GetString("name")
+		namespace, _ := cmd.Flags().GetString("namespace")
+		if namespace == "" {
+			namespace = contextNamespace()
+		}
+		kind, _ := cmd.Flags().GetString("kind")
+		podName, _ := cmd.Flags().GetString("pod")
+		container, _ := cmd.Flags().GetString("container")
+		stdin, _ := cmd.Flags().GetBool("stdin")
+		tty, _ := cmd.Flags().GetBool("tty")
+		if len(args) == 0 {
+			args = defaultShell
+			if !cmd.Flags().Changed("stdin") && !cmd.Flags().Changed("tty") {
+				stdin, tty = true, term.IsTerminal(int(os.Stdin.Fd()))
+			}
+		}
+		if tty && !stdin {
+			fmt.Println("--tty needs --stdin")
+			return
+		}
+
+		clientset, err := GetK8sClient()
+		if err != nil {
+			fmt.Println(err)
+			return
+		}
+		config, err := GetRestConfig()
+		if err != nil {
+			fmt.Println(err)
+			return
+		}
+		pod, err := pickPod(clientset, namespace, kind, name, podName)
+		if err != nil {
+			fmt.Println(err)
+			return
+		}
+		if container == "" {
+			container = defaultContainer(pod, name)
+		}
+		if status := containerStatus(pod, container); status == nil || status.State.Running == nil {
+			fmt.Printf("Container %s of pod %s is not running\n", container, pod.Name)
+			return
+		}
+		fmt.Fprintf(os.Stderr, "Running in pod %s, container %s\n", pod.Name, container)
+
+		req := clientset.CoreV1().RESTClient().Post().
+			Resource("pods").
+			Namespace(namespace).
+			Name(pod.Name).
+			SubResource("exec").
+			VersionedParams(&corev1.PodExecOptions{
+				Container: container,
+				Command:   args,
+				Stdin:     stdin,
+				Stdout:    true,
+				Stderr:    !tty,
+				TTY:       tty,
+			}, scheme.ParameterCodec)
+		exitWith(streamRemote(config, req, stdin, tty))
+	},
+}
+
+var DebugCmd = &cobra.Command{
+	Use:   "debug --name NAME [--image IMAGE] [-- COMMAND [ARG...]]",
+	Short: "Attach an ephemeral debug container to a running pod of a workload",
+	Long: `Add an ephemeral container with a debugging image to a running pod and attach to it.
+
+The debug container shares the process namespace of --target, the main container by
+default, so its processes and their files are visible under /proc/PID/root. It works on
+pods whose image has no shell. Ephemeral containers cannot request resources, so they see
+no GPU; use exec to run nvidia-smi in the model container. They stay in the pod until the
+pod is deleted and need Kubernetes 1.25 or later.
+
+The command attaches when stdin is a terminal, or with --stdin; otherwise it prints
+the debug container's output.`,
+	Run: func(cmd *cobra.Command, args []string) {
+		name, _ := cmd.Flags().GetString("name")
+		namespace, _ := cmd.Flags().GetString("namespace")
+		if namespace == "" {
+			namespace = contextNamespace()
+		}
+		kind, _ := cmd.Flags().GetString("kind")
+		podName, _ := cmd.Flags().GetString("pod")
+		image, _ := cmd.Flags().GetString("image")
+		target, _ := cmd.Flags().GetString("target")
+		timeout, _ := cmd.Flags().GetDuration("timeout")
+		skipPreflight, _ := cmd.Flags().GetBool("skip-preflight")
+		interactive := term.IsTerminal(int(os.Stdin.Fd()))
+		if cmd.Flags().Changed("stdin") {
+			interactive, _ = cmd.Flags().GetBool("stdin")
+		}
+		tty := interactive && term.IsTerminal(int(os.Stdin.Fd()))
+		if len(args) == 0 {
+			args = []string{"sh"}
+		}
+
+		clientset, err := GetK8sClient()
+		if err != nil {
+			fmt.Println(err)
+			return
+		}
+		if !skipPreflight {
+			if err := preflightRBAC(clientset, "debug", debugPermissions(namespace)); err != nil {
+				fmt.Println(err)
+				return
+			}
+		}
+		config, err := GetRestConfig()
+		if err != nil {
+			fmt.Println(err)
+			return
+		}
+		if err := checkEphemeralContainers(clientset); err != nil {
+			fmt.Println(err)
+			return
+		}
+		pod, err := pickPod(clientset, namespace, kind, name, podName)
+		if err != nil {
+			fmt.Println(err)
+			return
+		}
+		if target == "" {
+			target = defaultContainer(pod, name)
+		}
+		if findContainer(&pod.Spec, target) == nil {
+			fmt.Printf("Pod %s has no container %s\n", pod.Name, target)
+			return
+		}
+
+		registry, _ := configValue("registry")
+		debugName := "debugger-" + utilrand.String(5)
+		pod.Spec.EphemeralContainers = append(pod.Spec.EphemeralContainers, corev1.EphemeralContainer{
+			EphemeralContainerCommon: corev1.EphemeralContainerCommon{
+				Name:                     debugName,
+				Image:                    withRegistry(image, registry),
+				Command:                  args,
+				ImagePullPolicy:          corev1.PullIfNotPresent,
+				Stdin:                    interactive,
+				TTY:                      tty,
+				TerminationMessagePolicy: corev1.TerminationMessageReadFile,
+			},
+			TargetContainerName: target,
+		})
+		if _, err := clientset.CoreV1().Pods(namespace).UpdateEphemeralContainers(context.TODO(), pod.Name, pod, metav1.UpdateOptions{}); err != nil {
+			fmt.Printf("Failed to add a debug container to pod %s: %v\n", pod.Name, err)
+			return
+		}
+		fmt.Fprintf(os.Stderr, "Added debug container %s with %s to pod %s, targeting %s\n", debugName, image, pod.Name, target)
+
+		ctx, cancel := context.WithTimeout(context.Background(), timeout)
+		status, err := waitForEphemeralContainer(ctx, clientset, namespace, pod.Name, debugName)
+		cancel()
+		if err != nil {
+			fmt.Println(err)
+			return
+		}
+		if status.State.Terminated != nil || !interactive {
+			// Nothing to attach to, show what it printed
+			err := streamContainerLogs(clientset, namespace, pod.Name, debugName, status.State.Terminated == nil)
+			if err != nil {
+				fmt.Println(err)
+			}
+			return
+		}
+
+		req := clientset.CoreV1().RESTClient().Post().
+			Resource("pods").
+			Namespace(namespace).
+			Name(pod.Name).
+			SubResource("attach").
+			VersionedParams(&corev1.PodAttachOptions{
+				Container: debugName,
+				Stdin:     true,
+				Stdout:    true,
+				Stderr:    !tty,
+				TTY:       tty,
+			}, scheme.ParameterCodec)
+		if tty {
+			fmt.Fprintln(os.Stderr, "If you don't see a command prompt, try pressing enter.")
+		}
+		exitWith(streamRemote(config, req, true, tty))
+	},
+}
+
+// pickPod resolves a pod of the workload like health-status does. Without podName it prefers a
+// ready pod of the current revision, then any ready or running one.
+func pickPod(clientset *kubernetes.Clientset, namespace, kind, name, podName string) (*corev1.Pod, error) {
+	// A blue/green deployment picks from the color serving traffic
+	name, err := liveWorkload(clientset, namespace, kind, name)
+	if err != nil {
+		return nil, err
+	}
+	w, pods, err := getWorkloadPods(clientset, namespace, kind, name, true)
+	if err != nil {
+		return nil, err
+	}
+	if podName != "" {
+		var names []string
+		for i := range pods {
+			if pods[i].Name == podName {
+				return &pods[i], nil
+			}
+			names = append(names, pods[i].Name)
+		}
+		return nil, fmt.Errorf("pod %s does not belong to %s %s, its pods are: %s", podName, strings.ToLower(w.Kind), name, orDash(strings.Join(names, ", ")))
+	}
+	rank := func(pod *corev1.Pod) int {
+		_, current := w.owns(pod)
+		switch {
+		case isPodReady(pod) && current:
+			return 3
+		case isPodReady(pod):
+			return 2
+		case pod.Status.Phase == corev1.PodRunning && pod.DeletionTimestamp == nil:
+			return 1
+		}
+		return 0
+	}
+	var best *corev1.Pod
+	for i := range pods {
+		if rank(&pods[i]) > 0 && (best == nil || rank(&pods[i]) > rank(best)) {
+			best = &pods[i]
+		}
+	}
+	if best == nil {
+		return nil, fmt.Errorf("%s %s has no running pods, see 'simplismart-cli health-status --name %s'", strings.ToLower(w.Kind), name, name)
+	}
+	return best, nil
+}
+
+// defaultContainer is the container kubectl would pick, else the one named after the workload
+func defaultContainer(pod *corev1.Pod, name string) string {
+	if c := pod.Annotations[defaultContainerAnnotation]; c != "" {
+		return c
+	}
+	if findContainer(&pod.Spec, name) != nil {
+		return name
+	}
+	return pod.Spec.Containers[0].Name
+}
+
+func containerStatus(pod *corev1.Pod, name string) *corev1.ContainerStatus {
+	statuses := append(append([]corev1.ContainerStatus{}, pod.Status.ContainerStatuses...), pod.Status.InitContainerStatuses...)
+	statuses = append(statuses, pod.Status.EphemeralContainerStatuses...)
+	for i := range statuses {
+		if statuses[i].Name == name {
+			return &statuses[i]
+		}
+	}
+	return nil
+}
+
+func checkEphemeralContainers(clientset *kubernetes.Clientset) error {
+	info, err := clientset.Discovery().ServerVersion()
+	if err != nil {
+		return fmt.Errorf("failed to get the server version: %v", err)
+	}
+	v, err := version.ParseGeneric(info.GitVersion)
+	if err == nil && !v.AtLeast(version.MajorMinor(1, 25)) {
+		return fmt.Errorf("ephemeral debug containers need Kubernetes 1.25 or later, the cluster runs %s", info.GitVersion)
+	}
+	return nil
+}
+
+// waitForEphemeralContainer waits for the container to start, failing on image pull errors
+func waitForEphemeralContainer(ctx context.Context, clientset *kubernetes.Clientset, namespace, pod, container string) (*corev1.ContainerStatus, error) {
+	watcher, err := clientset.CoreV1().Pods(namespace).Watch(ctx, metav1.ListOptions{
+		FieldSelector: fields.OneTermEqualSelector("metadata.name", pod).String(),
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to watch pod %s: %v", pod, err)
+	}
+	defer watcher.Stop()
+	reported := ""
+	for {
+		select {
+		case <-ctx.Done():
+			return nil, fmt.Errorf("debug container %s did not start in time", container)
+		case event, ok := <-watcher.ResultChan():
+			if !ok {
+				return nil, fmt.Errorf("lost the watch on pod %s", pod)
+			}
+			if event.Type == watch.Deleted {
+				return nil, fmt.Errorf("pod %s was deleted", pod)
+			}
+			p, ok := event.Object.(*corev1.Pod)
+			if !ok {
+				continue
+			}
+			status := containerStatus(p, container)
+			if status == nil {
+				continue
+			}
+			if status.State.Running != nil || status.State.Terminated != nil {
+				return status, nil
+			}
+			if waiting := status.State.Waiting; waiting != nil && waiting.Reason != reported {
+				reported = waiting.Reason
+				switch waiting.Reason {
+				case "ErrImagePull", "ImagePullBackOff", "InvalidImageName", "CreateContainerError":
+					return nil, fmt.Errorf("debug container %s cannot start: %s: %s", container, waiting.Reason, waiting.Message)
+				}
+				fmt.Fprintf(os.Stderr, "Waiting for debug container %s: %s\n", container, waiting.Reason)
+			}
+		}
+	}
+}
+
+func streamContainerLogs(clientset *kubernetes.Clientset, namespace, pod, container string, follow bool) error {
+	stream, err := clientset.CoreV1().Pods(namespace).GetLogs(pod, &corev1.PodLogOptions{Container: container, Follow: follow}).Stream(context.TODO())
+	if err != nil {
+		return fmt.Errorf("failed to get the logs of %s: %v", container, err)
+	}
+	defer stream.Close()
+	_, err = io.Copy(os.Stdout, stream)
+	return err
+}
+
+// streamRemote connects the terminal to an exec or attach request, in raw mode with a TTY
+func streamRemote(config *rest.Config, req *rest.Request, stdin, tty bool) error {
+	executor, err := remotecommand.NewSPDYExecutor(config, http.MethodPost, req.URL())
+	if err != nil {
+		return err
+	}
+	options := remotecommand.StreamOptions{Stdout: os.Stdout, Stderr: os.Stderr, Tty: tty}
+	if stdin {
+		options.Stdin = os.Stdin
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	if tty {
+		// The remote terminal merges stderr into stdout
+		options.Stderr = nil
+		fd := int(os.Stdin.Fd())
+		if term.IsTerminal(fd) {
+			state, err := term.MakeRaw(fd)
+			if err != nil {
+				return err
+			}
+			defer term.Restore(fd, state)
+			options.TerminalSizeQueue = newTerminalSizes(ctx, int(os.Stdout.Fd()))
+		}
+	}
+	return executor.StreamWithContext(ctx, options)
+}
+
+// terminalSizes reports the terminal size when it changes. It polls rather than waiting for
+// SIGWINCH, which Windows does not have.
+type terminalSizes struct {
+	sizes chan remotecommand.TerminalSize
+}
+
+func newTerminalSizes(ctx context.Context, fd int) *terminalSizes {
+	t := &terminalSizes{sizes: make(chan remotecommand.TerminalSize, 1)}
+	go func() {
+		defer close(t.sizes)
+		var last remotecommand.TerminalSize
+		ticker := time.NewTicker(250 * time.Millisecond)
+		defer ticker.Stop()
+		for {
+			if width, height, err := term.GetSize(fd); err == nil {
+				size := remotecommand.TerminalSize{Width: uint16(width), Height: uint16(height)}
+				if size != last {
+					last = size
+					select {
+					case t.sizes <- size:
+					case <-ctx.Done():
+						return
+					}
+				}
+			}
+			select {
+			case <-ticker.C:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+	return t
+}
+
+func (t *terminalSizes) Next() *remotecommand.TerminalSize {
+	size, ok := <-t.sizes
+	if !ok {
+		return nil
+	}
+	return &size
+}
+
+// exitWith exits with the remote command's exit code
+func exitWith(err error) {
+	if err == nil {
+		return
+	}
+	if exitErr, ok := err.(utilexec.CodeExitError); ok {
+		os.Exit(exitErr.Code)
+	}
+	fmt.Println(err)
+	os.Exit(1)
+}
+
+func init() {
+	for _, cmd := range []*cobra.Command{ExecCmd, DebugCmd} {
+		cmd.Flags().String("name", "", "Name of the workload")
+		cmd.Flags().String("namespace", "", "Namespace of the workload (defaults to the context's namespace)")
+		cmd.Flags().String("kind", "deployment", "Workload kind: deployment, statefulset or daemonset")
+		cmd.Flags().String("pod", "", "Pod of the workload (defaults to a ready pod of the current revision)")
+		cmd.Flags().BoolP("stdin", "i", false, "Pass stdin to the container")
+		cmd.MarkFlagRequired("name")
+	}
+	ExecCmd.Flags().StringP("container", "c", "", "Container (defaults to the main container)")
+	ExecCmd.Flags().BoolP("tty", "t", false, "Allocate a TTY, with --stdin")
+	DebugCmd.Flags().String("image", "busybox:1.36", "Image of the debug container, e.g. nicolaka/netshoot or nvidia/cuda:12.4.1-base-ubuntu22.04")
+	DebugCmd.Flags().String("target", "", "Container whose process namespace the debug container shares (defaults to the main container)")
+	DebugCmd.Flags().Duration("timeout", 2*time.Minute, "How long to wait for the debug container to start")
+	DebugCmd.Flags().Bool("skip-preflight", false, "Skip the permission check before adding the debug container")
+}
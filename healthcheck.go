@@ -1,12 +1,15 @@
 package main
 
 import (
-	"context"
+	"encoding/json"
 	"fmt"
 	"log"
+	"os"
+	"text/tabwriter"
+	"time"
 
 	"github.com/spf13/cobra"
-	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/yaml"
 )
 
 var kubeconfig string
@@ -14,9 +17,31 @@ var kubeconfig string
 var HealthStatusCmd = &cobra.Command{
 	Use:   "health-status",
 	Short: "Retrieve health status of a deployment",
+	Long: `Retrieve health status of a deployment, statefulset or daemonset.
+
+The overall verdict sets the exit code: 0 Healthy, 2 Degraded, 3 Progressing,
+4 Unavailable. Exit code 1 means the status could not be retrieved.`,
 	Run: func(cmd *cobra.Command, args []string) {
-		deploymentName, _ := cmd.Flags().GetString("name")
+		names, _ := cmd.Flags().GetStringSlice("name")
 		namespace, _ := cmd.Flags().GetString("namespace")
+		if namespace == "" {
+			namespace = contextNamespace()
+		}
+		kind, _ := cmd.Flags().GetString("kind")
+		allRevisions, _ := cmd.Flags().GetBool("all-revisions")
+		watch, _ := cmd.Flags().GetBool("watch")
+		tui, _ := cmd.Flags().GetBool("tui")
+		interval, _ := cmd.Flags().GetDuration("interval")
+		output := outputFormat(cmd, "table", "json", "yaml")
+		requireReady, _ := cmd.Flags().GetInt32("require-ready")
+		maxRestarts, _ := cmd.Flags().GetInt32("max-restarts")
+		if output != "table" && output != "json" && output != "yaml" {
+			log.Fatalf("Invalid output format %q, expected table, json or yaml", output)
+		}
+		kind, err := normalizeKind(kind)
+		if err != nil {
+			log.Fatal(err)
+		}
 		clientset, err := GetK8sClient()
 		if err != nil {
 			log.Fatalf("Failed to create Kubernetes client: %v", err)
@@ -25,54 +50,121 @@ var HealthStatusCmd = &cobra.Command{
 		if err != nil {
 			log.Fatalf("Failed to create Metrics client: %v", err)
 		}
-
-		// Get deployment status
-		deployment, err := clientset.AppsV1().Deployments(namespace).Get(context.TODO(), deploymentName, metav1.GetOptions{})
-		if err != nil {
-			log.Fatalf("Failed to get deployment: %v", err)
+		// A blue/green deployment reports on the color serving traffic. Its idle color is scaled
+		// down once expired, noted on stderr to keep the report parseable.
+		for i, name := range names {
+			if kind == KindDeployment {
+				retireIdleColor(clientset, namespace, name, os.Stderr)
+			}
+			if names[i], err = liveWorkload(clientset, namespace, kind, name); err != nil {
+				log.Fatal(err)
+			}
 		}
-		fmt.Printf("Deployment: %s, Available Replicas: %d/%d\n", deployment.Name, deployment.Status.AvailableReplicas, *deployment.Spec.Replicas)
 
-		// Get pod status and resource usage
-		pods, err := clientset.CoreV1().Pods(namespace).List(context.TODO(), metav1.ListOptions{
-			LabelSelector: fmt.Sprintf("app=%s", deploymentName),
-		})
-		if err != nil {
-			log.Fatalf("Failed to list pods: %v", err)
+		if watch || tui {
+			if err := watchHealthStatus(clientset, metricsClient, namespace, kind, names, interval, tui); err != nil {
+				log.Fatalf("Watch failed: %v", err)
+			}
+			return
 		}
 
-		for _, pod := range pods.Items {
-			fmt.Printf("Pod: %s, Status: %s\n", pod.Name, pod.Status.Phase)
-			if pod.Status.Phase != "Running" {
-				fmt.Printf("\tWarning: Pod %s is in %s state!\n", pod.Name, pod.Status.Phase)
-			}
-			for _, containerStatus := range pod.Status.ContainerStatuses {
-				if !containerStatus.Ready {
-					fmt.Printf("\tContainer %s is not ready\n", containerStatus.Name)
-				}
+		report := healthReport{
+			APIVersion: healthReportVersion,
+			Kind:       "HealthReport",
+			Namespace:  namespace,
+			Workloads:  []workloadHealth{},
+		}
+		verdicts := []string{}
+		for _, name := range names {
+			health, err := collectHealth(clientset, metricsClient, namespace, kind, name, allRevisions, healthThresholds{
+				RequireReady: requireReady,
+				MaxRestarts:  maxRestarts,
+			})
+			if err != nil {
+				log.Fatal(err)
 			}
-			for _, containerStatus := range pod.Status.ContainerStatuses {
-				if !containerStatus.Ready {
-					fmt.Printf("\tContainer %s is not ready\n", containerStatus.Name)
+			report.Workloads = append(report.Workloads, health)
+			verdicts = append(verdicts, health.Verdict)
+		}
+		report.Verdict = worstVerdict(verdicts...)
+
+		switch output {
+		case "json":
+			data, _ := json.MarshalIndent(report, "", "  ")
+			fmt.Println(string(data))
+		case "yaml":
+			data, _ := yaml.Marshal(report)
+			fmt.Print(string(data))
+		default:
+			printHealthTable(report)
+		}
+		os.Exit(verdictExitCodes[report.Verdict])
+	},
+}
+
+func printHealthTable(report healthReport) {
+	for _, d := range report.Workloads {
+		fmt.Printf("%s: %s, Available Replicas: %d/%d, Verdict: %s\n", d.Kind, d.Name, d.Replicas.Available, d.Replicas.Desired, d.Verdict)
+		for _, reason := range d.Reasons {
+			fmt.Printf("\t%s\n", reason)
+		}
+		for _, warning := range d.Warnings {
+			log.Print(warning)
+		}
+		if len(d.Pods) > 0 {
+			tw := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+			fmt.Fprintln(tw, "POD\tCONTAINER\tSTATE\tREADY\tRESTARTS\tLAST TERMINATION\tCPU\tMEMORY")
+			for _, pod := range d.Pods {
+				for _, c := range pod.Containers {
+					state := c.State
+					if c.Reason != "" {
+						state += ": " + c.Reason
+					}
+					lastTermination := c.LastTermination
+					if lastTermination == "" {
+						lastTermination = "-"
+					}
+					fmt.Fprintf(tw, "%s\t%s\t%s\t%t\t%d\t%s\t%s\t%s\n", pod.Name, c.Name, state, c.Ready, c.Restarts, lastTermination,
+						orDash(c.Usage["cpu"]), orDash(c.Usage["memory"]))
 				}
 			}
-
-			// Get pod metrics
-			podMetrics, err := metricsClient.MetricsV1beta1().PodMetricses(namespace).Get(context.TODO(), pod.Name, metav1.GetOptions{})
-			if err != nil {
-				log.Printf("Failed to get metrics for pod %s: %v", pod.Name, err)
-				continue
+			tw.Flush()
+		}
+		if len(d.Events) > 0 {
+			fmt.Println("Recent warning events:")
+			for _, event := range d.Events {
+				fmt.Printf("\t%s %s: %s: %s\n", event.Time, event.Object, event.Reason, event.Message)
 			}
-			for _, container := range podMetrics.Containers {
-				fmt.Printf("\tContainer: %s, CPU: %s, Memory: %s\n", container.Name, container.Usage.Cpu().String(), container.Usage.Memory().String())
+		}
+		if len(d.Diagnoses) > 0 {
+			fmt.Println("Diagnosis:")
+			for _, diag := range d.Diagnoses {
+				fmt.Printf("\t%s: %s: %s\n\t\tFix: %s\n", diag.Object, diag.Reason, diag.Message, diag.Fix)
 			}
 		}
-	},
+	}
+	if len(report.Workloads) > 1 {
+		fmt.Printf("Overall verdict: %s\n", report.Verdict)
+	}
+}
+
+func orDash(s string) string {
+	if s == "" {
+		return "-"
+	}
+	return s
 }
 
 func init() {
-	HealthStatusCmd.Flags().String("name", "", "Name of the deployment")
-	HealthStatusCmd.Flags().String("namespace", "default", "Namespace of the deployment")
+	HealthStatusCmd.Flags().StringSlice("name", []string{}, "Name of the workload (repeatable, e.g., a,b)")
+	HealthStatusCmd.Flags().String("namespace", "", "Namespace of the workload (defaults to the context's namespace)")
+	HealthStatusCmd.Flags().String("kind", "deployment", "Kind of the workload (deployment, statefulset or daemonset)")
+	HealthStatusCmd.Flags().Bool("all-revisions", false, "Include pods of previous revisions still running during a rollout")
+	HealthStatusCmd.Flags().BoolP("watch", "w", false, "Keep refreshing the status as it changes")
+	HealthStatusCmd.Flags().Bool("tui", false, "Show a full-screen dashboard (implies --watch)")
+	HealthStatusCmd.Flags().Duration("interval", 2*time.Second, "Refresh interval for --watch")
+	HealthStatusCmd.Flags().StringP("output", "o", "table", "Output format (table, json or yaml)")
+	HealthStatusCmd.Flags().Int32("require-ready", 0, "Report Degraded when fewer replicas are ready")
+	HealthStatusCmd.Flags().Int32("max-restarts", -1, "Report Degraded when a pod restarted more often (-1 disables)")
 	HealthStatusCmd.MarkFlagRequired("name")
-	HealthStatusCmd.MarkFlagRequired("namespace")
 }
@@ -0,0 +1,275 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+	metricsv1beta1 "k8s.io/metrics/pkg/client/clientset/versioned"
+)
+
+// Version of the machine-readable health report. Bump it on breaking schema changes only; v2
+// renamed deployments to workloads and added their kind.
+const healthReportVersion = "simplismart.io/v2"
+
+// Health verdicts, ordered from best to worst
+const (
+	VerdictHealthy     = "Healthy"
+	VerdictProgressing = "Progressing"
+	VerdictDegraded    = "Degraded"
+	VerdictUnavailable = "Unavailable"
+)
+
+// Process exit codes per verdict; 1 is left for usage and connection errors
+var verdictExitCodes = map[string]int{
+	VerdictHealthy:     0,
+	VerdictDegraded:    2,
+	VerdictProgressing: 3,
+	VerdictUnavailable: 4,
+}
+
+var verdictSeverity = map[string]int{
+	VerdictHealthy:     0,
+	VerdictProgressing: 1,
+	VerdictDegraded:    2,
+	VerdictUnavailable: 3,
+}
+
+type healthReport struct {
+	APIVersion string           `json:"apiVersion"`
+	Kind       string           `json:"kind"`
+	Namespace  string           `json:"namespace"`
+	Verdict    string           `json:"verdict"`
+	Workloads  []workloadHealth `json:"workloads"`
+}
+
+type workloadHealth struct {
+	Kind       string            `json:"kind"`
+	Name       string            `json:"name"`
+	Verdict    string            `json:"verdict"`
+	Reasons    []string          `json:"reasons,omitempty"`
+	Replicas   replicaCounts     `json:"replicas"`
+	Conditions []conditionHealth `json:"conditions,omitempty"`
+	Pods       []podHealth       `json:"pods"`
+	Events     []eventHealth     `json:"events,omitempty"`
+	Diagnoses  []diagnosis       `json:"diagnoses,omitempty"`
+	Warnings   []string          `json:"warnings,omitempty"`
+}
+
+type replicaCounts struct {
+	Desired   int32 `json:"desired"`
+	Ready     int32 `json:"ready"`
+	Updated   int32 `json:"updated"`
+	Available int32 `json:"available"`
+}
+
+type conditionHealth struct {
+	Type    string `json:"type"`
+	Status  string `json:"status"`
+	Reason  string `json:"reason,omitempty"`
+	Message string `json:"message,omitempty"`
+}
+
+type podHealth struct {
+	Name       string            `json:"name"`
+	Phase      string            `json:"phase"`
+	Node       string            `json:"node,omitempty"`
+	Ready      bool              `json:"ready"`
+	Restarts   int32             `json:"restarts"`
+	Containers []containerHealth `json:"containers"`
+}
+
+type containerHealth struct {
+	Name            string            `json:"name"`
+	Image           string            `json:"image"`
+	Ready           bool              `json:"ready"`
+	State           string            `json:"state"`
+	Reason          string            `json:"reason,omitempty"`
+	Restarts        int32             `json:"restarts"`
+	LastTermination string            `json:"lastTermination,omitempty"`
+	Usage           map[string]string `json:"usage,omitempty"`
+	Requests        map[string]string `json:"requests,omitempty"`
+	Limits          map[string]string `json:"limits,omitempty"`
+}
+
+type eventHealth struct {
+	Time    string `json:"time"`
+	Object  string `json:"object"`
+	Reason  string `json:"reason"`
+	Message string `json:"message"`
+}
+
+// healthThresholds are the extra gates CI can put on a deployment
+type healthThresholds struct {
+	RequireReady int32
+	MaxRestarts  int32
+}
+
+// collectHealth gathers the status of one workload. Failing to look it up is an error rather
+// than a verdict, so a typo or a lost connection does not read as an outage.
+func collectHealth(clientset *kubernetes.Clientset, metricsClient *metricsv1beta1.Clientset, namespace, kind, name string, allRevisions bool, thresholds healthThresholds) (workloadHealth, error) {
+	health := workloadHealth{Kind: kind, Name: name, Pods: []podHealth{}}
+
+	w, pods, err := getWorkloadPods(clientset, namespace, kind, name, allRevisions)
+	if err != nil {
+		return health, err
+	}
+	health.Kind = w.Kind
+	health.Replicas = replicaCounts{
+		Desired:   w.Desired,
+		Ready:     w.Ready,
+		Updated:   w.Updated,
+		Available: w.Available,
+	}
+	health.Conditions = w.Conditions
+
+	for i := range pods {
+		pod := &pods[i]
+		usage := map[string]corev1.ResourceList{}
+		podMetrics, err := metricsClient.MetricsV1beta1().PodMetricses(namespace).Get(context.TODO(), pod.Name, metav1.GetOptions{})
+		if err != nil {
+			health.Warnings = append(health.Warnings, fmt.Sprintf("failed to get metrics for pod %s: %v", pod.Name, err))
+		} else {
+			for _, c := range podMetrics.Containers {
+				usage[c.Name] = c.Usage
+			}
+		}
+		health.Pods = append(health.Pods, podToHealth(pod, usage))
+		health.Diagnoses = append(health.Diagnoses, diagnosePod(pod)...)
+	}
+
+	// Explain failures from recent warning events
+	events, err := getWorkloadWarningEvents(clientset, w, pods)
+	if err != nil {
+		health.Warnings = append(health.Warnings, fmt.Sprintf("failed to get events: %v", err))
+	}
+	for i, event := range events {
+		if i == 10 {
+			break
+		}
+		health.Events = append(health.Events, eventHealth{
+			Time:    eventTime(event).Format(time.RFC3339),
+			Object:  event.InvolvedObject.Kind + "/" + event.InvolvedObject.Name,
+			Reason:  event.Reason,
+			Message: event.Message,
+		})
+	}
+	health.Diagnoses = append(health.Diagnoses, diagnoseEvents(events)...)
+
+	health.Verdict, health.Reasons = workloadVerdict(w, health, thresholds)
+	return health, nil
+}
+
+func podToHealth(pod *corev1.Pod, usage map[string]corev1.ResourceList) podHealth {
+	ph := podHealth{
+		Name:       pod.Name,
+		Phase:      string(pod.Status.Phase),
+		Node:       pod.Spec.NodeName,
+		Containers: []containerHealth{},
+	}
+	for _, c := range pod.Status.Conditions {
+		if c.Type == corev1.PodReady {
+			ph.Ready = c.Status == corev1.ConditionTrue
+		}
+	}
+	statuses := map[string]corev1.ContainerStatus{}
+	for _, cs := range pod.Status.ContainerStatuses {
+		statuses[cs.Name] = cs
+	}
+	for _, c := range pod.Spec.Containers {
+		ch := containerHealth{
+			Name:     c.Name,
+			Image:    c.Image,
+			State:    "Unknown",
+			Requests: resourceStrings(c.Resources.Requests),
+			Limits:   resourceStrings(c.Resources.Limits),
+			Usage:    resourceStrings(usage[c.Name]),
+		}
+		if cs, ok := statuses[c.Name]; ok {
+			ch.Ready = cs.Ready
+			ch.Restarts = cs.RestartCount
+			switch {
+			case cs.State.Running != nil:
+				ch.State = "Running"
+			case cs.State.Waiting != nil:
+				ch.State, ch.Reason = "Waiting", cs.State.Waiting.Reason
+			case cs.State.Terminated != nil:
+				ch.State, ch.Reason = "Terminated", cs.State.Terminated.Reason
+			}
+			if last := cs.LastTerminationState.Terminated; last != nil {
+				ch.LastTermination = fmt.Sprintf("%s (exit code %d)", last.Reason, last.ExitCode)
+			}
+		}
+		ph.Restarts += ch.Restarts
+		ph.Containers = append(ph.Containers, ch)
+	}
+	return ph
+}
+
+func resourceStrings(list corev1.ResourceList) map[string]string {
+	if len(list) == 0 {
+		return nil
+	}
+	result := map[string]string{}
+	for name, quantity := range list {
+		result[string(name)] = quantity.String()
+	}
+	return result
+}
+
+// workloadVerdict decides the verdict from replica counts, conditions, diagnoses and thresholds
+func workloadVerdict(w *workload, health workloadHealth, thresholds healthThresholds) (string, []string) {
+	var reasons []string
+	replicas := health.Replicas
+	if replicas.Desired > 0 && replicas.Available == 0 {
+		return VerdictUnavailable, []string{"no replicas are available"}
+	}
+
+	verdict := VerdictHealthy
+	for _, c := range w.Conditions {
+		if c.Type == string(appsv1.DeploymentProgressing) && c.Reason == "ProgressDeadlineExceeded" {
+			verdict = VerdictDegraded
+			reasons = append(reasons, "rollout exceeded its progress deadline")
+		}
+	}
+	if verdict == VerdictHealthy && (w.ObservedGeneration < w.Generation || replicas.Updated < replicas.Desired) {
+		verdict = VerdictProgressing
+		reasons = append(reasons, fmt.Sprintf("rollout in progress: %d/%d replicas updated", replicas.Updated, replicas.Desired))
+	}
+	if replicas.Available < replicas.Desired && verdict != VerdictProgressing {
+		verdict = VerdictDegraded
+		reasons = append(reasons, fmt.Sprintf("only %d/%d replicas available", replicas.Available, replicas.Desired))
+	}
+	if thresholds.RequireReady > 0 && replicas.Ready < thresholds.RequireReady {
+		verdict = VerdictDegraded
+		reasons = append(reasons, fmt.Sprintf("%d ready replicas, %d required", replicas.Ready, thresholds.RequireReady))
+	}
+	if thresholds.MaxRestarts >= 0 {
+		for _, pod := range health.Pods {
+			if pod.Restarts > thresholds.MaxRestarts {
+				verdict = VerdictDegraded
+				reasons = append(reasons, fmt.Sprintf("pod %s restarted %d times, at most %d allowed", pod.Name, pod.Restarts, thresholds.MaxRestarts))
+			}
+		}
+	}
+	if verdict == VerdictHealthy && len(health.Diagnoses) > 0 {
+		verdict = VerdictDegraded
+		reasons = append(reasons, fmt.Sprintf("%d problems diagnosed", len(health.Diagnoses)))
+	}
+	return verdict, reasons
+}
+
+// worstVerdict returns the most severe of the verdicts
+func worstVerdict(verdicts ...string) string {
+	worst := VerdictHealthy
+	for _, v := range verdicts {
+		if verdictSeverity[v] > verdictSeverity[worst] {
+			worst = v
+		}
+	}
+	return worst
+}
@@ -0,0 +1,309 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"time"
+
+	"helm.sh/helm/v3/pkg/action"
+	"helm.sh/helm/v3/pkg/chart"
+	"helm.sh/helm/v3/pkg/chart/loader"
+	"helm.sh/helm/v3/pkg/cli"
+	"helm.sh/helm/v3/pkg/cli/values"
+	"helm.sh/helm/v3/pkg/getter"
+	"helm.sh/helm/v3/pkg/registry"
+	"helm.sh/helm/v3/pkg/release"
+	"helm.sh/helm/v3/pkg/repo"
+	"helm.sh/helm/v3/pkg/storage/driver"
+)
+
+// helmRelease is the part of a Helm release the CLI reports on
+type helmRelease struct {
+	Name         string `json:"name"`
+	Namespace    string `json:"namespace"`
+	Revision     int    `json:"revision"`
+	Status       string `json:"status"`
+	ChartName    string `json:"chart"`
+	ChartVersion string `json:"chartVersion"`
+	AppVersion   string `json:"appVersion"`
+	// Images the release's manifest pulls
+	Images []string `json:"images,omitempty"`
+}
+
+// chartOptions are passed to install and upgrade. Chart is a "<repo>/<chart>" reference, an
+// "oci://" reference, or a local chart directory or archive.
+type chartOptions struct {
+	Release         string
+	Namespace       string
+	Chart           string
+	Version         string
+	ValuesFiles     []string
+	Set             []string
+	Wait            bool
+	Timeout         time.Duration
+	CreateNamespace bool
+	// Registry every container image is pulled from instead of its own
+	ImageRegistry string
+}
+
+// helmError tells which Helm operation failed on which release
+type helmError struct {
+	Op      string
+	Release string
+	Err     error
+}
+
+func (e *helmError) Error() string {
+	if e.Release == "" {
+		return fmt.Sprintf("helm %s: %v", e.Op, e.Err)
+	}
+	return fmt.Sprintf("helm %s %s: %v", e.Op, e.Release, e.Err)
+}
+
+func (e *helmError) Unwrap() error { return e.Err }
+
+// helmClient runs Helm in-process. actionConfig is replaceable so a memory driver and fake
+// kube client can stand in for a cluster.
+type helmClient struct {
+	settings     *cli.EnvSettings
+	plainHTTP    bool
+	actionConfig func(namespace string) (*action.Configuration, error)
+}
+
+// newHelmClient returns a client for the kubeconfig and context the other commands use
+func newHelmClient() *helmClient {
+	settings := cli.New()
+	settings.KubeConfig = kubeconfig
+	active, _ := activeSelection()
+	settings.KubeContext = active.Context
+	h := &helmClient{settings: settings}
+	h.actionConfig = func(namespace string) (*action.Configuration, error) {
+		cfg := new(action.Configuration)
+		settings.SetNamespace(namespace)
+		debug := func(format string, v ...interface{}) {
+			if settings.Debug {
+				log.Printf(format, v...)
+			}
+		}
+		if err := cfg.Init(settings.RESTClientGetter(), namespace, os.Getenv("HELM_DRIVER"), debug); err != nil {
+			return nil, err
+		}
+		registryClient, err := h.registryClient()
+		if err != nil {
+			return nil, err
+		}
+		cfg.RegistryClient = registryClient
+		return cfg, nil
+	}
+	return h
+}
+
+// registryClient pulls OCI charts with the credentials saved by 'helm registry login'
+func (h *helmClient) registryClient() (*registry.Client, error) {
+	options := []registry.ClientOption{
+		registry.ClientOptCredentialsFile(h.settings.RegistryConfig),
+		registry.ClientOptWriter(io.Discard),
+		registry.ClientOptEnableCache(true),
+	}
+	if h.plainHTTP {
+		options = append(options, registry.ClientOptPlainHTTP())
+	}
+	return registry.NewClient(options...)
+}
+
+// addRepo adds or refreshes a chart repository in Helm's repositories.yaml and caches its index
+func (h *helmClient) addRepo(name, url string) error {
+	repoFile, err := repo.LoadFile(h.settings.RepositoryConfig)
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return &helmError{Op: "repo add", Release: name, Err: err}
+	}
+	if repoFile == nil {
+		repoFile = repo.NewFile()
+	}
+	entry := &repo.Entry{Name: name, URL: url}
+	chartRepo, err := repo.NewChartRepository(entry, getter.All(h.settings))
+	if err != nil {
+		return &helmError{Op: "repo add", Release: name, Err: err}
+	}
+	chartRepo.CachePath = h.settings.RepositoryCache
+	if _, err := chartRepo.DownloadIndexFile(); err != nil {
+		return &helmError{Op: "repo add", Release: name, Err: fmt.Errorf("cannot reach %s: %v", url, err)}
+	}
+	repoFile.Update(entry)
+	if err := os.MkdirAll(filepath.Dir(h.settings.RepositoryConfig), 0755); err != nil {
+		return &helmError{Op: "repo add", Release: name, Err: err}
+	}
+	if err := repoFile.WriteFile(h.settings.RepositoryConfig, 0644); err != nil {
+		return &helmError{Op: "repo add", Release: name, Err: err}
+	}
+	return nil
+}
+
+// getRelease returns the release, or nil when it is not installed
+func (h *helmClient) getRelease(name, namespace string) (*helmRelease, error) {
+	cfg, err := h.actionConfig(namespace)
+	if err != nil {
+		return nil, &helmError{Op: "status", Release: name, Err: err}
+	}
+	rel, err := action.NewStatus(cfg).Run(name)
+	if errors.Is(err, driver.ErrReleaseNotFound) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, &helmError{Op: "status", Release: name, Err: err}
+	}
+	result := &helmRelease{
+		Name:      rel.Name,
+		Namespace: rel.Namespace,
+		Revision:  rel.Version,
+		Status:    rel.Info.Status.String(),
+		Images:    releaseImages(rel),
+	}
+	if rel.Chart != nil && rel.Chart.Metadata != nil {
+		result.ChartName = rel.Chart.Metadata.Name
+		result.ChartVersion = rel.Chart.Metadata.Version
+		result.AppVersion = rel.Chart.Metadata.AppVersion
+	}
+	return result, nil
+}
+
+// listReleases returns the releases of every namespace
+func (h *helmClient) listReleases() ([]helmRelease, error) {
+	cfg, err := h.actionConfig("")
+	if err != nil {
+		return nil, &helmError{Op: "list", Err: err}
+	}
+	list := action.NewList(cfg)
+	list.All = true
+	list.AllNamespaces = true
+	list.SetStateMask()
+	releases, err := list.Run()
+	if err != nil {
+		return nil, &helmError{Op: "list", Err: err}
+	}
+	result := make([]helmRelease, 0, len(releases))
+	for _, rel := range releases {
+		r := helmRelease{Name: rel.Name, Namespace: rel.Namespace, Revision: rel.Version, Status: rel.Info.Status.String()}
+		if rel.Chart != nil && rel.Chart.Metadata != nil {
+			r.ChartName, r.ChartVersion, r.AppVersion = rel.Chart.Metadata.Name, rel.Chart.Metadata.Version, rel.Chart.Metadata.AppVersion
+		}
+		result = append(result, r)
+	}
+	return result, nil
+}
+
+func (h *helmClient) install(opts chartOptions) error {
+	cfg, err := h.actionConfig(opts.Namespace)
+	if err != nil {
+		return &helmError{Op: "install", Release: opts.Release, Err: err}
+	}
+	install := action.NewInstall(cfg)
+	install.ReleaseName = opts.Release
+	install.Namespace = opts.Namespace
+	install.CreateNamespace = opts.CreateNamespace
+	install.Version = opts.Version
+	install.Wait = opts.Wait
+	install.Timeout = opts.Timeout
+	if opts.ImageRegistry != "" {
+		install.PostRenderer = imageRewriter{registry: opts.ImageRegistry}
+	}
+	_, chrt, vals, err := h.loadChart(&install.ChartPathOptions, opts)
+	if err != nil {
+		return &helmError{Op: "install", Release: opts.Release, Err: err}
+	}
+	if _, err := install.Run(chrt, vals); err != nil {
+		return &helmError{Op: "install", Release: opts.Release, Err: err}
+	}
+	return nil
+}
+
+func (h *helmClient) upgrade(opts chartOptions) error {
+	cfg, err := h.actionConfig(opts.Namespace)
+	if err != nil {
+		return &helmError{Op: "upgrade", Release: opts.Release, Err: err}
+	}
+	upgrade := action.NewUpgrade(cfg)
+	upgrade.Namespace = opts.Namespace
+	upgrade.Version = opts.Version
+	upgrade.Wait = opts.Wait
+	upgrade.Timeout = opts.Timeout
+	// Keep earlier values unless new ones are given
+	upgrade.ReuseValues = len(opts.ValuesFiles) == 0 && len(opts.Set) == 0
+	if opts.ImageRegistry != "" {
+		upgrade.PostRenderer = imageRewriter{registry: opts.ImageRegistry}
+	}
+	_, chrt, vals, err := h.loadChart(&upgrade.ChartPathOptions, opts)
+	if err != nil {
+		return &helmError{Op: "upgrade", Release: opts.Release, Err: err}
+	}
+	if _, err := upgrade.Run(opts.Release, chrt, vals); err != nil {
+		return &helmError{Op: "upgrade", Release: opts.Release, Err: err}
+	}
+	return nil
+}
+
+func (h *helmClient) uninstall(release, namespace string, wait bool, timeout time.Duration) error {
+	cfg, err := h.actionConfig(namespace)
+	if err != nil {
+		return &helmError{Op: "uninstall", Release: release, Err: err}
+	}
+	uninstall := action.NewUninstall(cfg)
+	uninstall.Wait = wait
+	uninstall.Timeout = timeout
+	if _, err := uninstall.Run(release); err != nil {
+		return &helmError{Op: "uninstall", Release: release, Err: err}
+	}
+	return nil
+}
+
+// template renders the chart without a cluster, as 'helm template' does, and returns the
+// release with the path of the chart it was rendered from
+func (h *helmClient) template(opts chartOptions) (*release.Release, string, error) {
+	registryClient, err := h.registryClient()
+	if err != nil {
+		return nil, "", &helmError{Op: "template", Release: opts.Release, Err: err}
+	}
+	cfg := &action.Configuration{RegistryClient: registryClient, Log: func(string, ...interface{}) {}}
+	install := action.NewInstall(cfg)
+	install.ReleaseName = opts.Release
+	install.Namespace = opts.Namespace
+	install.Version = opts.Version
+	install.DryRun = true
+	install.ClientOnly = true
+	install.Replace = true
+	install.IncludeCRDs = true
+	if opts.ImageRegistry != "" {
+		install.PostRenderer = imageRewriter{registry: opts.ImageRegistry}
+	}
+	path, chrt, vals, err := h.loadChart(&install.ChartPathOptions, opts)
+	if err != nil {
+		return nil, "", &helmError{Op: "template", Release: opts.Release, Err: err}
+	}
+	rel, err := install.Run(chrt, vals)
+	if err != nil {
+		return nil, "", &helmError{Op: "template", Release: opts.Release, Err: err}
+	}
+	return rel, path, nil
+}
+
+// loadChart resolves the chart reference and merges the values files and --set values
+func (h *helmClient) loadChart(pathOptions *action.ChartPathOptions, opts chartOptions) (string, *chart.Chart, map[string]interface{}, error) {
+	path, err := pathOptions.LocateChart(opts.Chart, h.settings)
+	if err != nil {
+		return "", nil, nil, err
+	}
+	chrt, err := loader.Load(path)
+	if err != nil {
+		return "", nil, nil, fmt.Errorf("cannot load chart %s: %v", opts.Chart, err)
+	}
+	valueOpts := &values.Options{ValueFiles: opts.ValuesFiles, Values: opts.Set}
+	vals, err := valueOpts.MergeValues(getter.All(h.settings))
+	if err != nil {
+		return "", nil, nil, err
+	}
+	return path, chrt, vals, nil
+}
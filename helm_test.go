@@ -0,0 +1,139 @@
+package main
+
+import (
+	"errors"
+	"io"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"helm.sh/helm/v3/pkg/action"
+	"helm.sh/helm/v3/pkg/chartutil"
+	"helm.sh/helm/v3/pkg/cli"
+	kubefake "helm.sh/helm/v3/pkg/kube/fake"
+	"helm.sh/helm/v3/pkg/storage"
+	"helm.sh/helm/v3/pkg/storage/driver"
+)
+
+const testChart = "testdata/demo-chart"
+
+// newTestHelmClient returns a client keeping releases in memory and applying nothing
+func newTestHelmClient(t *testing.T) *helmClient {
+	t.Helper()
+	dir := t.TempDir()
+	settings := cli.New()
+	settings.RepositoryConfig = filepath.Join(dir, "repositories.yaml")
+	settings.RepositoryCache = filepath.Join(dir, "cache")
+	settings.RegistryConfig = filepath.Join(dir, "registry.json")
+
+	memory := driver.NewMemory()
+	cfg := &action.Configuration{
+		Releases:     storage.Init(memory),
+		KubeClient:   &kubefake.PrintingKubeClient{Out: io.Discard},
+		Capabilities: chartutil.DefaultCapabilities,
+		Log:          func(string, ...interface{}) {},
+	}
+	return &helmClient{
+		settings: settings,
+		actionConfig: func(namespace string) (*action.Configuration, error) {
+			memory.SetNamespace(namespace)
+			return cfg, nil
+		},
+	}
+}
+
+func TestHelmInstallUpgradeUninstall(t *testing.T) {
+	h := newTestHelmClient(t)
+	opts := chartOptions{Release: "demo", Namespace: "apps", Chart: testChart}
+
+	if err := h.install(opts); err != nil {
+		t.Fatalf("install: %v", err)
+	}
+	rel, err := h.getRelease("demo", "apps")
+	if err != nil {
+		t.Fatalf("getRelease: %v", err)
+	}
+	want := &helmRelease{
+		Name:         "demo",
+		Namespace:    "apps",
+		Revision:     1,
+		Status:       "deployed",
+		ChartName:    "demo",
+		ChartVersion: "0.1.0",
+		AppVersion:   "1.0",
+		Images:       []string{"example/demo:1.0"},
+	}
+	if !reflect.DeepEqual(rel, want) {
+		t.Errorf("after install got %+v, want %+v", rel, want)
+	}
+
+	opts.Set = []string{"image=example/demo:2.0"}
+	if err := h.upgrade(opts); err != nil {
+		t.Fatalf("upgrade: %v", err)
+	}
+	rel, err = h.getRelease("demo", "apps")
+	if err != nil {
+		t.Fatalf("getRelease: %v", err)
+	}
+	if rel.Revision != 2 || !reflect.DeepEqual(rel.Images, []string{"example/demo:2.0"}) {
+		t.Errorf("after upgrade got revision %d with images %v, want revision 2 with example/demo:2.0", rel.Revision, rel.Images)
+	}
+
+	// Without new values the upgrade keeps the ones given before
+	opts.Set = nil
+	if err := h.upgrade(opts); err != nil {
+		t.Fatalf("upgrade: %v", err)
+	}
+	rel, _ = h.getRelease("demo", "apps")
+	if rel.Revision != 3 || !reflect.DeepEqual(rel.Images, []string{"example/demo:2.0"}) {
+		t.Errorf("after reusing values got revision %d with images %v, want revision 3 with example/demo:2.0", rel.Revision, rel.Images)
+	}
+
+	releases, err := h.listReleases()
+	if err != nil {
+		t.Fatalf("listReleases: %v", err)
+	}
+	if len(releases) != 1 || releases[0].Name != "demo" || releases[0].Revision != 3 {
+		t.Errorf("listReleases got %+v, want demo at revision 3", releases)
+	}
+
+	if err := h.uninstall("demo", "apps", false, 0); err != nil {
+		t.Fatalf("uninstall: %v", err)
+	}
+	rel, err = h.getRelease("demo", "apps")
+	if err != nil || rel != nil {
+		t.Errorf("after uninstall got %+v, %v, want no release", rel, err)
+	}
+}
+
+func TestHelmInstallImageRegistry(t *testing.T) {
+	h := newTestHelmClient(t)
+	opts := chartOptions{Release: "demo", Namespace: "apps", Chart: testChart, ImageRegistry: "mirror.local:5000"}
+	if err := h.install(opts); err != nil {
+		t.Fatalf("install: %v", err)
+	}
+	rel, err := h.getRelease("demo", "apps")
+	if err != nil {
+		t.Fatalf("getRelease: %v", err)
+	}
+	if want := []string{"mirror.local:5000/example/demo:1.0"}; !reflect.DeepEqual(rel.Images, want) {
+		t.Errorf("got images %v, want %v", rel.Images, want)
+	}
+}
+
+func TestHelmErrors(t *testing.T) {
+	h := newTestHelmClient(t)
+	err := h.upgrade(chartOptions{Release: "missing", Namespace: "apps", Chart: testChart})
+	var helmErr *helmError
+	if !errors.As(err, &helmErr) || helmErr.Op != "upgrade" || helmErr.Release != "missing" {
+		t.Errorf("upgrade of a missing release got %v, want a helmError for upgrade missing", err)
+	}
+	err = h.uninstall("missing", "apps", false, 0)
+	if !errors.As(err, &helmErr) || helmErr.Op != "uninstall" {
+		t.Errorf("uninstall of a missing release got %v, want a helmError for uninstall", err)
+	}
+	err = h.install(chartOptions{Release: "demo", Namespace: "apps", Chart: "testdata/no-such-chart"})
+	if !errors.As(err, &helmErr) || helmErr.Op != "install" {
+		t.Errorf("install of a missing chart got %v, want a helmError for install", err)
+	}
+}
@@ -1,22 +1,63 @@
 package main
 
 import (
+	"os"
+	"path/filepath"
+
 	"k8s.io/client-go/kubernetes"
 	"k8s.io/client-go/rest"
 	"k8s.io/client-go/tools/clientcmd"
 	metricsv1beta1 "k8s.io/metrics/pkg/client/clientset/versioned"
 )
 
-func GetK8sClient() (*kubernetes.Clientset, error) {
-	var config *rest.Config
-	var err error
+// Set by the global --kubeconfig and --context flags
+var kubeContext string
 
+// kubeconfigPath returns the kubeconfig file the clients read first
+func kubeconfigPath() string {
 	if kubeconfig != "" {
-		config, err = clientcmd.BuildConfigFromFlags("", kubeconfig)
-	} else {
-		config, err = clientcmd.BuildConfigFromFlags("", clientcmd.RecommendedHomeFile)
+		return kubeconfig
+	}
+	if paths := filepath.SplitList(os.Getenv("KUBECONFIG")); len(paths) > 0 && paths[0] != "" {
+		return paths[0]
 	}
+	return clientcmd.RecommendedHomeFile
+}
+
+// kubeClientConfig merges $KUBECONFIG like kubectl does. The context and namespace selected with
+// --context, .simplismart.yaml or connect take precedence over its current context.
+func kubeClientConfig() clientcmd.ClientConfig {
+	rules := clientcmd.NewDefaultClientConfigLoadingRules()
+	rules.ExplicitPath = kubeconfig
+	active, _ := activeSelection()
+	overrides := &clientcmd.ConfigOverrides{CurrentContext: active.Context}
+	overrides.Context.Namespace = active.Namespace
+	return clientcmd.NewNonInteractiveDeferredLoadingClientConfig(rules, overrides)
+}
+
+// kubeconfigAccess edits the kubeconfig like kubectl config does, writing each entry back to the
+// file that defines it
+func kubeconfigAccess() clientcmd.ConfigAccess {
+	pathOptions := clientcmd.NewDefaultPathOptions()
+	pathOptions.LoadingRules.ExplicitPath = kubeconfig
+	return pathOptions
+}
 
+// contextNamespace is the namespace commands use without --namespace
+func contextNamespace() string {
+	namespace, _, err := kubeClientConfig().Namespace()
+	if err != nil || namespace == "" {
+		return "default"
+	}
+	return namespace
+}
+
+func GetRestConfig() (*rest.Config, error) {
+	return kubeClientConfig().ClientConfig()
+}
+
+func GetK8sClient() (*kubernetes.Clientset, error) {
+	config, err := GetRestConfig()
 	if err != nil {
 		return nil, err
 	}
@@ -25,18 +66,10 @@ func GetK8sClient() (*kubernetes.Clientset, error) {
 }
 
 func GetMetricsClient() (*metricsv1beta1.Clientset, error) {
-	var config *rest.Config
-	var err error
-
-	if kubeconfig != "" {
-		config, err = clientcmd.BuildConfigFromFlags("", kubeconfig)
-	} else {
-		config, err = clientcmd.BuildConfigFromFlags("", clientcmd.RecommendedHomeFile)
-	}
-
+	config, err := GetRestConfig()
 	if err != nil {
 		return nil, err
 	}
 
 	return metricsv1beta1.NewForConfig(config)
-}
\ No newline at end of file
+}
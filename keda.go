@@ -1,56 +1,40 @@
 package main
 
 import (
-	"context"
 	"fmt"
-	"os/exec"
+	"time"
 
-	// "k8s.io/client-go/tools/clientcmd"
 	"github.com/spf13/cobra"
-	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
-	// "k8s.io/client-go/tools/clientcmd/api"
 )
 
 var InstallKEDACmd = &cobra.Command{
-	Use:   "install-keda",
-	Short: "Install KEDA on the Kubernetes cluster",
+	Use:        "install-keda",
+	Short:      "Install KEDA on the Kubernetes cluster",
+	Deprecated: "use 'addons keda install' instead",
 	Run: func(cmd *cobra.Command, args []string) {
+		skipPreflight, _ := cmd.Flags().GetBool("skip-preflight")
 		// Create a Kubernetes client
 		clientset, err := GetK8sClient()
 		if err != nil {
 			fmt.Println("Error creating Kubernetes client:", err)
 			return
 		}
-
-		// Check if KEDA deployment exists
-		deploymentName := "keda-operator"
-		namespace := "keda"
-		_, err = clientset.AppsV1().Deployments(namespace).Get(context.TODO(), deploymentName, metav1.GetOptions{})
-		if err != nil {
-			fmt.Println("KEDA is not running, installing...")
-			// Install KEDA using Helm
-			// Combine Helm commands into a single command execution
-			helmCmd := exec.Command("sh", "-c", "helm repo add kedacore https://kedacore.github.io/charts && helm repo update && helm install keda kedacore/keda --namespace "+namespace+" --create-namespace")
-			output, err := helmCmd.CombinedOutput()
-			if err != nil {
-				fmt.Println("Error installing KEDA:", err)
+		if !skipPreflight {
+			if err := preflightRBAC(clientset, "install-keda", installKEDAPermissions(kedaAddon.Namespace)); err != nil {
+				fmt.Println(err)
 				return
 			}
-			fmt.Println(string(output))
-		} else {
-			// Check if the KEDA operator pods are running
-			pods, err := clientset.CoreV1().Pods(namespace).List(context.TODO(), metav1.ListOptions{
-				LabelSelector: "app=keda-operator", // Adjust the label selector as needed
-			})
-			if err != nil {
-				fmt.Println("Error retrieving KEDA operator pods:", err)
-				return
-			}
-			if len(pods.Items) == 0 {
-				fmt.Println("KEDA operator pods are not running.")
-			} else {
-				fmt.Println("KEDA operator pods are running.")
-			}
+		}
+		if err := installAddon(newHelmClient(), clientset, kedaAddon, addonOptions{Timeout: 5 * time.Minute}); err != nil {
+			fmt.Println("Error installing KEDA:", err)
+			return
+		}
+		if err := printAddonStatus(newHelmClient(), clientset, kedaAddon); err != nil {
+			fmt.Println(err)
 		}
 	},
 }
+
+func init() {
+	InstallKEDACmd.Flags().Bool("skip-preflight", false, "Skip the permission check before installing")
+}
@@ -0,0 +1,312 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"hash/fnv"
+	"os"
+	"os/signal"
+	"regexp"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/spf13/cobra"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/watch"
+	"k8s.io/client-go/kubernetes"
+)
+
+// ANSI colors used to tell pods apart in the aggregated output
+var podColors = []string{"\033[31m", "\033[32m", "\033[33m", "\033[34m", "\033[35m", "\033[36m", "\033[91m", "\033[92m", "\033[93m", "\033[94m", "\033[95m", "\033[96m"}
+
+const colorReset = "\033[0m"
+
+type logLine struct {
+	Pod       string `json:"pod"`
+	Container string `json:"container"`
+	Message   string `json:"message"`
+}
+
+type logStreamer struct {
+	clientset *kubernetes.Clientset
+	namespace string
+	container string
+	options   corev1.PodLogOptions
+	lines     chan logLine
+
+	mu       sync.Mutex
+	active   map[string]bool
+	lastSeen map[string]time.Time
+	wg       sync.WaitGroup
+}
+
+var LogsCmd = &cobra.Command{
+	Use:   "logs",
+	Short: "Stream logs from all pods of a workload",
+	Run: func(cmd *cobra.Command, args []string) {
+		name, _ := cmd.Flags().GetString("name")
+		namespace, _ := cmd.Flags().GetString("namespace")
+		if namespace == "" {
+			namespace = contextNamespace()
+		}
+		kind, _ := cmd.Flags().GetString("kind")
+		allRevisions, _ := cmd.Flags().GetBool("all-revisions")
+		follow, _ := cmd.Flags().GetBool("follow")
+		since, _ := cmd.Flags().GetDuration("since")
+		tail, _ := cmd.Flags().GetInt64("tail")
+		previous, _ := cmd.Flags().GetBool("previous")
+		container, _ := cmd.Flags().GetString("container")
+		grep, _ := cmd.Flags().GetString("grep")
+		output := outputFormat(cmd, "text", "json")
+
+		if output != "text" && output != "json" {
+			fmt.Printf("Invalid output format %q, expected text or json\n", output)
+			return
+		}
+		if follow && previous {
+			fmt.Println("--follow cannot be combined with --previous")
+			return
+		}
+		var filter *regexp.Regexp
+		if grep != "" {
+			var err error
+			filter, err = regexp.Compile(grep)
+			if err != nil {
+				fmt.Printf("Invalid --grep expression: %v\n", err)
+				return
+			}
+		}
+
+		clientset, err := GetK8sClient()
+		if err != nil {
+			fmt.Println("Error creating Kubernetes client:", err)
+			return
+		}
+		// A blue/green deployment shows the logs of the color serving traffic
+		if name, err = liveWorkload(clientset, namespace, kind, name); err != nil {
+			fmt.Println(err)
+			return
+		}
+		w, pods, err := getWorkloadPods(clientset, namespace, kind, name, allRevisions)
+		if err != nil {
+			fmt.Println(err)
+			return
+		}
+		if len(pods) == 0 && !follow {
+			fmt.Printf("No pods found for %s %s\n", strings.ToLower(w.Kind), name)
+			return
+		}
+
+		ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+		defer stop()
+
+		options := corev1.PodLogOptions{
+			Follow:   follow,
+			Previous: previous,
+		}
+		if since > 0 {
+			seconds := int64(since.Seconds())
+			options.SinceSeconds = &seconds
+		}
+		if tail >= 0 {
+			options.TailLines = &tail
+		}
+		newLogStreamer(clientset, namespace, container, options).run(ctx, w, pods, output, filter)
+	},
+}
+
+func newLogStreamer(clientset *kubernetes.Clientset, namespace, container string, options corev1.PodLogOptions) *logStreamer {
+	return &logStreamer{
+		clientset: clientset,
+		namespace: namespace,
+		container: container,
+		options:   options,
+		lines:     make(chan logLine, 100),
+		active:    map[string]bool{},
+		lastSeen:  map[string]time.Time{},
+	}
+}
+
+// run prints the logs of the pods until they end, or until ctx is cancelled when following
+func (s *logStreamer) run(ctx context.Context, w *workload, pods []corev1.Pod, output string, filter *regexp.Regexp) {
+	done := make(chan struct{})
+	go func() {
+		printLogLines(s.lines, output, filter)
+		close(done)
+	}()
+
+	for i := range pods {
+		s.startPod(ctx, &pods[i])
+	}
+	if s.options.Follow {
+		// Pick up pods created by rollouts or scale-ups while following
+		s.watchPods(ctx, w)
+	}
+	s.wg.Wait()
+	close(s.lines)
+	<-done
+}
+
+// startPod streams every started container of the pod that is not already being streamed
+func (s *logStreamer) startPod(ctx context.Context, pod *corev1.Pod) {
+	statuses := append([]corev1.ContainerStatus{}, pod.Status.InitContainerStatuses...)
+	statuses = append(statuses, pod.Status.ContainerStatuses...)
+	for _, status := range statuses {
+		if s.container != "" && status.Name != s.container {
+			continue
+		}
+		if s.container == "" && isInitContainer(pod, status.Name) && status.State.Running == nil {
+			// Finished init containers are only shown when asked for explicitly
+			continue
+		}
+		key := pod.Name + "/" + status.Name
+		s.mu.Lock()
+		_, seen := s.lastSeen[key]
+		// Terminated containers are only read once, running ones again after a restart
+		if s.active[key] || (status.State.Running == nil && (seen || (status.State.Terminated == nil && !s.options.Previous))) {
+			s.mu.Unlock()
+			continue
+		}
+		s.active[key] = true
+		options := s.options
+		options.Container = status.Name
+		if last, ok := s.lastSeen[key]; ok {
+			// Resume a restarted container where the previous stream stopped
+			sinceTime := metav1.NewTime(last)
+			options.SinceTime = &sinceTime
+			options.SinceSeconds = nil
+			options.TailLines = nil
+		}
+		s.mu.Unlock()
+
+		s.wg.Add(1)
+		go s.stream(ctx, pod.Name, status.Name, options)
+	}
+}
+
+func (s *logStreamer) stream(ctx context.Context, podName, containerName string, options corev1.PodLogOptions) {
+	defer s.wg.Done()
+	key := podName + "/" + containerName
+	defer func() {
+		s.mu.Lock()
+		delete(s.active, key)
+		s.lastSeen[key] = time.Now()
+		s.mu.Unlock()
+	}()
+
+	reader, err := s.clientset.CoreV1().Pods(s.namespace).GetLogs(podName, &options).Stream(ctx)
+	if err != nil {
+		if ctx.Err() == nil {
+			fmt.Fprintf(os.Stderr, "Error streaming logs for %s: %v\n", key, err)
+		}
+		return
+	}
+	defer reader.Close()
+
+	scanner := bufio.NewScanner(reader)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		select {
+		case s.lines <- logLine{Pod: podName, Container: containerName, Message: scanner.Text()}:
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// watchPods starts streaming pods of the workload as they become ready until ctx is cancelled
+func (s *logStreamer) watchPods(ctx context.Context, w *workload) {
+	for ctx.Err() == nil {
+		watcher, err := s.clientset.CoreV1().Pods(s.namespace).Watch(ctx, metav1.ListOptions{
+			LabelSelector: w.Selector.String(),
+		})
+		if err != nil {
+			if ctx.Err() == nil {
+				fmt.Fprintf(os.Stderr, "Error watching pods: %v\n", err)
+				time.Sleep(2 * time.Second)
+			}
+			continue
+		}
+		for event := range watcher.ResultChan() {
+			if event.Type != watch.Added && event.Type != watch.Modified {
+				continue
+			}
+			pod, ok := event.Object.(*corev1.Pod)
+			if !ok {
+				continue
+			}
+			owned, _ := w.owns(pod)
+			if !owned {
+				// A rollout may have created a ReplicaSet we have not seen yet
+				w.refreshOwners(s.clientset)
+				owned, _ = w.owns(pod)
+			}
+			if owned {
+				s.startPod(ctx, pod)
+			}
+		}
+		watcher.Stop()
+	}
+}
+
+func isInitContainer(pod *corev1.Pod, name string) bool {
+	for _, c := range pod.Spec.InitContainers {
+		if c.Name == name {
+			return true
+		}
+	}
+	return false
+}
+
+func printLogLines(lines <-chan logLine, output string, filter *regexp.Regexp) {
+	encoder := json.NewEncoder(os.Stdout)
+	useColor := isTerminal(os.Stdout)
+	for line := range lines {
+		if filter != nil && !filter.MatchString(line.Message) {
+			continue
+		}
+		if output == "json" {
+			encoder.Encode(line)
+			continue
+		}
+		prefix := fmt.Sprintf("[%s/%s]", line.Pod, line.Container)
+		if useColor {
+			prefix = podColor(line.Pod) + prefix + colorReset
+		}
+		fmt.Printf("%s %s\n", prefix, line.Message)
+	}
+}
+
+func podColor(podName string) string {
+	h := fnv.New32a()
+	h.Write([]byte(podName))
+	return podColors[h.Sum32()%uint32(len(podColors))]
+}
+
+func isTerminal(f *os.File) bool {
+	info, err := f.Stat()
+	if err != nil {
+		return false
+	}
+	return info.Mode()&os.ModeCharDevice != 0
+}
+
+func init() {
+	LogsCmd.Flags().String("name", "", "Name of the workload")
+	LogsCmd.Flags().String("namespace", "", "Namespace of the workload (defaults to the context's namespace)")
+	LogsCmd.Flags().String("kind", "deployment", "Kind of the workload (deployment, statefulset or daemonset)")
+	LogsCmd.Flags().Bool("all-revisions", false, "Include pods of previous revisions still running during a rollout")
+	LogsCmd.Flags().BoolP("follow", "f", false, "Follow the logs and pick up new pods")
+	LogsCmd.Flags().Duration("since", 0, "Only return logs newer than a relative duration (e.g., 5m, 1h)")
+	LogsCmd.Flags().Int64("tail", -1, "Number of recent lines to show per container (-1 shows all)")
+	LogsCmd.Flags().Bool("previous", false, "Show logs of the previous container instance")
+	LogsCmd.Flags().StringP("container", "c", "", "Only show logs of this container")
+	LogsCmd.Flags().String("grep", "", "Only show lines matching this regular expression")
+	LogsCmd.Flags().StringP("output", "o", "text", "Output format (text or json)")
+	LogsCmd.MarkFlagRequired("name")
+}
@@ -12,11 +12,29 @@ import (
 
 func main() {
 	var rootCmd = &cobra.Command{Use: "simplismart-cli"}
+	rootCmd.PersistentFlags().StringVar(&kubeconfig, "kubeconfig", "", "Path to the kubeconfig file")
+	rootCmd.PersistentFlags().StringVar(&kubeContext, "context", "", "Kubeconfig context to use")
+	rootCmd.PersistentFlags().StringVar(&profileName, "profile", "", "Config profile to use (see 'config --help')")
 	rootCmd.AddCommand(ConnectCmd)
 	rootCmd.AddCommand(InstallKEDACmd)
 	rootCmd.AddCommand(CreateDeploymentCmd)
+	rootCmd.AddCommand(DeleteDeploymentCmd)
+	rootCmd.AddCommand(DeployModelCmd)
+	rootCmd.AddCommand(TestEndpointCmd)
+	rootCmd.AddCommand(PortForwardCmd)
+	rootCmd.AddCommand(ExecCmd)
+	rootCmd.AddCommand(DebugCmd)
+	rootCmd.AddCommand(ScaleCmd)
+	rootCmd.AddCommand(RestartCmd)
+	rootCmd.AddCommand(CanaryCmd)
+	rootCmd.AddCommand(SwitchCmd)
+	rootCmd.AddCommand(AutoscaleCmd)
 	rootCmd.AddCommand(HealthStatusCmd)
 	rootCmd.AddCommand(DoctorCmd) // Added the doctor command
+	rootCmd.AddCommand(LogsCmd)
+	rootCmd.AddCommand(AddonsCmd)
+	rootCmd.AddCommand(BootstrapCmd)
+	rootCmd.AddCommand(ConfigCmd)
 	GenerateDocs(rootCmd)
 
 	if err := rootCmd.Execute(); err != nil {
@@ -0,0 +1,367 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/spf13/cobra"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/util/intstr"
+)
+
+// A modelServer is a deploy-model template. {model}, {port} and {app} in args, env and the
+// query are replaced with the model, the first port and the deployment name.
+type modelServer struct {
+	Name        string
+	Description string
+	Image       string
+	Ports       []string
+	Command     []string
+	Args        []string
+	Env         map[string]string
+	// HTTP paths on the first port; ReadyCommand replaces ReadyPath when the server is up
+	// before the model is loaded
+	HealthPath   string
+	ReadyPath    string
+	ReadyCommand []string
+	Preset       string
+	ShmSize      string
+	// Where the server keeps downloaded weights, mounted by --model-cache
+	CacheMount  string
+	MetricsPort string
+	MetricsPath string
+	// Prometheus metric names by role: requests, latency and queue
+	Metrics map[string]string
+	// Autoscaling profiles of the ScaledObject
+	ScaleOn []string
+	// Request format test-endpoint sends: openai, kserve or raw
+	Protocol string
+	// Metrics a canary is compared on, the HTTP ones when nil
+	Canary *canaryAnalysis
+}
+
+var vllmServer = modelServer{
+	Name:        "vllm",
+	Description: "vLLM OpenAI-compatible server for Hugging Face LLMs",
+	Image:       "vllm/vllm-openai:v0.6.4",
+	Ports:       []string{"8000"},
+	Args:        []string{"--model", "{model}", "--port", "{port}"},
+	HealthPath:  "/health",
+	ReadyPath:   "/health",
+	Preset:      "gpu-a10-1x",
+	ShmSize:     "8Gi",
+	CacheMount:  "/root/.cache/huggingface",
+	MetricsPath: "/metrics",
+	Metrics: map[string]string{
+		"requests": "vllm:request_success_total",
+		"latency":  "vllm:e2e_request_latency_seconds",
+		"queue":    "vllm:num_requests_waiting",
+	},
+	ScaleOn:  []string{"vllm-queue", "vllm-kv-cache"},
+	Protocol: "openai",
+	Canary: &canaryAnalysis{
+		ErrorRate: httpAnalysis.ErrorRate,
+		Latency:   `sum(rate(vllm:e2e_request_latency_seconds_sum{{selector}}[{window}])) / sum(rate(vllm:e2e_request_latency_seconds_count{{selector}}[{window}]))`,
+	},
+}
+
+var tritonServer = modelServer{
+	Name:        "triton",
+	Description: "NVIDIA Triton Inference Server for a model repository (path, s3:// or gs://)",
+	Image:       "nvcr.io/nvidia/tritonserver:24.10-py3",
+	Ports:       []string{"8000", "8001", "8002"},
+	Command:     []string{"tritonserver"},
+	Args:        []string{"--model-repository={model}", "--http-port={port}"},
+	HealthPath:  "/v2/health/live",
+	ReadyPath:   "/v2/health/ready",
+	Preset:      "gpu-a10-1x",
+	ShmSize:     "4Gi",
+	CacheMount:  "/models",
+	MetricsPort: "8002",
+	MetricsPath: "/metrics",
+	Metrics: map[string]string{
+		"requests": "nv_inference_request_success",
+		"latency":  "nv_inference_request_duration_us",
+		"queue":    "nv_inference_pending_request_count",
+	},
+	ScaleOn:  []string{"triton-queue"},
+	Protocol: "kserve",
+	Canary: &canaryAnalysis{
+		ErrorRate: `sum(rate(nv_inference_request_failure{{selector}}[{window}])) / (sum(rate(nv_inference_request_success{{selector}}[{window}])) + sum(rate(nv_inference_request_failure{{selector}}[{window}])))`,
+		Latency:   `sum(rate(nv_inference_request_duration_us{{selector}}[{window}])) / sum(rate(nv_inference_request_success{{selector}}[{window}])) / 1e6`,
+	},
+}
+
+var tgiServer = modelServer{
+	Name:        "tgi",
+	Description: "Hugging Face Text Generation Inference",
+	Image:       "ghcr.io/huggingface/text-generation-inference:2.4.0",
+	Ports:       []string{"8080"},
+	Args:        []string{"--model-id", "{model}", "--port", "{port}"},
+	HealthPath:  "/health",
+	ReadyPath:   "/health",
+	Preset:      "gpu-a10-1x",
+	ShmSize:     "1Gi",
+	CacheMount:  "/data",
+	MetricsPath: "/metrics",
+	Metrics: map[string]string{
+		"requests": "tgi_request_count",
+		"latency":  "tgi_request_duration",
+		"queue":    "tgi_queue_size",
+	},
+	ScaleOn:  []string{"tgi-queue"},
+	Protocol: "openai",
+	Canary: &canaryAnalysis{
+		ErrorRate: `sum(rate(tgi_request_failure{{selector}}[{window}])) / sum(rate(tgi_request_count{{selector}}[{window}]))`,
+		Latency:   `sum(rate(tgi_request_duration_sum{{selector}}[{window}])) / sum(rate(tgi_request_duration_count{{selector}}[{window}]))`,
+	},
+}
+
+var torchServeServer = modelServer{
+	Name:        "torchserve",
+	Description: "TorchServe for .mar archives (name=file.mar or a URL)",
+	Image:       "pytorch/torchserve:0.12.0-gpu",
+	Ports:       []string{"8080", "8082"},
+	// The entrypoint would eval the script and lose its quoting, so run it with sh. Prometheus
+	// metrics are off unless set in config.properties.
+	Command: []string{"sh", "-c"},
+	Args: []string{"printf 'inference_address=http://0.0.0.0:{port}\\nmetrics_address=http://0.0.0.0:8082\\nmetrics_mode=prometheus\\n' > /tmp/config.properties && " +
+		"exec torchserve --foreground --disable-token-auth --ts-config /tmp/config.properties --model-store /home/model-server/model-store --models {model} {args}"},
+	HealthPath:  "/ping",
+	ReadyPath:   "/ping",
+	Preset:      "gpu-a10-1x",
+	ShmSize:     "2Gi",
+	CacheMount:  "/home/model-server/model-store",
+	MetricsPort: "8082",
+	MetricsPath: "/metrics",
+	Metrics: map[string]string{
+		"requests": "ts_inference_requests_total",
+		"latency":  "ts_inference_latency_microseconds",
+		"queue":    "ts_queue_latency_microseconds",
+	},
+	ScaleOn:  []string{"torchserve-requests"},
+	Protocol: "raw",
+	// TorchServe counts requests but not failures
+	Canary: &canaryAnalysis{
+		Latency: `sum(rate(ts_inference_latency_microseconds{{selector}}[{window}])) / sum(rate(ts_inference_requests_total{{selector}}[{window}])) / 1e6`,
+	},
+}
+
+var ollamaServer = modelServer{
+	Name:        "ollama",
+	Description: "Ollama, pulling the model on start",
+	Image:       "ollama/ollama:0.4.1",
+	Ports:       []string{"11434"},
+	Command:     []string{"/bin/sh", "-c"},
+	Args:        []string{"ollama serve & until ollama list >/dev/null 2>&1; do sleep 1; done; ollama pull {model} && wait"},
+	Env:         map[string]string{"OLLAMA_HOST": "0.0.0.0:{port}"},
+	HealthPath:  "/",
+	// The API answers before the pull is done
+	ReadyCommand: []string{"ollama", "show", "{model}"},
+	Preset:       "gpu-l4-1x",
+	CacheMount:   "/root/.ollama",
+	ScaleOn:      []string{"gpu-utilization"},
+	Protocol:     "openai",
+	// Ollama exports no metrics
+	Canary: &canaryAnalysis{},
+}
+
+var modelServers = []modelServer{vllmServer, tritonServer, tgiServer, torchServeServer, ollamaServer}
+
+// defaults turns the template into create-deployment defaults for a model. Server arguments
+// follow the template's, or go in place of {args} in a shell script; scripts without it take
+// none.
+func (s modelServer) defaults(name, model string, ports, serverArgs []string) (deploymentDefaults, error) {
+	if len(ports) == 0 {
+		ports = s.Ports
+	}
+	port := ports[0]
+	expand := func(v string) string {
+		return strings.NewReplacer("{model}", model, "{port}", port, "{app}", name).Replace(v)
+	}
+	expandAll := func(values []string) []string {
+		var result []string
+		for _, v := range values {
+			result = append(result, expand(v))
+		}
+		return result
+	}
+
+	d := deploymentDefaults{
+		Image:   s.Image,
+		Ports:   s.Ports,
+		Preset:  s.Preset,
+		Command: s.Command,
+		Args:    expandAll(s.Args),
+		ScaleOn: s.ScaleOn,
+		Canary:  s.Canary,
+	}
+	if s.shellScript() {
+		script := d.Args[len(d.Args)-1]
+		if !strings.Contains(script, "{args}") && len(serverArgs) > 0 {
+			return d, fmt.Errorf("the %s template runs a shell script that takes no --server-arg, override it with -f instead", s.Name)
+		}
+		quoted := make([]string, 0, len(serverArgs))
+		for _, a := range serverArgs {
+			quoted = append(quoted, shellQuote(a))
+		}
+		d.Args[len(d.Args)-1] = strings.TrimSpace(strings.ReplaceAll(script, "{args}", strings.Join(quoted, " ")))
+	} else {
+		d.Args = append(d.Args, serverArgs...)
+	}
+	for _, k := range sortedKeys(s.Env) {
+		d.Env = append(d.Env, corev1.EnvVar{Name: k, Value: expand(s.Env[k])})
+	}
+	if s.ShmSize != "" {
+		d.Volumes = append(d.Volumes, "type=shm,size="+s.ShmSize)
+	}
+
+	portNumber, _ := strconv.Atoi(port)
+	httpGet := func(path string) corev1.ProbeHandler {
+		return corev1.ProbeHandler{HTTPGet: &corev1.HTTPGetAction{Path: path, Port: intstr.FromInt32(int32(portNumber))}}
+	}
+	ready := httpGet(s.ReadyPath)
+	if len(s.ReadyCommand) > 0 {
+		ready = corev1.ProbeHandler{Exec: &corev1.ExecAction{Command: expandAll(s.ReadyCommand)}}
+	}
+	d.LivenessProbe = &corev1.Probe{ProbeHandler: httpGet(s.HealthPath), PeriodSeconds: 10, FailureThreshold: 3}
+	d.ReadinessProbe = &corev1.Probe{ProbeHandler: ready, PeriodSeconds: 5, FailureThreshold: 3}
+	// Loading tens of GBs of weights takes a while; allow up to 30 minutes
+	d.StartupProbe = &corev1.Probe{ProbeHandler: ready, PeriodSeconds: 10, FailureThreshold: 180}
+
+	if s.MetricsPath != "" {
+		metricsPort := s.MetricsPort
+		if metricsPort == "" {
+			metricsPort = port
+		}
+		d.Annotations = map[string]string{
+			"prometheus.io/scrape": "true",
+			"prometheus.io/port":   metricsPort,
+			"prometheus.io/path":   s.MetricsPath,
+		}
+	}
+	return d, nil
+}
+
+// shellScript tells whether the template's last argument is a script run by sh -c
+func (s modelServer) shellScript() bool {
+	return len(s.Command) > 0 && s.Command[len(s.Command)-1] == "-c" && len(s.Args) > 0
+}
+
+// shellQuote quotes an argument for sh unless it is made of safe characters only
+func shellQuote(arg string) string {
+	if arg != "" && strings.Trim(arg, "abcdefghijklmnopqrstuvwxyzABCDEFGHIJKLMNOPQRSTUVWXYZ0123456789-_=.,:/@%+") == "" {
+		return arg
+	}
+	return "'" + strings.ReplaceAll(arg, "'", `'\''`) + "'"
+}
+
+var DeployModelCmd = &cobra.Command{
+	Use:   "deploy-model",
+	Short: "Deploy a model with a built-in model server template",
+	Long: `Deploy a model with a built-in template for vLLM, Triton, TGI, TorchServe or Ollama.
+
+Templates set the image, ports, health probes, resource preset, /dev/shm volume,
+model arguments and the autoscaling profiles the ScaledObject scales on. Every
+create-deployment flag overrides them; the container section of -f overrides any
+other field of the model server container.`,
+}
+
+var DeployModelListCmd = &cobra.Command{
+	Use:   "list",
+	Short: "List the model server templates",
+	Run: func(cmd *cobra.Command, args []string) {
+		tw := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+		fmt.Fprintln(tw, "NAME\tIMAGE\tPORTS\tPRESET\tSCALES ON")
+		for _, s := range modelServers {
+			fmt.Fprintf(tw, "%s\t%s\t%s\t%s\t%s\n", s.Name, s.Image, strings.Join(s.Ports, ","), s.Preset, orDash(strings.Join(s.ScaleOn, ",")))
+		}
+		tw.Flush()
+	},
+}
+
+func newModelServerCmd(s modelServer) *cobra.Command {
+	long := s.Description + "\n\nImage: " + s.Image + "\nPorts: " + strings.Join(s.Ports, ", ") + "\nPreset: " + s.Preset
+	if s.ShmSize != "" {
+		long += "\n/dev/shm: " + s.ShmSize
+	}
+	long += "\nModel cache: " + s.CacheMount
+	if len(s.Metrics) > 0 {
+		long += "\nMetrics:"
+		for _, role := range sortedKeys(s.Metrics) {
+			long += fmt.Sprintf("\n  %-9s %s", role, s.Metrics[role])
+		}
+	} else {
+		long += "\nMetrics: none"
+	}
+	long += "\nScales on: " + strings.Join(s.ScaleOn, ", ") + ", see 'autoscale profiles'"
+	cmd := &cobra.Command{
+		Use:   s.Name,
+		Short: s.Description,
+		Long:  long,
+		Run: func(cmd *cobra.Command, args []string) {
+			name, _ := cmd.Flags().GetString("name")
+			model, _ := cmd.Flags().GetString("model")
+			ports, _ := cmd.Flags().GetStringSlice("ports")
+			serverArgs, _ := cmd.Flags().GetStringArray("server-arg")
+			d, err := s.defaults(name, model, ports, serverArgs)
+			if err != nil {
+				fmt.Println(err)
+				return
+			}
+			env, _ := cmd.Flags().GetStringArray("env")
+			for _, e := range env {
+				k, v, ok := strings.Cut(e, "=")
+				if !ok {
+					fmt.Printf("Invalid --env %q, expected KEY=VALUE\n", e)
+					return
+				}
+				d.Env = withEnv(d.Env, corev1.EnvVar{Name: k, Value: v})
+			}
+			if cache, _ := cmd.Flags().GetString("model-cache"); cache != "" {
+				// Keys given later win, so the cache can switch to type=cache or another mount. The
+				// server downloads into it, so it stays writable unless readOnly=true is given.
+				d.Volumes = append(d.Volumes, "type=pvc,mount="+s.CacheMount+",readOnly=false,"+cache)
+			}
+			runDeployment(cmd, d)
+		},
+	}
+	addDeploymentFlags(cmd)
+	cmd.Flags().String("model", "", "Model to serve, e.g. a Hugging Face id for vllm and tgi")
+	cmd.Flags().StringArray("server-arg", nil, "Extra argument for the model server, appended to its command line (repeatable; ollama takes none)")
+	cmd.Flags().StringArray("env", nil, "Environment variable KEY=VALUE, replacing the template's (repeatable)")
+	cmd.Flags().String("model-cache", "", "PVC for downloaded weights as source=CLAIM[,size=SIZE][,storageClass=CLASS][,type=cache], mounted writable at "+s.CacheMount)
+	cmd.MarkFlagRequired("name")
+	cmd.MarkFlagRequired("model")
+	return cmd
+}
+
+func sortedKeys(m map[string]string) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+func withEnv(env []corev1.EnvVar, e corev1.EnvVar) []corev1.EnvVar {
+	for i := range env {
+		if env[i].Name == e.Name {
+			env[i] = e
+			return env
+		}
+	}
+	return append(env, e)
+}
+
+func init() {
+	DeployModelCmd.AddCommand(DeployModelListCmd)
+	for _, s := range modelServers {
+		DeployModelCmd.AddCommand(newModelServerCmd(s))
+	}
+}
@@ -0,0 +1,223 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/kubernetes"
+)
+
+// Workload kinds pods can be resolved from
+const (
+	KindDeployment  = "Deployment"
+	KindStatefulSet = "StatefulSet"
+	KindDaemonSet   = "DaemonSet"
+)
+
+// A workload is a Deployment, StatefulSet or DaemonSet reduced to what pod-finding commands need
+type workload struct {
+	Kind               string
+	Name               string
+	Namespace          string
+	UID                types.UID
+	Object             metav1.Object
+	Selector           labels.Selector
+	Template           corev1.PodTemplateSpec
+	Desired            int32
+	Ready              int32
+	Updated            int32
+	Available          int32
+	Generation         int64
+	ObservedGeneration int64
+	Conditions         []conditionHealth
+
+	// Deployment pods are owned by ReplicaSets, the others by the workload itself
+	owners       map[types.UID]bool
+	currentOwner types.UID
+	currentHash  string
+}
+
+// normalizeKind accepts the kubectl style names and short forms of the supported kinds
+func normalizeKind(kind string) (string, error) {
+	switch strings.ToLower(kind) {
+	case "", "deployment", "deployments", "deploy":
+		return KindDeployment, nil
+	case "statefulset", "statefulsets", "sts":
+		return KindStatefulSet, nil
+	case "daemonset", "daemonsets", "ds":
+		return KindDaemonSet, nil
+	}
+	return "", fmt.Errorf("unsupported kind %q, expected deployment, statefulset or daemonset", kind)
+}
+
+// getWorkload fetches the workload and works out which pods belong to its current revision
+func getWorkload(clientset *kubernetes.Clientset, namespace, kind, name string) (*workload, error) {
+	kind, err := normalizeKind(kind)
+	if err != nil {
+		return nil, err
+	}
+	var obj interface{}
+	switch kind {
+	case KindDeployment:
+		obj, err = clientset.AppsV1().Deployments(namespace).Get(context.TODO(), name, metav1.GetOptions{})
+	case KindStatefulSet:
+		obj, err = clientset.AppsV1().StatefulSets(namespace).Get(context.TODO(), name, metav1.GetOptions{})
+	case KindDaemonSet:
+		obj, err = clientset.AppsV1().DaemonSets(namespace).Get(context.TODO(), name, metav1.GetOptions{})
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to get %s: %v", strings.ToLower(kind), err)
+	}
+	w, err := workloadFromObject(obj)
+	if err != nil {
+		return nil, err
+	}
+	return w, w.refreshOwners(clientset)
+}
+
+// workloadFromObject converts a typed workload, as returned by the API or a lister
+func workloadFromObject(obj interface{}) (*workload, error) {
+	var w *workload
+	var selector *metav1.LabelSelector
+	switch o := obj.(type) {
+	case *appsv1.Deployment:
+		w = &workload{Kind: KindDeployment, Object: o, Template: o.Spec.Template,
+			Ready: o.Status.ReadyReplicas, Updated: o.Status.UpdatedReplicas, Available: o.Status.AvailableReplicas,
+			ObservedGeneration: o.Status.ObservedGeneration}
+		if o.Spec.Replicas != nil {
+			w.Desired = *o.Spec.Replicas
+		}
+		for _, c := range o.Status.Conditions {
+			w.Conditions = append(w.Conditions, conditionHealth{Type: string(c.Type), Status: string(c.Status), Reason: c.Reason, Message: c.Message})
+		}
+		selector = o.Spec.Selector
+	case *appsv1.StatefulSet:
+		w = &workload{Kind: KindStatefulSet, Object: o, Template: o.Spec.Template,
+			Ready: o.Status.ReadyReplicas, Updated: o.Status.UpdatedReplicas, Available: o.Status.AvailableReplicas,
+			ObservedGeneration: o.Status.ObservedGeneration, currentHash: o.Status.UpdateRevision}
+		if o.Spec.Replicas != nil {
+			w.Desired = *o.Spec.Replicas
+		}
+		for _, c := range o.Status.Conditions {
+			w.Conditions = append(w.Conditions, conditionHealth{Type: string(c.Type), Status: string(c.Status), Reason: c.Reason, Message: c.Message})
+		}
+		selector = o.Spec.Selector
+	case *appsv1.DaemonSet:
+		w = &workload{Kind: KindDaemonSet, Object: o, Template: o.Spec.Template,
+			Desired: o.Status.DesiredNumberScheduled, Ready: o.Status.NumberReady, Updated: o.Status.UpdatedNumberScheduled,
+			Available: o.Status.NumberAvailable, ObservedGeneration: o.Status.ObservedGeneration}
+		for _, c := range o.Status.Conditions {
+			w.Conditions = append(w.Conditions, conditionHealth{Type: string(c.Type), Status: string(c.Status), Reason: c.Reason, Message: c.Message})
+		}
+		selector = o.Spec.Selector
+	default:
+		return nil, fmt.Errorf("unsupported workload type %T", obj)
+	}
+	w.Name = w.Object.GetName()
+	w.Namespace = w.Object.GetNamespace()
+	w.UID = w.Object.GetUID()
+	w.Generation = w.Object.GetGeneration()
+	s, err := metav1.LabelSelectorAsSelector(selector)
+	if err != nil {
+		return nil, fmt.Errorf("invalid selector on %s %s: %v", strings.ToLower(w.Kind), w.Name, err)
+	}
+	w.Selector = s
+	w.owners = map[types.UID]bool{w.UID: true}
+	return w, nil
+}
+
+// refreshOwners looks up the ReplicaSets or ControllerRevisions that identify the current revision
+func (w *workload) refreshOwners(clientset *kubernetes.Clientset) error {
+	switch w.Kind {
+	case KindDeployment:
+		replicaSets, err := clientset.AppsV1().ReplicaSets(w.Namespace).List(context.TODO(), metav1.ListOptions{
+			LabelSelector: w.Selector.String(),
+		})
+		if err != nil {
+			return fmt.Errorf("failed to list replicasets: %v", err)
+		}
+		rs := make([]*appsv1.ReplicaSet, 0, len(replicaSets.Items))
+		for i := range replicaSets.Items {
+			rs = append(rs, &replicaSets.Items[i])
+		}
+		w.setReplicaSets(rs)
+	case KindDaemonSet:
+		revisions, err := clientset.AppsV1().ControllerRevisions(w.Namespace).List(context.TODO(), metav1.ListOptions{
+			LabelSelector: w.Selector.String(),
+		})
+		if err != nil {
+			return fmt.Errorf("failed to list controllerrevisions: %v", err)
+		}
+		var latest int64 = -1
+		for i := range revisions.Items {
+			cr := &revisions.Items[i]
+			if metav1.IsControlledBy(cr, w.Object) && cr.Revision > latest {
+				latest = cr.Revision
+				w.currentHash = strings.TrimPrefix(cr.Name, w.Name+"-")
+			}
+		}
+	}
+	return nil
+}
+
+// setReplicaSets records the ReplicaSets of a deployment and which one is current
+func (w *workload) setReplicaSets(replicaSets []*appsv1.ReplicaSet) {
+	w.owners = map[types.UID]bool{}
+	revision := w.Object.GetAnnotations()["deployment.kubernetes.io/revision"]
+	for _, rs := range replicaSets {
+		if !metav1.IsControlledBy(rs, w.Object) {
+			continue
+		}
+		w.owners[rs.UID] = true
+		if revision != "" && rs.Annotations["deployment.kubernetes.io/revision"] == revision {
+			w.currentOwner = rs.UID
+		}
+	}
+}
+
+// owns reports whether the pod belongs to the workload and whether it runs the current revision
+func (w *workload) owns(pod *corev1.Pod) (owned, current bool) {
+	ref := metav1.GetControllerOf(pod)
+	if ref == nil || !w.owners[ref.UID] {
+		return false, false
+	}
+	switch w.Kind {
+	case KindDeployment:
+		return true, ref.UID == w.currentOwner
+	default:
+		return true, w.currentHash == "" || pod.Labels[appsv1.ControllerRevisionHashLabelKey] == w.currentHash
+	}
+}
+
+// filterPods keeps the pods owned by the workload, only those of the current revision unless allRevisions is set
+func (w *workload) filterPods(pods []corev1.Pod, allRevisions bool) []corev1.Pod {
+	result := []corev1.Pod{}
+	for i := range pods {
+		owned, current := w.owns(&pods[i])
+		if owned && (current || allRevisions) {
+			result = append(result, pods[i])
+		}
+	}
+	return result
+}
+
+// getWorkloadPods resolves the pods of a workload through its own selector and owner references
+func getWorkloadPods(clientset *kubernetes.Clientset, namespace, kind, name string, allRevisions bool) (*workload, []corev1.Pod, error) {
+	w, err := getWorkload(clientset, namespace, kind, name)
+	if err != nil {
+		return nil, nil, err
+	}
+	pods, err := clientset.CoreV1().Pods(namespace).List(context.TODO(), metav1.ListOptions{
+		LabelSelector: w.Selector.String(),
+	})
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to list pods: %v", err)
+	}
+	return w, w.filterPods(pods.Items, allRevisions), nil
+}
@@ -0,0 +1,379 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"os/signal"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/spf13/cobra"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/fields"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/watch"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/rest"
+	"k8s.io/client-go/tools/portforward"
+	"k8s.io/client-go/transport/spdy"
+)
+
+// A forwardTarget is where a port-forward finds its pods: a workload, or the pods behind a service
+type forwardTarget struct {
+	clientset *kubernetes.Clientset
+	namespace string
+	kind      string
+	name      string
+	// Set when forwarding to a service; remote ports are then its ports
+	service *corev1.Service
+}
+
+// A forwardPort is a LOCAL:REMOTE pair, REMOTE being resolved against each pod it connects to
+type forwardPort struct {
+	Local  int
+	Remote string
+}
+
+var PortForwardCmd = &cobra.Command{
+	Use:   "port-forward [LOCAL:]REMOTE...",
+	Short: "Forward local ports to a ready pod of a deployment, reconnecting when the pod goes away",
+	Long: `Forward local ports to a ready pod of a deployment, or of the pods behind --service.
+
+Ports default to those of the service create-deployment made for it, on the same local
+ports. A REMOTE port the service exposes is forwarded to its target port, so 8080:80
+works like kubectl port-forward svc/NAME. A LOCAL port of 0 picks a free one.
+
+When the pod is deleted, stops being ready or the connection drops, as during a rollout,
+the forward moves to another ready pod on the same local ports.`,
+	Run: func(cmd *cobra.Command, args []string) {
+		name, _ := cmd.Flags().GetString("name")
+		namespace, _ := cmd.Flags().GetString("namespace")
+		if namespace == "" {
+			namespace = contextNamespace()
+		}
+		kind, _ := cmd.Flags().GetString("kind")
+		serviceName, _ := cmd.Flags().GetString("service")
+		addresses, _ := cmd.Flags().GetStringSlice("address")
+		if (name == "") == (serviceName == "") {
+			fmt.Println("Exactly one of --name and --service is required")
+			return
+		}
+
+		clientset, err := GetK8sClient()
+		if err != nil {
+			fmt.Println(err)
+			return
+		}
+		config, err := GetRestConfig()
+		if err != nil {
+			fmt.Println(err)
+			return
+		}
+		target := &forwardTarget{clientset: clientset, namespace: namespace, kind: kind, name: name}
+		if name != "" {
+			if _, err := normalizeKind(kind); err != nil {
+				fmt.Println(err)
+				return
+			}
+			serviceName = fmt.Sprintf("%s-service", name)
+			// A blue/green deployment forwards to the color serving traffic
+			if target.name, err = liveWorkload(clientset, namespace, kind, name); err != nil {
+				fmt.Println(err)
+				return
+			}
+		}
+		service, err := clientset.CoreV1().Services(namespace).Get(context.TODO(), serviceName, metav1.GetOptions{})
+		switch {
+		case err == nil:
+			target.service = service
+		case name == "" || len(args) == 0:
+			fmt.Printf("Failed to get service %s: %v\n", serviceName, err)
+			return
+		}
+		if name == "" && len(service.Spec.Selector) == 0 {
+			fmt.Printf("Service %s has no selector\n", serviceName)
+			return
+		}
+
+		ports, err := parseForwardPorts(args, target.service)
+		if err != nil {
+			fmt.Println(err)
+			return
+		}
+
+		ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+		defer stop()
+		if err := target.forward(ctx, config, addresses, ports); err != nil {
+			fmt.Println(err)
+		}
+	},
+}
+
+// parseForwardPorts reads [LOCAL:]REMOTE pairs, defaulting to the service's ports
+func parseForwardPorts(args []string, service *corev1.Service) ([]forwardPort, error) {
+	if len(args) == 0 {
+		if service == nil || len(service.Spec.Ports) == 0 {
+			return nil, fmt.Errorf("no ports given and no service ports to default to")
+		}
+		for _, p := range service.Spec.Ports {
+			if p.Protocol != "" && p.Protocol != corev1.ProtocolTCP {
+				continue
+			}
+			args = append(args, strconv.Itoa(int(p.Port)))
+		}
+	}
+	var ports []forwardPort
+	for _, arg := range args {
+		local, remote, hasLocal := strings.Cut(arg, ":")
+		if !hasLocal {
+			local, remote = arg, arg
+		} else if local == "" {
+			local = "0"
+		}
+		l, err := strconv.Atoi(local)
+		if err != nil || l < 0 || l > 65535 {
+			return nil, fmt.Errorf("invalid local port in %q", arg)
+		}
+		if r, err := strconv.Atoi(remote); err != nil || r < 1 || r > 65535 {
+			return nil, fmt.Errorf("invalid remote port in %q", arg)
+		}
+		ports = append(ports, forwardPort{Local: l, Remote: remote})
+	}
+	return ports, nil
+}
+
+// forward keeps the ports forwarded to a ready pod until ctx is cancelled
+func (t *forwardTarget) forward(ctx context.Context, config *rest.Config, addresses []string, ports []forwardPort) error {
+	for {
+		pod, err := t.waitForReadyPod(ctx)
+		if err != nil {
+			return err
+		}
+		var specs []string
+		for _, p := range ports {
+			specs = append(specs, fmt.Sprintf("%d:%s", p.Local, t.remotePort(pod, p.Remote)))
+		}
+		stopCh := make(chan struct{})
+		fw, errCh, err := forwardPorts(config, t.clientset, t.namespace, pod.Name, addresses, specs, stopCh, os.Stderr)
+		if err != nil {
+			fmt.Println(err)
+			if !sleepContext(ctx, 2*time.Second) {
+				return nil
+			}
+			continue
+		}
+		forwarded, _ := fw.GetPorts()
+		for i, p := range forwarded {
+			// Reconnects listen on the same local ports
+			ports[i].Local = int(p.Local)
+			fmt.Printf("Forwarding %s:%d -> pod %s:%d\n", strings.Join(addresses, ","), p.Local, pod.Name, p.Remote)
+		}
+
+		reason := t.watchPod(ctx, pod, errCh)
+		close(stopCh)
+		if ctx.Err() != nil {
+			return nil
+		}
+		fmt.Printf("Pod %s %s, reconnecting\n", pod.Name, reason)
+	}
+}
+
+// watchPod returns once the pod stops being ready, is deleted or the forward ends
+func (t *forwardTarget) watchPod(ctx context.Context, pod *corev1.Pod, errCh <-chan error) string {
+	watchCtx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	events := make(<-chan watch.Event)
+	watcher, err := t.clientset.CoreV1().Pods(t.namespace).Watch(watchCtx, metav1.ListOptions{
+		FieldSelector:   fields.OneTermEqualSelector("metadata.name", pod.Name).String(),
+		ResourceVersion: pod.ResourceVersion,
+	})
+	if err == nil {
+		defer watcher.Stop()
+		events = watcher.ResultChan()
+	}
+	for {
+		select {
+		case <-ctx.Done():
+			return "stopped"
+		case err := <-errCh:
+			if err != nil {
+				return fmt.Sprintf("lost the connection (%v)", err)
+			}
+			return "lost the connection"
+		case event, ok := <-events:
+			if !ok {
+				// Watches time out; the forward keeps running without one
+				events = nil
+				continue
+			}
+			switch event.Type {
+			case watch.Deleted:
+				return "was deleted"
+			case watch.Modified:
+				if p, ok := event.Object.(*corev1.Pod); ok && !isPodReady(p) {
+					return "is no longer ready"
+				}
+			}
+		}
+	}
+}
+
+// waitForReadyPod polls until a pod is ready, telling the user once why it waits
+func (t *forwardTarget) waitForReadyPod(ctx context.Context) (*corev1.Pod, error) {
+	waiting := false
+	for {
+		pod, err := t.readyPod()
+		if err == nil {
+			return pod, nil
+		}
+		if !waiting {
+			fmt.Printf("%v, waiting\n", err)
+			waiting = true
+		}
+		if !sleepContext(ctx, 2*time.Second) {
+			return nil, ctx.Err()
+		}
+	}
+}
+
+// readyPod prefers pods of the workload's current revision, then the old ones a rollout keeps
+func (t *forwardTarget) readyPod() (*corev1.Pod, error) {
+	if t.name == "" {
+		pods, err := t.clientset.CoreV1().Pods(t.namespace).List(context.TODO(), metav1.ListOptions{
+			LabelSelector: labels.SelectorFromSet(t.service.Spec.Selector).String(),
+		})
+		if err != nil {
+			return nil, fmt.Errorf("failed to list pods: %v", err)
+		}
+		for i := range pods.Items {
+			if isPodReady(&pods.Items[i]) {
+				return &pods.Items[i], nil
+			}
+		}
+		return nil, fmt.Errorf("service %s has no ready pods", t.service.Name)
+	}
+	w, pods, err := getWorkloadPods(t.clientset, t.namespace, t.kind, t.name, true)
+	if err != nil {
+		return nil, err
+	}
+	var old *corev1.Pod
+	for i := range pods {
+		if !isPodReady(&pods[i]) {
+			continue
+		}
+		if _, current := w.owns(&pods[i]); current {
+			return &pods[i], nil
+		}
+		if old == nil {
+			old = &pods[i]
+		}
+	}
+	if old != nil {
+		return old, nil
+	}
+	return nil, fmt.Errorf("%s %s has no ready pods", strings.ToLower(w.Kind), t.name)
+}
+
+// remotePort translates a service port to the pod port it targets
+func (t *forwardTarget) remotePort(pod *corev1.Pod, remote string) string {
+	if t.service == nil {
+		return remote
+	}
+	for _, p := range t.service.Spec.Ports {
+		if strconv.Itoa(int(p.Port)) == remote {
+			return servicePortTarget(p, pod)
+		}
+	}
+	return remote
+}
+
+// servicePortTarget is the pod port a service port sends to
+func servicePortTarget(p corev1.ServicePort, pod *corev1.Pod) string {
+	switch {
+	case p.TargetPort.StrVal != "":
+		return namedContainerPort(pod, p.TargetPort.StrVal)
+	case p.TargetPort.IntVal != 0:
+		return strconv.Itoa(int(p.TargetPort.IntVal))
+	}
+	return strconv.Itoa(int(p.Port))
+}
+
+func namedContainerPort(pod *corev1.Pod, name string) string {
+	for _, c := range pod.Spec.Containers {
+		for _, p := range c.Ports {
+			if p.Name == name {
+				return strconv.Itoa(int(p.ContainerPort))
+			}
+		}
+	}
+	return name
+}
+
+// forwardPorts forwards LOCAL:REMOTE ports on the addresses to a pod over SPDY, a local port of
+// 0 picking a free one. It returns once the ports listen; the forward runs until stopCh closes or
+// the connection drops, which is reported on the returned channel.
+func forwardPorts(config *rest.Config, clientset *kubernetes.Clientset, namespace, pod string, addresses, ports []string, stopCh <-chan struct{}, errOut io.Writer) (*portforward.PortForwarder, <-chan error, error) {
+	transport, upgrader, err := spdy.RoundTripperFor(config)
+	if err != nil {
+		return nil, nil, err
+	}
+	url := clientset.CoreV1().RESTClient().Post().
+		Resource("pods").
+		Namespace(namespace).
+		Name(pod).
+		SubResource("portforward").
+		URL()
+	dialer := spdy.NewDialer(upgrader, &http.Client{Transport: transport}, http.MethodPost, url)
+
+	readyCh := make(chan struct{})
+	fw, err := portforward.NewOnAddresses(dialer, addresses, ports, stopCh, readyCh, io.Discard, errOut)
+	if err != nil {
+		return nil, nil, err
+	}
+	errCh := make(chan error, 1)
+	go func() {
+		errCh <- fw.ForwardPorts()
+	}()
+	select {
+	case <-readyCh:
+		return fw, errCh, nil
+	case err := <-errCh:
+		return nil, nil, fmt.Errorf("failed to forward to pod %s: %v", pod, err)
+	}
+}
+
+func isPodReady(pod *corev1.Pod) bool {
+	if pod.DeletionTimestamp != nil {
+		return false
+	}
+	for _, c := range pod.Status.Conditions {
+		if c.Type == corev1.PodReady {
+			return c.Status == corev1.ConditionTrue
+		}
+	}
+	return false
+}
+
+// sleepContext waits for d, returning false when ctx is cancelled first
+func sleepContext(ctx context.Context, d time.Duration) bool {
+	select {
+	case <-ctx.Done():
+		return false
+	case <-time.After(d):
+		return true
+	}
+}
+
+func init() {
+	PortForwardCmd.Flags().String("name", "", "Name of the deployment")
+	PortForwardCmd.Flags().String("namespace", "", "Namespace of the deployment (defaults to the context's namespace)")
+	PortForwardCmd.Flags().String("kind", "deployment", "Workload kind: deployment, statefulset or daemonset")
+	PortForwardCmd.Flags().String("service", "", "Forward to the pods behind this service instead of a workload")
+	PortForwardCmd.Flags().StringSlice("address", []string{"localhost"}, "Local addresses to listen on")
+}
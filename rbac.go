@@ -0,0 +1,453 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+
+	authenticationv1 "k8s.io/api/authentication/v1"
+	authorizationv1 "k8s.io/api/authorization/v1"
+	rbacv1 "k8s.io/api/rbac/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+	"sigs.k8s.io/yaml"
+)
+
+// A permission is one verb on one resource; an empty namespace means cluster scope
+type permission struct {
+	Group     string `json:"group"`
+	Resource  string `json:"resource"`
+	Verb      string `json:"verb"`
+	Namespace string `json:"namespace,omitempty"`
+}
+
+func (p permission) String() string {
+	resource := p.Resource
+	if p.Group != "" {
+		resource += "." + p.Group
+	}
+	if p.Namespace == "" {
+		return fmt.Sprintf("%s %s (cluster)", p.Verb, resource)
+	}
+	return fmt.Sprintf("%s %s in %s", p.Verb, resource, p.Namespace)
+}
+
+func permissions(group, resource, namespace string, verbs ...string) []permission {
+	result := make([]permission, 0, len(verbs))
+	for _, verb := range verbs {
+		result = append(result, permission{Group: group, Resource: resource, Verb: verb, Namespace: namespace})
+	}
+	return result
+}
+
+// Permissions each command needs, checked by the preflight and by 'doctor --rbac'
+var commandPermissions = []struct {
+	command     string
+	permissions func(namespace string) []permission
+}{
+	{"create-deployment", createDeploymentPermissions},
+	{"delete-deployment", deleteDeploymentPermissions},
+	{"addons keda install", installKEDAPermissions},
+	{"addons keda uninstall", uninstallKEDAPermissions},
+	{"bootstrap", bootstrapPermissions},
+	{"health-status", healthStatusPermissions},
+	{"logs", logsPermissions},
+	{"autoscale explain", autoscaleExplainPermissions},
+	{"test-endpoint", testEndpointPermissions},
+	{"port-forward", portForwardPermissions},
+	{"exec", execPermissions},
+	{"debug", debugPermissions},
+	{"scale", scalePermissions},
+	{"restart", restartPermissions},
+	{"canary", canaryPermissions},
+	{"switch", switchPermissions},
+}
+
+func createDeploymentPermissions(namespace string) []permission {
+	var p []permission
+	p = append(p, permissions("apps", "deployments", namespace, "get", "create", "update")...)
+	p = append(p, permissions("", "services", namespace, "get", "create", "update")...)
+	p = append(p, permissions("keda.sh", "scaledobjects", namespace, "get", "create", "patch")...)
+	// The quota preflight reads the limits and quotas and the pods already counted in them
+	p = append(p, permissions("", "limitranges", namespace, "list")...)
+	p = append(p, permissions("", "resourcequotas", namespace, "list")...)
+	p = append(p, permissions("", "pods", namespace, "list")...)
+	p = append(p, permissions("apps", "replicasets", namespace, "list")...)
+	return p
+}
+
+func deleteDeploymentPermissions(namespace string) []permission {
+	var p []permission
+	p = append(p, permissions("apps", "deployments", namespace, "delete")...)
+	p = append(p, permissions("", "services", namespace, "delete")...)
+	p = append(p, permissions("keda.sh", "scaledobjects", namespace, "delete")...)
+	p = append(p, permissions("", "persistentvolumeclaims", namespace, "list")...)
+	return p
+}
+
+func installKEDAPermissions(string) []permission {
+	var p []permission
+	p = append(p, permissions("", "namespaces", "", "create")...)
+	p = append(p, permissions("apiextensions.k8s.io", "customresourcedefinitions", "", "get", "create")...)
+	p = append(p, permissions("rbac.authorization.k8s.io", "clusterroles", "", "create")...)
+	p = append(p, permissions("rbac.authorization.k8s.io", "clusterrolebindings", "", "create")...)
+	p = append(p, permissions("admissionregistration.k8s.io", "validatingwebhookconfigurations", "", "create")...)
+	p = append(p, permissions("apiregistration.k8s.io", "apiservices", "", "create")...)
+	p = append(p, permissions("apps", "deployments", "keda", "get", "create")...)
+	p = append(p, permissions("", "pods", "keda", "list")...)
+	return p
+}
+
+func uninstallKEDAPermissions(string) []permission {
+	var p []permission
+	p = append(p, permissions("apiextensions.k8s.io", "customresourcedefinitions", "", "get", "delete")...)
+	p = append(p, permissions("rbac.authorization.k8s.io", "clusterroles", "", "delete")...)
+	p = append(p, permissions("rbac.authorization.k8s.io", "clusterrolebindings", "", "delete")...)
+	p = append(p, permissions("admissionregistration.k8s.io", "validatingwebhookconfigurations", "", "delete")...)
+	p = append(p, permissions("apiregistration.k8s.io", "apiservices", "", "delete")...)
+	p = append(p, permissions("apps", "deployments", "keda", "delete")...)
+	p = append(p, permissions("", "secrets", "keda", "list", "delete")...)
+	return p
+}
+
+// installAddonPermissions covers what a typical chart creates, and the secrets Helm keeps its
+// releases in
+func installAddonPermissions(namespace string) []permission {
+	var p []permission
+	p = append(p, permissions("", "namespaces", "", "create")...)
+	p = append(p, permissions("apiextensions.k8s.io", "customresourcedefinitions", "", "get", "create")...)
+	p = append(p, permissions("rbac.authorization.k8s.io", "clusterroles", "", "create")...)
+	p = append(p, permissions("rbac.authorization.k8s.io", "clusterrolebindings", "", "create")...)
+	p = append(p, permissions("apps", "deployments", namespace, "get", "create")...)
+	p = append(p, permissions("", "services", namespace, "create")...)
+	p = append(p, permissions("", "secrets", namespace, "list", "create")...)
+	return p
+}
+
+func uninstallAddonPermissions(namespace string) []permission {
+	var p []permission
+	p = append(p, permissions("apiextensions.k8s.io", "customresourcedefinitions", "", "get", "delete")...)
+	p = append(p, permissions("rbac.authorization.k8s.io", "clusterroles", "", "delete")...)
+	p = append(p, permissions("rbac.authorization.k8s.io", "clusterrolebindings", "", "delete")...)
+	p = append(p, permissions("apps", "deployments", namespace, "delete")...)
+	p = append(p, permissions("", "services", namespace, "delete")...)
+	p = append(p, permissions("", "secrets", namespace, "list", "delete")...)
+	return p
+}
+
+// addonsPermissions is the union of the install permissions of the addons
+func addonsPermissions(addons []helmAddon) []permission {
+	var p []permission
+	for _, addon := range addons {
+		p = append(p, addon.InstallPermissions(addon.Namespace)...)
+	}
+	return dedupePermissions(p)
+}
+
+func bootstrapPermissions(string) []permission {
+	addons, _ := resolveAddons(bootstrapAddons)
+	return addonsPermissions(addons)
+}
+
+// workloadPermissions covers resolving the pods of any --kind: the workload, and the ReplicaSets
+// or ControllerRevisions telling its current revision
+func workloadPermissions(namespace string, verbs ...string) []permission {
+	var p []permission
+	for _, resource := range []string{"deployments", "statefulsets", "daemonsets"} {
+		p = append(p, permissions("apps", resource, namespace, verbs...)...)
+	}
+	p = append(p, permissions("apps", "replicasets", namespace, "list")...)
+	p = append(p, permissions("apps", "controllerrevisions", namespace, "list")...)
+	// The service tells which color of a blue/green deployment is live
+	p = append(p, permissions("", "services", namespace, "get")...)
+	return p
+}
+
+func healthStatusPermissions(namespace string) []permission {
+	p := workloadPermissions(namespace, "get", "list", "watch")
+	// --watch keeps informers on the ReplicaSets and autoscalers
+	p = append(p, permissions("apps", "replicasets", namespace, "watch")...)
+	p = append(p, permissions("autoscaling", "horizontalpodautoscalers", namespace, "list", "watch")...)
+	p = append(p, permissions("", "pods", namespace, "list", "watch")...)
+	p = append(p, permissions("", "events", namespace, "list")...)
+	p = append(p, permissions("metrics.k8s.io", "pods", namespace, "get", "list")...)
+	return p
+}
+
+func logsPermissions(namespace string) []permission {
+	p := workloadPermissions(namespace, "get")
+	p = append(p, permissions("", "pods", namespace, "list", "watch")...)
+	p = append(p, permissions("", "pods/log", namespace, "get")...)
+	return p
+}
+
+// autoscaleExplainPermissions leaves out services/proxy, which it needs in Prometheus's namespace
+func autoscaleExplainPermissions(namespace string) []permission {
+	var p []permission
+	p = append(p, permissions("keda.sh", "scaledobjects", namespace, "get")...)
+	p = append(p, permissions("apps", "deployments", namespace, "get")...)
+	p = append(p, permissions("", "services", namespace, "get")...)
+	return p
+}
+
+func testEndpointPermissions(namespace string) []permission {
+	p := workloadPermissions(namespace, "get")
+	p = append(p, permissions("", "pods", namespace, "list")...)
+	p = append(p, permissions("", "pods/portforward", namespace, "create")...)
+	return p
+}
+
+func portForwardPermissions(namespace string) []permission {
+	p := workloadPermissions(namespace, "get")
+	p = append(p, permissions("", "services", namespace, "get")...)
+	p = append(p, permissions("", "pods", namespace, "list", "watch")...)
+	p = append(p, permissions("", "pods/portforward", namespace, "create")...)
+	return p
+}
+
+func execPermissions(namespace string) []permission {
+	p := workloadPermissions(namespace, "get")
+	p = append(p, permissions("", "pods", namespace, "list")...)
+	p = append(p, permissions("", "pods/exec", namespace, "create")...)
+	return p
+}
+
+func debugPermissions(namespace string) []permission {
+	p := workloadPermissions(namespace, "get")
+	p = append(p, permissions("", "pods", namespace, "list", "watch")...)
+	p = append(p, permissions("", "pods/ephemeralcontainers", namespace, "update")...)
+	p = append(p, permissions("", "pods/attach", namespace, "create")...)
+	p = append(p, permissions("", "pods/log", namespace, "get")...)
+	return p
+}
+
+func scalePermissions(namespace string) []permission {
+	var p []permission
+	p = append(p, permissions("apps", "deployments/scale", namespace, "get", "update")...)
+	p = append(p, permissions("keda.sh", "scaledobjects", namespace, "get", "patch")...)
+	p = append(p, permissions("", "services", namespace, "get")...)
+	return p
+}
+
+func restartPermissions(namespace string) []permission {
+	var p []permission
+	p = append(p, permissions("apps", "deployments", namespace, "get", "patch")...)
+	p = append(p, permissions("", "services", namespace, "get")...)
+	return p
+}
+
+// blueGreenPermissions are what create-deployment --strategy=blue-green needs on top of the
+// rolling update, port-forwarding only with --verify
+func blueGreenPermissions(namespace string, verify bool) []permission {
+	p := switchPermissions(namespace)
+	if verify {
+		p = append(p, portForwardPermissions(namespace)...)
+	}
+	return p
+}
+
+// switchPermissions patch deployments to record when the previous color is scaled to zero, and
+// create-deployment to label the pods blue
+func switchPermissions(namespace string) []permission {
+	var p []permission
+	p = append(p, permissions("apps", "deployments", namespace, "get", "patch")...)
+	p = append(p, permissions("apps", "deployments/scale", namespace, "get", "update")...)
+	p = append(p, permissions("", "services", namespace, "get", "update")...)
+	p = append(p, permissions("keda.sh", "scaledobjects", namespace, "get", "patch")...)
+	return p
+}
+
+// canaryPermissions leaves out services/proxy, which it needs in Prometheus's namespace
+func canaryPermissions(namespace string) []permission {
+	var p []permission
+	p = append(p, permissions("apps", "deployments", namespace, "get", "create", "update", "delete")...)
+	p = append(p, permissions("apps", "deployments/scale", namespace, "get", "update")...)
+	p = append(p, permissions("", "services", namespace, "get", "create", "delete")...)
+	p = append(p, permissions("gateway.networking.k8s.io", "httproutes", namespace, "list", "update")...)
+	return p
+}
+
+// missingPermissions returns the permissions the current user lacks. Namespaced permissions are
+// answered from one SelfSubjectRulesReview per namespace when the review is complete, everything
+// else with a SelfSubjectAccessReview each.
+func missingPermissions(clientset *kubernetes.Clientset, perms []permission) ([]permission, error) {
+	rules := map[string][]authorizationv1.ResourceRule{}
+	var missing []permission
+	for _, p := range perms {
+		if p.Namespace != "" {
+			if _, ok := rules[p.Namespace]; !ok {
+				review, err := clientset.AuthorizationV1().SelfSubjectRulesReviews().Create(context.TODO(), &authorizationv1.SelfSubjectRulesReview{
+					Spec: authorizationv1.SelfSubjectRulesReviewSpec{Namespace: p.Namespace},
+				}, metav1.CreateOptions{})
+				if err == nil && !review.Status.Incomplete {
+					rules[p.Namespace] = review.Status.ResourceRules
+				} else {
+					rules[p.Namespace] = nil
+				}
+			}
+			if r := rules[p.Namespace]; r != nil {
+				if !rulesAllow(r, p) {
+					missing = append(missing, p)
+				}
+				continue
+			}
+		}
+
+		resource, subresource, _ := strings.Cut(p.Resource, "/")
+		review, err := clientset.AuthorizationV1().SelfSubjectAccessReviews().Create(context.TODO(), &authorizationv1.SelfSubjectAccessReview{
+			Spec: authorizationv1.SelfSubjectAccessReviewSpec{
+				ResourceAttributes: &authorizationv1.ResourceAttributes{
+					Namespace:   p.Namespace,
+					Verb:        p.Verb,
+					Group:       p.Group,
+					Resource:    resource,
+					Subresource: subresource,
+				},
+			},
+		}, metav1.CreateOptions{})
+		if err != nil {
+			return nil, fmt.Errorf("failed to review access for %s: %v", p, err)
+		}
+		if !review.Status.Allowed {
+			missing = append(missing, p)
+		}
+	}
+	return missing, nil
+}
+
+func rulesAllow(rules []authorizationv1.ResourceRule, p permission) bool {
+	for _, rule := range rules {
+		if matchesAny(rule.Verbs, p.Verb) && matchesAny(rule.APIGroups, p.Group) && matchesAny(rule.Resources, p.Resource) && len(rule.ResourceNames) == 0 {
+			return true
+		}
+	}
+	return false
+}
+
+func matchesAny(values []string, want string) bool {
+	for _, v := range values {
+		if v == want || v == "*" {
+			return true
+		}
+		if prefix, ok := strings.CutSuffix(v, "/*"); ok && strings.HasPrefix(want, prefix+"/") {
+			return true
+		}
+	}
+	return false
+}
+
+// preflightRBAC stops a mutating command before it touches anything the user may not change
+func preflightRBAC(clientset *kubernetes.Clientset, command string, perms []permission) error {
+	missing, err := missingPermissions(clientset, perms)
+	if err != nil {
+		return err
+	}
+	if len(missing) == 0 {
+		return nil
+	}
+	fmt.Printf("Missing permissions for %s:\n", command)
+	for _, p := range missing {
+		fmt.Printf("\t%s\n", p)
+	}
+	fmt.Println("\nAsk a cluster admin to apply:")
+	fmt.Println(generateRBACYAML(clientset, command, missing))
+	return fmt.Errorf("permission preflight failed for %s", command)
+}
+
+// rbacName is the name of the Role and binding granting the command, a valid DNS-1123 name
+// whatever the command looks like
+func rbacName(command string) string {
+	var b strings.Builder
+	for _, r := range strings.ToLower(command) {
+		if (r >= 'a' && r <= 'z') || (r >= '0' && r <= '9') {
+			b.WriteRune(r)
+		} else if s := b.String(); s != "" && !strings.HasSuffix(s, "-") {
+			b.WriteByte('-')
+		}
+	}
+	name := "simplismart-cli"
+	slug := strings.Trim(b.String(), "-")
+	if slug == name || strings.HasPrefix(slug, name+"-") {
+		slug = strings.TrimPrefix(slug[len(name):], "-")
+	}
+	if slug != "" {
+		name += "-" + slug
+	}
+	if len(name) > 253 {
+		name = strings.TrimRight(name[:253], "-")
+	}
+	return name
+}
+
+// generateRBACYAML renders a Role and RoleBinding per namespace, and a ClusterRole for cluster scope
+func generateRBACYAML(clientset *kubernetes.Clientset, command string, missing []permission) string {
+	subject := rbacv1.Subject{Kind: rbacv1.UserKind, APIGroup: rbacv1.GroupName, Name: "<your-user>"}
+	review, err := clientset.AuthenticationV1().SelfSubjectReviews().Create(context.TODO(), &authenticationv1.SelfSubjectReview{}, metav1.CreateOptions{})
+	if err == nil && review.Status.UserInfo.Username != "" {
+		username := review.Status.UserInfo.Username
+		subject.Name = username
+		if parts := strings.Split(username, ":"); len(parts) == 4 && parts[0] == "system" && parts[1] == "serviceaccount" {
+			subject = rbacv1.Subject{Kind: rbacv1.ServiceAccountKind, Namespace: parts[2], Name: parts[3]}
+		}
+	}
+
+	byNamespace := map[string][]permission{}
+	for _, p := range missing {
+		byNamespace[p.Namespace] = append(byNamespace[p.Namespace], p)
+	}
+	namespaces := make([]string, 0, len(byNamespace))
+	for ns := range byNamespace {
+		namespaces = append(namespaces, ns)
+	}
+	sort.Strings(namespaces)
+
+	name := rbacName(command)
+	var docs []string
+	for _, ns := range namespaces {
+		rules := policyRules(byNamespace[ns])
+		var objects []interface{}
+		if ns == "" {
+			objects = []interface{}{
+				rbacv1.ClusterRole{TypeMeta: metav1.TypeMeta{APIVersion: "rbac.authorization.k8s.io/v1", Kind: "ClusterRole"},
+					ObjectMeta: metav1.ObjectMeta{Name: name}, Rules: rules},
+				rbacv1.ClusterRoleBinding{TypeMeta: metav1.TypeMeta{APIVersion: "rbac.authorization.k8s.io/v1", Kind: "ClusterRoleBinding"},
+					ObjectMeta: metav1.ObjectMeta{Name: name}, Subjects: []rbacv1.Subject{subject},
+					RoleRef: rbacv1.RoleRef{APIGroup: rbacv1.GroupName, Kind: "ClusterRole", Name: name}},
+			}
+		} else {
+			objects = []interface{}{
+				rbacv1.Role{TypeMeta: metav1.TypeMeta{APIVersion: "rbac.authorization.k8s.io/v1", Kind: "Role"},
+					ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: ns}, Rules: rules},
+				rbacv1.RoleBinding{TypeMeta: metav1.TypeMeta{APIVersion: "rbac.authorization.k8s.io/v1", Kind: "RoleBinding"},
+					ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: ns}, Subjects: []rbacv1.Subject{subject},
+					RoleRef: rbacv1.RoleRef{APIGroup: rbacv1.GroupName, Kind: "Role", Name: name}},
+			}
+		}
+		for _, obj := range objects {
+			data, _ := yaml.Marshal(obj)
+			// Drop the empty creationTimestamp yaml emits for ObjectMeta
+			docs = append(docs, strings.Replace(string(data), "  creationTimestamp: null\n", "", 1))
+		}
+	}
+	return strings.Join(docs, "---\n")
+}
+
+// policyRules groups verbs by API group and resource
+func policyRules(perms []permission) []rbacv1.PolicyRule {
+	type key struct{ group, resource string }
+	verbs := map[key][]string{}
+	var order []key
+	for _, p := range perms {
+		k := key{p.Group, p.Resource}
+		if _, ok := verbs[k]; !ok {
+			order = append(order, k)
+		}
+		verbs[k] = append(verbs[k], p.Verb)
+	}
+	rules := make([]rbacv1.PolicyRule, 0, len(order))
+	for _, k := range order {
+		rules = append(rules, rbacv1.PolicyRule{APIGroups: []string{k.group}, Resources: []string{k.resource}, Verbs: verbs[k]})
+	}
+	return rules
+}
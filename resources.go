@@ -0,0 +1,455 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/spf13/cobra"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+)
+
+// Extended resource advertised by the NVIDIA device plugin
+const gpuResource corev1.ResourceName = "nvidia.com/gpu"
+
+// A resourcePreset names the resources of a deployment. Empty quantities are left unset.
+type resourcePreset struct {
+	CPURequest    string            `json:"cpuRequest,omitempty"`
+	CPULimit      string            `json:"cpuLimit,omitempty"`
+	MemoryRequest string            `json:"memoryRequest,omitempty"`
+	MemoryLimit   string            `json:"memoryLimit,omitempty"`
+	GPU           string            `json:"gpu,omitempty"`
+	NodeSelector  map[string]string `json:"nodeSelector,omitempty"`
+}
+
+// Presets shipped with the CLI; presets of the same name in the config file replace them
+var builtinPresets = map[string]resourcePreset{
+	"cpu-small":  {CPURequest: "250m", CPULimit: "1", MemoryRequest: "512Mi", MemoryLimit: "1Gi"},
+	"cpu-medium": {CPURequest: "1", CPULimit: "2", MemoryRequest: "2Gi", MemoryLimit: "4Gi"},
+	"cpu-large":  {CPURequest: "4", CPULimit: "8", MemoryRequest: "8Gi", MemoryLimit: "16Gi"},
+	"gpu-a10-1x": {CPURequest: "4", CPULimit: "8", MemoryRequest: "16Gi", MemoryLimit: "32Gi", GPU: "1",
+		NodeSelector: map[string]string{"nvidia.com/gpu.product": "NVIDIA-A10G"}},
+	"gpu-l4-1x": {CPURequest: "4", CPULimit: "8", MemoryRequest: "16Gi", MemoryLimit: "32Gi", GPU: "1",
+		NodeSelector: map[string]string{"nvidia.com/gpu.product": "NVIDIA-L4"}},
+}
+
+// resourcePresets merges the built-in presets with those of the config file
+func resourcePresets() map[string]resourcePreset {
+	presets := map[string]resourcePreset{}
+	for name, p := range builtinPresets {
+		presets[name] = p
+	}
+	for name, p := range loadedConfig().Presets {
+		presets[name] = p
+	}
+	return presets
+}
+
+func validatePreset(v string) error {
+	if _, ok := resourcePresets()[v]; !ok {
+		return fmt.Errorf("unknown preset, run 'simplismart-cli config preset list'")
+	}
+	return nil
+}
+
+// resolveResources picks the resources of create-deployment: flags override the preset, or the
+// resources.* settings when there is no preset. A template's preset wins over the configured one.
+func resolveResources(cmd *cobra.Command, templatePreset string) (resourcePreset, error) {
+	var r resourcePreset
+	name := flagOrConfig(cmd, "preset", "resources.preset")
+	if !cmd.Flags().Changed("preset") && templatePreset != "" {
+		name = templatePreset
+	}
+	if name != "" {
+		p, ok := resourcePresets()[name]
+		if !ok {
+			return r, fmt.Errorf("unknown preset %q, run 'simplismart-cli config preset list'", name)
+		}
+		r = p
+	}
+	fields := []struct {
+		flag, key string
+		value     *string
+	}{
+		{"cpu-request", "resources.cpuRequest", &r.CPURequest},
+		{"cpu-limit", "resources.cpuLimit", &r.CPULimit},
+		{"ram-request", "resources.memoryRequest", &r.MemoryRequest},
+		{"ram-limit", "resources.memoryLimit", &r.MemoryLimit},
+		{"gpus", "resources.gpu", &r.GPU},
+	}
+	for _, f := range fields {
+		if cmd.Flags().Changed(f.flag) || name == "" {
+			*f.value = flagOrConfig(cmd, f.flag, f.key)
+		}
+	}
+	return r, nil
+}
+
+// requirements parses the preset and checks no request is above its limit
+func (r resourcePreset) requirements() (corev1.ResourceRequirements, error) {
+	requirements := corev1.ResourceRequirements{Requests: corev1.ResourceList{}, Limits: corev1.ResourceList{}}
+	quantities := []struct {
+		what, value string
+		list        corev1.ResourceList
+		name        corev1.ResourceName
+	}{
+		{"CPU request", r.CPURequest, requirements.Requests, corev1.ResourceCPU},
+		{"CPU limit", r.CPULimit, requirements.Limits, corev1.ResourceCPU},
+		{"memory request", r.MemoryRequest, requirements.Requests, corev1.ResourceMemory},
+		{"memory limit", r.MemoryLimit, requirements.Limits, corev1.ResourceMemory},
+	}
+	for _, q := range quantities {
+		if q.value == "" {
+			continue
+		}
+		quantity, err := resource.ParseQuantity(q.value)
+		if err != nil {
+			return requirements, fmt.Errorf("invalid %s %q: %v", q.what, q.value, err)
+		}
+		if quantity.Sign() < 0 {
+			return requirements, fmt.Errorf("invalid %s %q: must not be negative", q.what, q.value)
+		}
+		q.list[q.name] = quantity
+	}
+	for _, name := range []corev1.ResourceName{corev1.ResourceCPU, corev1.ResourceMemory} {
+		request, hasRequest := requirements.Requests[name]
+		limit, hasLimit := requirements.Limits[name]
+		if hasRequest && hasLimit && request.Cmp(limit) > 0 {
+			return requirements, fmt.Errorf("%s request %s is above the limit %s", name, request.String(), limit.String())
+		}
+	}
+	if r.GPU != "" {
+		gpus, err := strconv.Atoi(r.GPU)
+		if err != nil || gpus < 0 {
+			return requirements, fmt.Errorf("invalid GPU count %q: expected a non-negative integer", r.GPU)
+		}
+		// Extended resources cannot be overcommitted, the limit doubles as the request
+		if gpus > 0 {
+			requirements.Limits[gpuResource] = *resource.NewQuantity(int64(gpus), resource.DecimalSI)
+		}
+	}
+	return requirements, nil
+}
+
+// preflightQuota checks the pods of a deployment pass the LimitRanges of the namespace and
+// that its ResourceQuotas leave room for them up to the maximum replicas
+func preflightQuota(clientset *kubernetes.Clientset, namespace, name string, containers, initContainers []corev1.Container, policy autoscalingPolicy) []checkResult {
+	var results []checkResult
+	limitRanges, err := clientset.CoreV1().LimitRanges(namespace).List(context.TODO(), metav1.ListOptions{})
+	if err != nil {
+		results = append(results, checkResult{Name: "limit-ranges", Status: CheckWarn, Message: fmt.Sprintf("cannot list LimitRanges: %v", err)})
+	} else {
+		for _, lr := range limitRanges.Items {
+			results = append(results, checkLimitRange(&lr, containers, initContainers))
+		}
+	}
+
+	quotas, err := clientset.CoreV1().ResourceQuotas(namespace).List(context.TODO(), metav1.ListOptions{})
+	if err != nil {
+		return append(results, checkResult{Name: "resource-quotas", Status: CheckWarn, Message: fmt.Sprintf("cannot list ResourceQuotas: %v", err)})
+	}
+	if len(quotas.Items) == 0 {
+		return results
+	}
+	// Pods of an earlier rollout are already counted in the quota usage; the deployment's own
+	// selector finds them, and a new deployment has none
+	var current [][]corev1.Container
+	if _, pods, err := getWorkloadPods(clientset, namespace, KindDeployment, name, true); err == nil {
+		for _, pod := range pods {
+			if pod.Status.Phase != corev1.PodSucceeded && pod.Status.Phase != corev1.PodFailed {
+				current = append(current, []corev1.Container{{Resources: podRequests(pod.Spec.Containers, pod.Spec.InitContainers)}})
+			}
+		}
+	}
+	pod := []corev1.Container{{Resources: podRequests(containers, initContainers)}}
+	for _, quota := range quotas.Items {
+		results = append(results, checkQuota(&quota, pod, current, policy))
+	}
+	return results
+}
+
+func checkLimitRange(lr *corev1.LimitRange, containers, initContainers []corev1.Container) checkResult {
+	result := checkResult{Name: "limit-range/" + lr.Name, Status: CheckPass, Message: "pods are within its limits"}
+	var problems []string
+	for _, item := range lr.Spec.Limits {
+		switch item.Type {
+		case corev1.LimitTypeContainer:
+			for _, c := range append(append([]corev1.Container{}, containers...), initContainers...) {
+				for _, problem := range limitRangeProblems(item, c.Resources) {
+					problems = append(problems, fmt.Sprintf("container %s: %s", c.Name, problem))
+				}
+			}
+		case corev1.LimitTypePod:
+			problems = append(problems, limitRangeProblems(item, podRequests(containers, initContainers))...)
+		}
+	}
+	if len(problems) > 0 {
+		sort.Strings(problems)
+		result.Status = CheckFail
+		result.Message = strings.Join(problems, "; ")
+		result.Hint = "Pods would be rejected at admission, change the requests and limits or pick another preset"
+	}
+	return result
+}
+
+func limitRangeProblems(item corev1.LimitRangeItem, requirements corev1.ResourceRequirements) []string {
+	var problems []string
+	// The LimitRanger admission plugin fills in missing values before validating
+	requests, limits := corev1.ResourceList{}, corev1.ResourceList{}
+	for name, q := range item.Default {
+		limits[name] = q
+	}
+	for name, q := range requirements.Limits {
+		limits[name] = q
+	}
+	for name, q := range limits {
+		requests[name] = q
+	}
+	for name, q := range item.DefaultRequest {
+		requests[name] = q
+	}
+	for name, q := range requirements.Requests {
+		requests[name] = q
+	}
+	for name, max := range item.Max {
+		limit, ok := limits[name]
+		if !ok {
+			problems = append(problems, fmt.Sprintf("%s max %s needs a %s limit", item.Type, max.String(), name))
+		} else if limit.Cmp(max) > 0 {
+			problems = append(problems, fmt.Sprintf("%s limit %s is above the %s max %s", name, limit.String(), item.Type, max.String()))
+		}
+	}
+	for name, min := range item.Min {
+		request, ok := requests[name]
+		if !ok {
+			problems = append(problems, fmt.Sprintf("%s min %s needs a %s request", item.Type, min.String(), name))
+		} else if request.Cmp(min) < 0 {
+			problems = append(problems, fmt.Sprintf("%s request %s is below the %s min %s", name, request.String(), item.Type, min.String()))
+		}
+	}
+	for name, ratio := range item.MaxLimitRequestRatio {
+		request, limit := requests[name], limits[name]
+		if request.IsZero() || limit.IsZero() {
+			continue
+		}
+		if limit.AsApproximateFloat64()/request.AsApproximateFloat64() > ratio.AsApproximateFloat64() {
+			problems = append(problems, fmt.Sprintf("%s limit/request ratio is above the %s max %s", name, item.Type, ratio.String()))
+		}
+	}
+	return problems
+}
+
+// checkQuota fails when the quota cannot hold the minimum replicas and warns when it cannot
+// hold the maximum
+func checkQuota(quota *corev1.ResourceQuota, pod []corev1.Container, current [][]corev1.Container, policy autoscalingPolicy) checkResult {
+	result := checkResult{Name: "resource-quota/" + quota.Name}
+	if quota.Spec.ScopeSelector != nil || !quotaScopesMatch(quota.Spec.Scopes) {
+		result.Status = CheckPass
+		result.Message = "scoped to other pods"
+		return result
+	}
+	fits := policy.MaxReplicas
+	limiting := ""
+	for name, hard := range quota.Spec.Hard {
+		perPod, ok := quotaUsage(name, pod)
+		if !ok || perPod.IsZero() {
+			continue
+		}
+		available := hard.DeepCopy()
+		available.Sub(quota.Status.Used[name])
+		for _, p := range current {
+			if used, ok := quotaUsage(name, p); ok {
+				available.Add(used)
+			}
+		}
+		n := 0
+		if available.Sign() > 0 {
+			n = int(available.MilliValue() / perPod.MilliValue())
+		}
+		if n < fits {
+			fits = n
+			limiting = fmt.Sprintf("%s %s of %s left", name, available.String(), hard.String())
+		}
+	}
+	switch {
+	case limiting == "":
+		result.Status = CheckPass
+		result.Message = fmt.Sprintf("room for %d replicas", policy.MaxReplicas)
+	case fits < policy.MinReplicas:
+		result.Status = CheckFail
+		result.Message = fmt.Sprintf("room for %d replicas, below the minimum %d (%s)", fits, policy.MinReplicas, limiting)
+		result.Hint = "Lower the requests or the minimum replicas, or ask a cluster admin for more quota"
+	default:
+		result.Status = CheckWarn
+		result.Message = fmt.Sprintf("room for %d of the %d maximum replicas (%s)", fits, policy.MaxReplicas, limiting)
+		result.Hint = "The ScaledObject will stop scaling out early, lower --max-replicas or ask for more quota"
+	}
+	return result
+}
+
+// quotaScopesMatch reports whether a quota with these scopes counts the pods this CLI creates,
+// which have requests and no deadline
+func quotaScopesMatch(scopes []corev1.ResourceQuotaScope) bool {
+	for _, scope := range scopes {
+		if scope != corev1.ResourceQuotaScopeNotBestEffort && scope != corev1.ResourceQuotaScopeNotTerminating {
+			return false
+		}
+	}
+	return true
+}
+
+// quotaUsage is what one pod of these containers adds to a quota resource, false for
+// resources this check does not understand
+func quotaUsage(name corev1.ResourceName, containers []corev1.Container) (resource.Quantity, bool) {
+	if name == corev1.ResourcePods || name == "count/pods" {
+		return *resource.NewQuantity(1, resource.DecimalSI), true
+	}
+	var resourceName corev1.ResourceName
+	fromLimits := false
+	switch {
+	case name == corev1.ResourceCPU || name == corev1.ResourceMemory:
+		resourceName = name
+	case strings.HasPrefix(string(name), "requests."):
+		resourceName = corev1.ResourceName(strings.TrimPrefix(string(name), "requests."))
+	case strings.HasPrefix(string(name), "limits."):
+		resourceName, fromLimits = corev1.ResourceName(strings.TrimPrefix(string(name), "limits.")), true
+	default:
+		return resource.Quantity{}, false
+	}
+	total := resource.Quantity{}
+	for _, c := range containers {
+		q, ok := c.Resources.Requests[resourceName]
+		if fromLimits || !ok {
+			// Requests default to the limits
+			q, ok = c.Resources.Limits[resourceName]
+		}
+		if ok {
+			total.Add(q)
+		}
+	}
+	return total, true
+}
+
+var ConfigPresetCmd = &cobra.Command{
+	Use:   "preset",
+	Short: "Manage named resource presets for create-deployment --preset",
+}
+
+var ConfigPresetListCmd = &cobra.Command{
+	Use:   "list",
+	Short: "List the built-in and configured resource presets",
+	Run: func(cmd *cobra.Command, args []string) {
+		presets := resourcePresets()
+		names := make([]string, 0, len(presets))
+		for name := range presets {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		tw := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+		fmt.Fprintln(tw, "NAME\tCPU\tMEMORY\tGPU\tNODE SELECTOR\tSOURCE")
+		for _, name := range names {
+			p := presets[name]
+			source := "built-in"
+			if _, ok := loadedConfig().Presets[name]; ok {
+				source = "config"
+			}
+			fmt.Fprintf(tw, "%s\t%s/%s\t%s/%s\t%s\t%s\t%s\n", name, orDash(p.CPURequest), orDash(p.CPULimit),
+				orDash(p.MemoryRequest), orDash(p.MemoryLimit), orDash(p.GPU), orDash(formatLabels(p.NodeSelector)), source)
+		}
+		tw.Flush()
+	},
+}
+
+var ConfigPresetSetCmd = &cobra.Command{
+	Use:   "set NAME",
+	Short: "Define a resource preset, replacing any preset of the same name",
+	Example: `  simplismart-cli config preset set gpu-a100-1x --cpu-request 8 --memory-request 64Gi \
+    --memory-limit 96Gi --gpus 1 --node-selector nvidia.com/gpu.product=NVIDIA-A100-SXM4-80GB`,
+	Args: cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		var p resourcePreset
+		p.CPURequest, _ = cmd.Flags().GetString("cpu-request")
+		p.CPULimit, _ = cmd.Flags().GetString("cpu-limit")
+		p.MemoryRequest, _ = cmd.Flags().GetString("memory-request")
+		p.MemoryLimit, _ = cmd.Flags().GetString("memory-limit")
+		p.GPU, _ = cmd.Flags().GetString("gpus")
+		nodeSelector, _ := cmd.Flags().GetString("node-selector")
+		if _, err := p.requirements(); err != nil {
+			fmt.Println(err)
+			return
+		}
+		selector, err := parseLabels(nodeSelector)
+		if err != nil {
+			fmt.Println(err)
+			return
+		}
+		if len(selector) > 0 {
+			p.NodeSelector = selector
+		}
+		config, err := loadConfig()
+		if err != nil {
+			fmt.Println(err)
+			return
+		}
+		if config.Presets == nil {
+			config.Presets = map[string]resourcePreset{}
+		}
+		config.Presets[args[0]] = p
+		if err := saveConfig(config); err != nil {
+			fmt.Println(err)
+			return
+		}
+		fmt.Printf("Saved preset %s\n", args[0])
+	},
+}
+
+var ConfigPresetDeleteCmd = &cobra.Command{
+	Use:   "delete NAME",
+	Short: "Delete a configured preset, restoring the built-in one of the same name if any",
+	Args:  cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		config, err := loadConfig()
+		if err != nil {
+			fmt.Println(err)
+			return
+		}
+		if _, ok := config.Presets[args[0]]; !ok {
+			fmt.Printf("Preset %q is not in the config file\n", args[0])
+			return
+		}
+		delete(config.Presets, args[0])
+		if err := saveConfig(config); err != nil {
+			fmt.Println(err)
+			return
+		}
+		fmt.Printf("Deleted preset %s\n", args[0])
+	},
+}
+
+// formatLabels is the inverse of parseLabels
+func formatLabels(labels map[string]string) string {
+	pairs := make([]string, 0, len(labels))
+	for k, v := range labels {
+		pairs = append(pairs, k+"="+v)
+	}
+	sort.Strings(pairs)
+	return strings.Join(pairs, ",")
+}
+
+func init() {
+	ConfigPresetSetCmd.Flags().String("cpu-request", "", "CPU request")
+	ConfigPresetSetCmd.Flags().String("cpu-limit", "", "CPU limit")
+	ConfigPresetSetCmd.Flags().String("memory-request", "", "Memory request")
+	ConfigPresetSetCmd.Flags().String("memory-limit", "", "Memory limit")
+	ConfigPresetSetCmd.Flags().String("gpus", "", "Number of nvidia.com/gpu")
+	ConfigPresetSetCmd.Flags().String("node-selector", "", "Node labels the pods must match, e.g. nvidia.com/gpu.product=NVIDIA-A10G")
+	ConfigPresetCmd.AddCommand(ConfigPresetListCmd, ConfigPresetSetCmd, ConfigPresetDeleteCmd)
+	ConfigCmd.AddCommand(ConfigPresetCmd)
+}
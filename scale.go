@@ -0,0 +1,399 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/spf13/cobra"
+	"golang.org/x/term"
+	appsv1 "k8s.io/api/apps/v1"
+	k8serrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/kubernetes"
+)
+
+// KEDA scales the target to this many replicas and stops autoscaling while it is set
+const pausedReplicasAnnotation = "autoscaling.keda.sh/paused-replicas"
+
+// kubectl rollout restart bumps this annotation
+const restartedAtAnnotation = "kubectl.kubernetes.io/restartedAt"
+
+// What scale does about an active ScaledObject
+const (
+	AutoscalerAdjust = "adjust"
+	AutoscalerPause  = "pause"
+)
+
+// scaledObjectState is the part of a ScaledObject scale, restart and autoscale explain care about
+type scaledObjectState struct {
+	MinReplicas    int
+	MaxReplicas    int
+	PausedReplicas string
+	// Prometheus triggers, and a description of the others
+	Triggers []scalingTrigger
+	Others   []string
+}
+
+var ScaleCmd = &cobra.Command{
+	Use:   "scale",
+	Short: "Set the replicas of a deployment, adjusting or pausing its ScaledObject",
+	Long: `Set the replicas of a deployment.
+
+KEDA would undo a manual scale of a deployment it autoscales, so with a ScaledObject the
+command either adjusts the ScaledObject or pauses it, asking which unless --autoscaler
+says so:
+
+  adjust  raise or lower its minimum to the replicas, and its maximum when below them;
+          KEDA still scales up to the maximum
+  pause   scale to exactly the replicas and stop autoscaling until 'scale --resume'
+
+A paused ScaledObject is kept paused at the new replicas; --autoscaler adjust needs
+'scale --resume' first.`,
+	Run: func(cmd *cobra.Command, args []string) {
+		name, _ := cmd.Flags().GetString("name")
+		namespace, _ := cmd.Flags().GetString("namespace")
+		if namespace == "" {
+			namespace = contextNamespace()
+		}
+		replicas, _ := cmd.Flags().GetInt32("replicas")
+		autoscaler, _ := cmd.Flags().GetString("autoscaler")
+		resume, _ := cmd.Flags().GetBool("resume")
+		if !resume && replicas < 0 {
+			fmt.Println("--replicas is required")
+			return
+		}
+		if autoscaler != "" && autoscaler != AutoscalerAdjust && autoscaler != AutoscalerPause {
+			fmt.Printf("Invalid --autoscaler %q, expected adjust or pause\n", autoscaler)
+			return
+		}
+
+		skipPreflight, _ := cmd.Flags().GetBool("skip-preflight")
+
+		clientset, err := GetK8sClient()
+		if err != nil {
+			fmt.Println(err)
+			return
+		}
+		if !skipPreflight {
+			if err := preflightRBAC(clientset, "scale", scalePermissions(namespace)); err != nil {
+				fmt.Println(err)
+				return
+			}
+		}
+		retireIdleColor(clientset, namespace, name, os.Stdout)
+		so, err := getScaledObjectState(clientset, namespace, name)
+		if err != nil && !k8serrors.IsNotFound(err) {
+			fmt.Println(err)
+			return
+		}
+		if resume {
+			if so == nil {
+				fmt.Printf("Deployment %s has no ScaledObject to resume\n", name)
+				return
+			}
+			err := patchScaledObject(clientset, namespace, name, map[string]interface{}{
+				"metadata": map[string]interface{}{"annotations": map[string]interface{}{pausedReplicasAnnotation: nil}},
+			})
+			if err != nil {
+				fmt.Println(err)
+				return
+			}
+			fmt.Printf("Resumed autoscaling of %s between %d and %d replicas\n", name, so.MinReplicas, so.MaxReplicas)
+			return
+		}
+
+		if so == nil {
+			deployment, err := liveWorkload(clientset, namespace, KindDeployment, name)
+			if err != nil {
+				fmt.Println(err)
+				return
+			}
+			scale, err := clientset.AppsV1().Deployments(namespace).GetScale(context.TODO(), deployment, metav1.GetOptions{})
+			if err != nil {
+				fmt.Printf("Failed to get deployment %s: %v\n", deployment, err)
+				return
+			}
+			scale.Spec.Replicas = replicas
+			if _, err := clientset.AppsV1().Deployments(namespace).UpdateScale(context.TODO(), deployment, scale, metav1.UpdateOptions{}); err != nil {
+				fmt.Printf("Failed to scale deployment %s: %v\n", deployment, err)
+				return
+			}
+			fmt.Printf("Scaled deployment %s to %d replicas\n", deployment, replicas)
+			return
+		}
+
+		if so.PausedReplicas != "" {
+			if autoscaler == AutoscalerAdjust {
+				fmt.Printf("ScaledObject %s is paused at %s replicas, run 'simplismart-cli scale --name %s --resume' before adjusting it\n", name, so.PausedReplicas, name)
+				return
+			}
+			autoscaler = AutoscalerPause
+		}
+		if autoscaler == "" {
+			if !term.IsTerminal(int(os.Stdin.Fd())) {
+				fmt.Printf("Deployment %s is autoscaled by ScaledObject %s, pass --autoscaler adjust or --autoscaler pause\n", name, name)
+				return
+			}
+			fmt.Printf("Deployment %s is autoscaled between %d and %d replicas by ScaledObject %s, which would undo a manual scale.\n", name, so.MinReplicas, so.MaxReplicas, name)
+			fmt.Printf("  a) adjust: set its minimum to %d replicas\n", replicas)
+			fmt.Printf("  p) pause:  keep %d replicas until 'scale --name %s --resume'\n", replicas, name)
+			switch prompt("Choose a or p, anything else cancels: ") {
+			case "a", "adjust":
+				autoscaler = AutoscalerAdjust
+			case "p", "pause":
+				autoscaler = AutoscalerPause
+			default:
+				fmt.Println("Cancelled")
+				return
+			}
+		}
+
+		switch autoscaler {
+		case AutoscalerPause:
+			err = patchScaledObject(clientset, namespace, name, map[string]interface{}{
+				"metadata": map[string]interface{}{"annotations": map[string]interface{}{pausedReplicasAnnotation: strconv.Itoa(int(replicas))}},
+			})
+			if err == nil {
+				fmt.Printf("Paused ScaledObject %s at %d replicas, run 'simplismart-cli scale --name %s --resume' to autoscale again\n", name, replicas, name)
+			}
+		case AutoscalerAdjust:
+			spec := map[string]interface{}{"minReplicaCount": replicas}
+			max := so.MaxReplicas
+			if int(replicas) > max {
+				max = int(replicas)
+				spec["maxReplicaCount"] = replicas
+			}
+			err = patchScaledObject(clientset, namespace, name, map[string]interface{}{"spec": spec})
+			if err == nil {
+				fmt.Printf("ScaledObject %s now autoscales %s between %d and %d replicas\n", name, name, replicas, max)
+			}
+		}
+		if err != nil {
+			fmt.Println(err)
+		}
+	},
+}
+
+var RestartCmd = &cobra.Command{
+	Use:   "restart",
+	Short: "Restart the pods of a deployment with a rolling update",
+	Long: `Restart the pods of a deployment with a rolling update, like kubectl rollout restart.
+
+New pods replace the old ones following the deployment's rollout strategy, so the
+service keeps answering. --wait follows the rollout until it completes.`,
+	Run: func(cmd *cobra.Command, args []string) {
+		name, _ := cmd.Flags().GetString("name")
+		namespace, _ := cmd.Flags().GetString("namespace")
+		if namespace == "" {
+			namespace = contextNamespace()
+		}
+		wait, _ := cmd.Flags().GetBool("wait")
+		timeout, _ := cmd.Flags().GetDuration("timeout")
+		skipPreflight, _ := cmd.Flags().GetBool("skip-preflight")
+
+		clientset, err := GetK8sClient()
+		if err != nil {
+			fmt.Println(err)
+			return
+		}
+		if !skipPreflight {
+			if err := preflightRBAC(clientset, "restart", restartPermissions(namespace)); err != nil {
+				fmt.Println(err)
+				return
+			}
+		}
+		retireIdleColor(clientset, namespace, name, os.Stdout)
+		// A blue/green deployment restarts the color serving traffic
+		name, err = liveWorkload(clientset, namespace, KindDeployment, name)
+		if err != nil {
+			fmt.Println(err)
+			return
+		}
+		patch, _ := json.Marshal(map[string]interface{}{
+			"spec": map[string]interface{}{
+				"template": map[string]interface{}{
+					"metadata": map[string]interface{}{
+						"annotations": map[string]string{restartedAtAnnotation: time.Now().Format(time.RFC3339)},
+					},
+				},
+			},
+		})
+		_, err = clientset.AppsV1().Deployments(namespace).Patch(context.TODO(), name, types.StrategicMergePatchType, patch, metav1.PatchOptions{})
+		if err != nil {
+			fmt.Printf("Failed to restart deployment %s: %v\n", name, err)
+			return
+		}
+		fmt.Printf("Restarting deployment %s\n", name)
+		if !wait {
+			return
+		}
+		ctx, cancel := context.WithTimeout(context.Background(), timeout)
+		defer cancel()
+		if err := waitForRollout(ctx, clientset, namespace, name); err != nil {
+			fmt.Println(err)
+			os.Exit(1)
+		}
+		fmt.Printf("Deployment %s restarted\n", name)
+	},
+}
+
+// getScaledObjectState returns a NotFound error when the deployment has no ScaledObject
+func getScaledObjectState(clientset *kubernetes.Clientset, namespace, name string) (*scaledObjectState, error) {
+	data, err := clientset.RESTClient().
+		Get().
+		AbsPath("/apis/keda.sh/v1alpha1").
+		Namespace(namespace).
+		Resource("scaledobjects").
+		Name(name).
+		DoRaw(context.Background())
+	if err != nil {
+		if k8serrors.IsNotFound(err) {
+			return nil, err
+		}
+		return nil, fmt.Errorf("failed to get ScaledObject %s: %v", name, err)
+	}
+	var so struct {
+		Metadata struct {
+			Annotations map[string]string `json:"annotations"`
+		} `json:"metadata"`
+		Spec struct {
+			MinReplicaCount *int `json:"minReplicaCount"`
+			MaxReplicaCount *int `json:"maxReplicaCount"`
+			Triggers        []struct {
+				Type       string            `json:"type"`
+				Name       string            `json:"name"`
+				MetricType string            `json:"metricType"`
+				Metadata   map[string]string `json:"metadata"`
+			} `json:"triggers"`
+		} `json:"spec"`
+	}
+	if err := json.Unmarshal(data, &so); err != nil {
+		return nil, err
+	}
+	// KEDA's defaults
+	state := &scaledObjectState{MinReplicas: 0, MaxReplicas: 100, PausedReplicas: so.Metadata.Annotations[pausedReplicasAnnotation]}
+	if so.Spec.MinReplicaCount != nil {
+		state.MinReplicas = *so.Spec.MinReplicaCount
+	}
+	if so.Spec.MaxReplicaCount != nil {
+		state.MaxReplicas = *so.Spec.MaxReplicaCount
+	}
+	for i, t := range so.Spec.Triggers {
+		if t.Type != "prometheus" {
+			state.Others = append(state.Others, fmt.Sprintf("%s %s%%", t.Type, t.Metadata["value"]))
+			continue
+		}
+		name := t.Name
+		if name == "" {
+			name = fmt.Sprintf("trigger-%d", i)
+		}
+		metricType := t.MetricType
+		if metricType == "" {
+			metricType = MetricAverageValue
+		}
+		state.Triggers = append(state.Triggers, scalingTrigger{
+			Name: name, Query: t.Metadata["query"], Threshold: t.Metadata["threshold"],
+			ActivationThreshold: t.Metadata["activationThreshold"], MetricType: metricType,
+		})
+	}
+	return state, nil
+}
+
+func patchScaledObject(clientset *kubernetes.Clientset, namespace, name string, patch map[string]interface{}) error {
+	data, err := json.Marshal(patch)
+	if err != nil {
+		return err
+	}
+	_, err = clientset.RESTClient().
+		Patch(types.MergePatchType).
+		AbsPath("/apis/keda.sh/v1alpha1").
+		Namespace(namespace).
+		Resource("scaledobjects").
+		Name(name).
+		Body(data).
+		DoRaw(context.Background())
+	if err != nil {
+		return fmt.Errorf("failed to patch ScaledObject %s: %v", name, err)
+	}
+	return nil
+}
+
+// waitForRollout follows the deployment until its current revision is available, like
+// kubectl rollout status
+func waitForRollout(ctx context.Context, clientset *kubernetes.Clientset, namespace, name string) error {
+	reported := ""
+	for {
+		d, err := clientset.AppsV1().Deployments(namespace).Get(ctx, name, metav1.GetOptions{})
+		if err != nil {
+			return fmt.Errorf("failed to get deployment %s: %v", name, err)
+		}
+		status := rolloutStatus(d)
+		if status == "" {
+			return nil
+		}
+		for _, c := range d.Status.Conditions {
+			if c.Type == appsv1.DeploymentProgressing && c.Reason == "ProgressDeadlineExceeded" {
+				return fmt.Errorf("rollout of %s exceeded its progress deadline, see 'simplismart-cli health-status --name %s'", name, name)
+			}
+		}
+		if status != reported {
+			fmt.Printf("Waiting for rollout: %s\n", status)
+			reported = status
+		}
+		if !sleepContext(ctx, 2*time.Second) {
+			return fmt.Errorf("rollout of %s did not complete in time: %s", name, status)
+		}
+	}
+}
+
+// rolloutStatus describes what the rollout waits for, empty once it is complete
+func rolloutStatus(d *appsv1.Deployment) string {
+	if d.Status.ObservedGeneration < d.Generation {
+		return "the update is not observed yet"
+	}
+	desired := int32(1)
+	if d.Spec.Replicas != nil {
+		desired = *d.Spec.Replicas
+	}
+	switch {
+	case d.Status.UpdatedReplicas < desired:
+		return fmt.Sprintf("%d of %d replicas updated", d.Status.UpdatedReplicas, desired)
+	case d.Status.Replicas > d.Status.UpdatedReplicas:
+		return fmt.Sprintf("%d old replicas terminating", d.Status.Replicas-d.Status.UpdatedReplicas)
+	case d.Status.AvailableReplicas < d.Status.UpdatedReplicas:
+		return fmt.Sprintf("%d of %d updated replicas available", d.Status.AvailableReplicas, d.Status.UpdatedReplicas)
+	}
+	return ""
+}
+
+// prompt reads a trimmed, lower-case answer from stdin
+func prompt(question string) string {
+	fmt.Print(question)
+	answer, _ := bufio.NewReader(os.Stdin).ReadString('\n')
+	return strings.ToLower(strings.TrimSpace(answer))
+}
+
+func init() {
+	ScaleCmd.Flags().String("name", "", "Name of the deployment")
+	ScaleCmd.Flags().String("namespace", "", "Namespace of the deployment (defaults to the context's namespace)")
+	ScaleCmd.Flags().Int32("replicas", -1, "Number of replicas")
+	ScaleCmd.Flags().String("autoscaler", "", "With a ScaledObject: adjust its minimum or pause it (asked when left out)")
+	ScaleCmd.Flags().Bool("resume", false, "Resume autoscaling of a ScaledObject paused by scale")
+	ScaleCmd.Flags().Bool("skip-preflight", false, "Skip the permission check before scaling")
+	ScaleCmd.MarkFlagRequired("name")
+	ScaleCmd.MarkFlagsMutuallyExclusive("replicas", "resume")
+
+	RestartCmd.Flags().String("name", "", "Name of the deployment")
+	RestartCmd.Flags().String("namespace", "", "Namespace of the deployment (defaults to the context's namespace)")
+	RestartCmd.Flags().Bool("wait", false, "Wait for the rollout to complete")
+	RestartCmd.Flags().Duration("timeout", 10*time.Minute, "How long --wait waits")
+	RestartCmd.Flags().Bool("skip-preflight", false, "Skip the permission check before restarting")
+	RestartCmd.MarkFlagRequired("name")
+}
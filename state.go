@@ -0,0 +1,170 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+
+	"sigs.k8s.io/yaml"
+)
+
+// Name of the per-directory file pinning the cluster of a project
+const projectFileName = ".simplismart.yaml"
+
+// A selection is the context and namespace this CLI runs against. Empty fields fall back to
+// the kubeconfig.
+type selection struct {
+	Context   string `json:"context,omitempty"`
+	Namespace string `json:"namespace,omitempty"`
+	// Only read from .simplismart.yaml
+	Profile string `json:"profile,omitempty"`
+}
+
+// configDir is ~/.config/simplismart, or under $XDG_CONFIG_HOME when set
+func configDir() (string, error) {
+	dir := os.Getenv("XDG_CONFIG_HOME")
+	if dir == "" {
+		home, err := os.UserHomeDir()
+		if err != nil {
+			return "", err
+		}
+		dir = filepath.Join(home, ".config")
+	}
+	return filepath.Join(dir, "simplismart"), nil
+}
+
+func statePath() (string, error) {
+	dir, err := configDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(dir, "state.yaml"), nil
+}
+
+func readSelection(path string) (selection, error) {
+	var s selection
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return s, err
+	}
+	if err := yaml.UnmarshalStrict(data, &s); err != nil {
+		return s, fmt.Errorf("cannot parse %s: %v", path, err)
+	}
+	return s, nil
+}
+
+// loadState returns the selection saved by connect, empty when there is none
+func loadState() (selection, error) {
+	path, err := statePath()
+	if err != nil {
+		return selection{}, err
+	}
+	s, err := readSelection(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return selection{}, nil
+	}
+	return s, err
+}
+
+func saveState(s selection) error {
+	path, err := statePath()
+	if err != nil {
+		return err
+	}
+	if s == (selection{}) {
+		if err := os.Remove(path); err != nil && !errors.Is(err, os.ErrNotExist) {
+			return fmt.Errorf("failed to clear %s: %v", path, err)
+		}
+		return nil
+	}
+	data, err := yaml.Marshal(s)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return fmt.Errorf("failed to save %s: %v", path, err)
+	}
+	if err := os.WriteFile(path, data, 0600); err != nil {
+		return fmt.Errorf("failed to save %s: %v", path, err)
+	}
+	return nil
+}
+
+// findProjectFile looks for .simplismart.yaml in the working directory and its parents
+func findProjectFile() string {
+	dir, err := os.Getwd()
+	if err != nil {
+		return ""
+	}
+	for {
+		path := filepath.Join(dir, projectFileName)
+		if _, err := os.Stat(path); err == nil {
+			return path
+		}
+		parent := filepath.Dir(dir)
+		if parent == dir {
+			return ""
+		}
+		dir = parent
+	}
+}
+
+// A selectionLayer is one place the context and namespace can come from
+type selectionLayer struct {
+	selection
+	source string
+}
+
+// activeSelection resolves the context and namespace from, in order: --context, the
+// SIMPLISMART_CONTEXT and SIMPLISMART_NAMESPACE variables, the nearest .simplismart.yaml, the
+// state file saved by connect and the active profile. The first layer naming a context decides
+// it; the namespace comes from that layer or one above it. Empty fields fall back to the
+// kubeconfig. The source is the file or setting that decided.
+func activeSelection() (selection, string) {
+	layers := selectionLayers()
+	if kubeContext != "" {
+		layers = append([]selectionLayer{{selection{Context: kubeContext}, "--context"}}, layers...)
+	}
+	var active selection
+	source := ""
+	for _, layer := range layers {
+		if active.Namespace == "" && layer.Namespace != "" {
+			active.Namespace = layer.Namespace
+			source = layer.source
+		}
+		if layer.Context != "" {
+			active.Context = layer.Context
+			return active, layer.source
+		}
+	}
+	return active, source
+}
+
+var selectionLayers = sync.OnceValue(func() []selectionLayer {
+	var layers []selectionLayer
+	env := selection{Context: os.Getenv("SIMPLISMART_CONTEXT"), Namespace: os.Getenv("SIMPLISMART_NAMESPACE")}
+	if env != (selection{}) {
+		layers = append(layers, selectionLayer{env, "environment"})
+	}
+	if path := findProjectFile(); path != "" {
+		s, err := readSelection(path)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Warning: ignoring %v\n", err)
+		} else {
+			layers = append(layers, selectionLayer{s, path})
+		}
+	}
+	if s, err := loadState(); err != nil {
+		fmt.Fprintf(os.Stderr, "Warning: ignoring %v\n", err)
+	} else {
+		path, _ := statePath()
+		layers = append(layers, selectionLayer{s, path})
+	}
+	if name, _ := activeProfile(); name != "" {
+		p := loadedConfig().Profiles[name]
+		layers = append(layers, selectionLayer{selection{Context: p["context"], Namespace: p["namespace"]}, "profile " + name})
+	}
+	return layers
+})
@@ -0,0 +1,350 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+
+	corev1 "k8s.io/api/core/v1"
+	k8serrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/validation"
+	"k8s.io/client-go/kubernetes"
+)
+
+// Label marking PVCs the CLI created, so delete --purge only removes those
+const managedByLabel = "app.kubernetes.io/managed-by"
+
+const managedByValue = "simplismart-cli"
+
+// Volume types of --volume
+const (
+	VolumeEmptyDir  = "emptyDir"
+	VolumeShm       = "shm"
+	VolumePVC       = "pvc"
+	VolumeCache     = "cache"
+	VolumeHostPath  = "hostPath"
+	VolumeConfigMap = "configMap"
+	VolumeSecret    = "secret"
+)
+
+// A volumeOption is one --volume value
+type volumeOption struct {
+	Type         string
+	Name         string
+	Source       string
+	Mount        string
+	SubPath      string
+	ReadOnly     bool
+	Size         string
+	StorageClass string
+	AccessMode   corev1.PersistentVolumeAccessMode
+	// Containers mounting the volume, the main container when empty
+	Containers []string
+}
+
+// parseVolumeFlag parses comma separated key=value pairs:
+//
+//	type=shm,size=8Gi
+//	type=emptyDir,name=scratch,mount=/scratch
+//	type=pvc,source=llama-weights,mount=/models,size=100Gi,storageClass=gp3
+//	type=cache,source=shared-models,mount=/models,subPath=llama-3-8b
+//	type=hostPath,name=nvme,source=/mnt/nvme,mount=/cache
+//	type=secret,source=hf-token,mount=/secrets/hf,containers=download-model;app
+func parseVolumeFlag(value string) (volumeOption, error) {
+	v := volumeOption{}
+	readOnly := ""
+	for _, pair := range strings.Split(value, ",") {
+		key, val, ok := strings.Cut(pair, "=")
+		if !ok {
+			return v, fmt.Errorf("%q is not key=value in %q", pair, value)
+		}
+		switch key {
+		case "type":
+			v.Type = val
+		case "name":
+			v.Name = val
+		case "source":
+			v.Source = val
+		case "mount":
+			v.Mount = val
+		case "subPath":
+			v.SubPath = val
+		case "readOnly":
+			readOnly = val
+		case "size":
+			v.Size = val
+		case "storageClass":
+			v.StorageClass = val
+		case "accessMode":
+			v.AccessMode = corev1.PersistentVolumeAccessMode(val)
+		case "containers":
+			v.Containers = strings.Split(val, ";")
+		default:
+			return v, fmt.Errorf("unknown key %q in %q", key, value)
+		}
+	}
+
+	switch v.Type {
+	case VolumeShm:
+		if v.Name == "" {
+			v.Name = "dshm"
+		}
+		if v.Mount == "" {
+			v.Mount = "/dev/shm"
+		}
+	case VolumeEmptyDir:
+	case VolumePVC, VolumeCache:
+		if v.Source == "" {
+			return v, fmt.Errorf("%s volume %q needs source=CLAIM", v.Type, value)
+		}
+		if v.AccessMode == "" {
+			v.AccessMode = corev1.ReadWriteOnce
+			if v.Type == VolumeCache {
+				v.AccessMode = corev1.ReadWriteMany
+			}
+		}
+		switch v.AccessMode {
+		case corev1.ReadWriteOnce, corev1.ReadWriteOncePod, corev1.ReadWriteMany, corev1.ReadOnlyMany:
+		default:
+			return v, fmt.Errorf("invalid accessMode %q in %q", v.AccessMode, value)
+		}
+		// Shared caches are filled out of band; replicas only read them
+		if v.Type == VolumeCache && readOnly == "" {
+			readOnly = "true"
+		}
+	case VolumeHostPath, VolumeConfigMap, VolumeSecret:
+		if v.Source == "" {
+			return v, fmt.Errorf("%s volume %q needs a source", v.Type, value)
+		}
+	default:
+		return v, fmt.Errorf("unknown volume type %q in %q, expected emptyDir, shm, pvc, cache, hostPath, configMap or secret", v.Type, value)
+	}
+	if v.Name == "" {
+		if v.Type == VolumeHostPath || v.Type == VolumeEmptyDir {
+			return v, fmt.Errorf("%s volume %q needs a name", v.Type, value)
+		}
+		v.Name = v.Source
+	}
+	if errs := validation.IsDNS1123Label(v.Name); len(errs) > 0 {
+		return v, fmt.Errorf("invalid volume name %q: %s", v.Name, strings.Join(errs, "; "))
+	}
+	if !strings.HasPrefix(v.Mount, "/") {
+		return v, fmt.Errorf("volume %s needs an absolute mount path", v.Name)
+	}
+	if readOnly != "" {
+		b, err := strconv.ParseBool(readOnly)
+		if err != nil {
+			return v, fmt.Errorf("invalid readOnly %q in %q", readOnly, value)
+		}
+		v.ReadOnly = b
+	}
+	if v.Size != "" {
+		if _, err := resource.ParseQuantity(v.Size); err != nil {
+			return v, fmt.Errorf("invalid size %q in %q: %v", v.Size, value, err)
+		}
+	}
+	return v, nil
+}
+
+// withVolume adds the volume, replacing an earlier one of the same name such as a template's
+func withVolume(volumes []volumeOption, v volumeOption) []volumeOption {
+	for i := range volumes {
+		if volumes[i].Name == v.Name {
+			volumes[i] = v
+			return volumes
+		}
+	}
+	return append(volumes, v)
+}
+
+func (v volumeOption) volume() corev1.Volume {
+	volume := corev1.Volume{Name: v.Name}
+	switch v.Type {
+	case VolumeEmptyDir, VolumeShm:
+		source := &corev1.EmptyDirVolumeSource{}
+		if v.Type == VolumeShm {
+			source.Medium = corev1.StorageMediumMemory
+		}
+		if v.Size != "" {
+			size := resource.MustParse(v.Size)
+			source.SizeLimit = &size
+		}
+		volume.EmptyDir = source
+	case VolumePVC, VolumeCache:
+		volume.PersistentVolumeClaim = &corev1.PersistentVolumeClaimVolumeSource{ClaimName: v.Source, ReadOnly: v.ReadOnly}
+	case VolumeHostPath:
+		volume.HostPath = &corev1.HostPathVolumeSource{Path: v.Source}
+	case VolumeConfigMap:
+		volume.ConfigMap = &corev1.ConfigMapVolumeSource{LocalObjectReference: corev1.LocalObjectReference{Name: v.Source}}
+	case VolumeSecret:
+		volume.Secret = &corev1.SecretVolumeSource{SecretName: v.Source}
+	}
+	return volume
+}
+
+// addVolumes adds the volumes to the pod and mounts them into their containers
+func addVolumes(pod *corev1.PodSpec, mainContainer string, volumes []volumeOption) error {
+	for _, v := range volumes {
+		for _, existing := range pod.Volumes {
+			if existing.Name == v.Name {
+				return fmt.Errorf("volume name %q is used twice", v.Name)
+			}
+		}
+		pod.Volumes = append(pod.Volumes, v.volume())
+		targets := v.Containers
+		if len(targets) == 0 {
+			targets = []string{mainContainer}
+		}
+		for _, target := range targets {
+			c := findContainer(pod, target)
+			if c == nil {
+				return fmt.Errorf("volume %s: no container named %s", v.Name, target)
+			}
+			c.VolumeMounts = append(c.VolumeMounts, corev1.VolumeMount{
+				Name: v.Name, MountPath: v.Mount, SubPath: v.SubPath, ReadOnly: v.ReadOnly,
+			})
+		}
+	}
+	return nil
+}
+
+func findContainer(pod *corev1.PodSpec, name string) *corev1.Container {
+	for i := range pod.Containers {
+		if pod.Containers[i].Name == name {
+			return &pod.Containers[i]
+		}
+	}
+	for i := range pod.InitContainers {
+		if pod.InitContainers[i].Name == name {
+			return &pod.InitContainers[i]
+		}
+	}
+	return nil
+}
+
+// mergeVolumes updates the existing volumes by name, appends new ones and drops the removed
+// ones, keeping volumes added to the deployment by other means
+func mergeVolumes(existing, desired []corev1.Volume, removed []string) []corev1.Volume {
+	drop := map[string]bool{}
+	for _, name := range removed {
+		drop[name] = true
+	}
+	byName := map[string]corev1.Volume{}
+	for _, v := range desired {
+		byName[v.Name] = v
+	}
+	var merged []corev1.Volume
+	for _, v := range existing {
+		if drop[v.Name] {
+			continue
+		}
+		if d, ok := byName[v.Name]; ok {
+			v = d
+			delete(byName, v.Name)
+		}
+		merged = append(merged, v)
+	}
+	for _, v := range desired {
+		if _, ok := byName[v.Name]; ok {
+			merged = append(merged, v)
+		}
+	}
+	return merged
+}
+
+// dropVolumeMounts removes the mounts of removed volumes from kept containers
+func dropVolumeMounts(containers []corev1.Container, removed []string) {
+	drop := map[string]bool{}
+	for _, name := range removed {
+		drop[name] = true
+	}
+	for i := range containers {
+		var mounts []corev1.VolumeMount
+		for _, m := range containers[i].VolumeMounts {
+			if !drop[m.Name] {
+				mounts = append(mounts, m)
+			}
+		}
+		containers[i].VolumeMounts = mounts
+	}
+}
+
+// ensureClaims creates the PVCs of pvc and cache volumes that do not exist yet
+func ensureClaims(clientset *kubernetes.Clientset, namespace, name string, volumes []volumeOption) error {
+	for _, v := range volumes {
+		if v.Type != VolumePVC && v.Type != VolumeCache {
+			continue
+		}
+		claim, err := clientset.CoreV1().PersistentVolumeClaims(namespace).Get(context.TODO(), v.Source, metav1.GetOptions{})
+		if err == nil {
+			if !hasAccessMode(claim.Spec.AccessModes, v.AccessMode) {
+				fmt.Printf("Warning: PVC %s has access modes %v, not %s\n", v.Source, claim.Spec.AccessModes, v.AccessMode)
+			}
+			fmt.Printf("Using PVC %s\n", v.Source)
+			continue
+		}
+		if !k8serrors.IsNotFound(err) {
+			return fmt.Errorf("failed to get PVC %s: %v", v.Source, err)
+		}
+		if v.Size == "" {
+			return fmt.Errorf("PVC %s does not exist, pass size= to create it", v.Source)
+		}
+		claim = &corev1.PersistentVolumeClaim{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:      v.Source,
+				Namespace: namespace,
+				Labels:    map[string]string{"app": name, managedByLabel: managedByValue},
+			},
+			Spec: corev1.PersistentVolumeClaimSpec{
+				AccessModes: []corev1.PersistentVolumeAccessMode{v.AccessMode},
+				Resources: corev1.VolumeResourceRequirements{
+					Requests: corev1.ResourceList{corev1.ResourceStorage: resource.MustParse(v.Size)},
+				},
+			},
+		}
+		if v.StorageClass != "" {
+			claim.Spec.StorageClassName = &v.StorageClass
+		}
+		if _, err := clientset.CoreV1().PersistentVolumeClaims(namespace).Create(context.TODO(), claim, metav1.CreateOptions{}); err != nil {
+			return fmt.Errorf("failed to create PVC %s: %v", v.Source, err)
+		}
+		fmt.Printf("Created PVC %s (%s, %s)\n", v.Source, v.Size, v.AccessMode)
+	}
+	return nil
+}
+
+func hasAccessMode(modes []corev1.PersistentVolumeAccessMode, want corev1.PersistentVolumeAccessMode) bool {
+	for _, m := range modes {
+		if m == want {
+			return true
+		}
+	}
+	return false
+}
+
+// volumeWarnings flags volumes that will not behave with several replicas
+func volumeWarnings(volumes []volumeOption, maxReplicas int) []string {
+	var warnings []string
+	for _, v := range volumes {
+		switch {
+		case (v.Type == VolumePVC || v.Type == VolumeCache) && maxReplicas > 1 &&
+			(v.AccessMode == corev1.ReadWriteOnce || v.AccessMode == corev1.ReadWriteOncePod):
+			warnings = append(warnings, fmt.Sprintf("PVC %s is %s, replicas on other nodes cannot mount it; use type=cache for a shared ReadWriteMany cache", v.Source, v.AccessMode))
+		case v.Type == VolumeHostPath:
+			warnings = append(warnings, fmt.Sprintf("hostPath %s differs on every node and may be blocked by Pod Security admission", v.Source))
+		}
+	}
+	return warnings
+}
+
+func volumePermissions(namespace string, volumes []volumeOption) []permission {
+	for _, v := range volumes {
+		if v.Type == VolumePVC || v.Type == VolumeCache {
+			return permissions("", "persistentvolumeclaims", namespace, "get", "create")
+		}
+	}
+	return nil
+}
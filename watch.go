@@ -0,0 +1,529 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"os"
+	"os/signal"
+	"sort"
+	"strings"
+	"syscall"
+	"text/tabwriter"
+	"time"
+
+	"golang.org/x/term"
+	autoscalingv2 "k8s.io/api/autoscaling/v2"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/client-go/informers"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/tools/cache"
+	metricsv1beta1 "k8s.io/metrics/pkg/client/clientset/versioned"
+)
+
+// Columns the dashboard can be sorted by, cycled with the 's' key
+var watchSortKeys = []string{"name", "status", "restarts", "cpu", "memory"}
+
+type workloadRow struct {
+	Kind       string
+	Name       string
+	Desired    int32
+	Ready      int32
+	Updated    int32
+	Available  int32
+	Autoscaler string
+	Pods       []podRow
+}
+
+type podRow struct {
+	Name     string
+	Status   string
+	Ready    string
+	Restarts int32
+	Age      time.Duration
+	CPU      string
+	Memory   string
+	cpuMilli int64
+	memBytes int64
+}
+
+type healthWatcher struct {
+	clientset     *kubernetes.Clientset
+	metricsClient *metricsv1beta1.Clientset
+	namespace     string
+	kind          string
+	names         []string
+	factory       informers.SharedInformerFactory
+	changed       chan struct{}
+	interval      time.Duration
+	usage         map[string]corev1.ResourceList
+	usageAt       time.Time
+	sortKey       int
+	selected      int
+}
+
+// watchHealthStatus keeps the status of the workloads on screen until interrupted
+func watchHealthStatus(clientset *kubernetes.Clientset, metricsClient *metricsv1beta1.Clientset, namespace, kind string, names []string, interval time.Duration, tui bool) error {
+	// In the TUI Ctrl-C is read as a key, and while showing logs it only ends the logs
+	signals := []os.Signal{os.Interrupt, syscall.SIGTERM}
+	if tui {
+		signals = []os.Signal{syscall.SIGTERM}
+	}
+	ctx, stop := signal.NotifyContext(context.Background(), signals...)
+	defer stop()
+
+	w := &healthWatcher{
+		clientset:     clientset,
+		metricsClient: metricsClient,
+		namespace:     namespace,
+		kind:          kind,
+		names:         names,
+		interval:      interval,
+		factory:       informers.NewSharedInformerFactoryWithOptions(clientset, 0, informers.WithNamespace(namespace)),
+		changed:       make(chan struct{}, 1),
+		usage:         map[string]corev1.ResourceList{},
+	}
+	notify := cache.ResourceEventHandlerFuncs{
+		AddFunc:    func(obj interface{}) { w.notify() },
+		UpdateFunc: func(oldObj, newObj interface{}) { w.notify() },
+		DeleteFunc: func(obj interface{}) { w.notify() },
+	}
+	switch kind {
+	case KindStatefulSet:
+		w.factory.Apps().V1().StatefulSets().Informer().AddEventHandler(notify)
+	case KindDaemonSet:
+		w.factory.Apps().V1().DaemonSets().Informer().AddEventHandler(notify)
+	default:
+		w.factory.Apps().V1().Deployments().Informer().AddEventHandler(notify)
+		w.factory.Apps().V1().ReplicaSets().Informer().AddEventHandler(notify)
+	}
+	w.factory.Core().V1().Pods().Informer().AddEventHandler(notify)
+	w.factory.Autoscaling().V2().HorizontalPodAutoscalers().Informer().AddEventHandler(notify)
+	w.factory.Start(ctx.Done())
+	defer w.factory.Shutdown()
+	for informer, synced := range w.factory.WaitForCacheSync(ctx.Done()) {
+		if !synced {
+			return fmt.Errorf("failed to sync informer %v", informer)
+		}
+	}
+
+	if tui {
+		return w.runTUI(ctx, interval)
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	clearScreen := isTerminal(os.Stdout)
+	for {
+		w.refreshMetrics(ctx)
+		var buf bytes.Buffer
+		w.render(&buf, false)
+		if clearScreen {
+			fmt.Print("\033[H\033[2J")
+		} else {
+			fmt.Println()
+		}
+		fmt.Print(buf.String())
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-ticker.C:
+		case <-w.changed:
+		}
+	}
+}
+
+func (w *healthWatcher) notify() {
+	select {
+	case w.changed <- struct{}{}:
+	default:
+	}
+}
+
+// refreshMetrics polls the metrics API, which has no watch support, at most once per interval
+func (w *healthWatcher) refreshMetrics(ctx context.Context) {
+	if time.Since(w.usageAt) < w.interval {
+		return
+	}
+	w.usageAt = time.Now()
+	podMetrics, err := w.metricsClient.MetricsV1beta1().PodMetricses(w.namespace).List(ctx, metav1.ListOptions{})
+	if err != nil {
+		return
+	}
+	usage := map[string]corev1.ResourceList{}
+	for _, pm := range podMetrics.Items {
+		total := corev1.ResourceList{}
+		for _, c := range pm.Containers {
+			addResources(total, c.Usage)
+		}
+		usage[pm.Name] = total
+	}
+	w.usage = usage
+}
+
+func (w *healthWatcher) rows() []workloadRow {
+	podLister := w.factory.Core().V1().Pods().Lister().Pods(w.namespace)
+	hpas, _ := w.factory.Autoscaling().V2().HorizontalPodAutoscalers().Lister().HorizontalPodAutoscalers(w.namespace).List(labels.Everything())
+
+	var rows []workloadRow
+	for _, name := range w.names {
+		wl, err := w.getWorkload(name)
+		if err != nil {
+			rows = append(rows, workloadRow{Kind: w.kind, Name: name, Autoscaler: err.Error()})
+			continue
+		}
+		row := workloadRow{
+			Kind:       wl.Kind,
+			Name:       name,
+			Desired:    wl.Desired,
+			Ready:      wl.Ready,
+			Updated:    wl.Updated,
+			Available:  wl.Available,
+			Autoscaler: describeAutoscaler(wl.Kind, wl.Name, hpas),
+		}
+		// Pods of old revisions stay visible so rollouts can be followed
+		pods, _ := podLister.List(wl.Selector)
+		for _, pod := range pods {
+			if owned, _ := wl.owns(pod); owned {
+				row.Pods = append(row.Pods, w.podRow(pod))
+			}
+		}
+		w.sortPods(row.Pods)
+		rows = append(rows, row)
+	}
+	return rows
+}
+
+// getWorkload reads the workload from the informer cache
+func (w *healthWatcher) getWorkload(name string) (*workload, error) {
+	var obj interface{}
+	var err error
+	switch w.kind {
+	case KindStatefulSet:
+		obj, err = w.factory.Apps().V1().StatefulSets().Lister().StatefulSets(w.namespace).Get(name)
+	case KindDaemonSet:
+		obj, err = w.factory.Apps().V1().DaemonSets().Lister().DaemonSets(w.namespace).Get(name)
+	default:
+		obj, err = w.factory.Apps().V1().Deployments().Lister().Deployments(w.namespace).Get(name)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("%s not found", strings.ToLower(w.kind))
+	}
+	wl, err := workloadFromObject(obj)
+	if err != nil {
+		return nil, err
+	}
+	if wl.Kind == KindDeployment {
+		replicaSets, _ := w.factory.Apps().V1().ReplicaSets().Lister().ReplicaSets(w.namespace).List(wl.Selector)
+		wl.setReplicaSets(replicaSets)
+	}
+	return wl, nil
+}
+
+func (w *healthWatcher) podRow(pod *corev1.Pod) podRow {
+	row := podRow{
+		Name:   pod.Name,
+		Status: string(pod.Status.Phase),
+		Age:    time.Since(pod.CreationTimestamp.Time).Round(time.Second),
+	}
+	if pod.DeletionTimestamp != nil {
+		row.Status = "Terminating"
+	}
+	ready := 0
+	for _, cs := range pod.Status.ContainerStatuses {
+		if cs.Ready {
+			ready++
+		}
+		row.Restarts += cs.RestartCount
+		if cs.State.Waiting != nil && cs.State.Waiting.Reason != "" {
+			row.Status = cs.State.Waiting.Reason
+		}
+	}
+	row.Ready = fmt.Sprintf("%d/%d", ready, len(pod.Spec.Containers))
+
+	requests, limits := corev1.ResourceList{}, corev1.ResourceList{}
+	for _, c := range pod.Spec.Containers {
+		addResources(requests, c.Resources.Requests)
+		addResources(limits, c.Resources.Limits)
+	}
+	row.CPU, row.Memory = "-", "-"
+	if usage, ok := w.usage[pod.Name]; ok {
+		cpu, memory := usage[corev1.ResourceCPU], usage[corev1.ResourceMemory]
+		row.cpuMilli, row.memBytes = cpu.MilliValue(), memory.Value()
+		row.CPU = formatUsage(cpu, requests[corev1.ResourceCPU], limits[corev1.ResourceCPU], true)
+		row.Memory = formatUsage(memory, requests[corev1.ResourceMemory], limits[corev1.ResourceMemory], false)
+	}
+	return row
+}
+
+func (w *healthWatcher) sortPods(pods []podRow) {
+	key := watchSortKeys[w.sortKey]
+	sort.SliceStable(pods, func(i, j int) bool {
+		switch key {
+		case "status":
+			return pods[i].Status < pods[j].Status
+		case "restarts":
+			return pods[i].Restarts > pods[j].Restarts
+		case "cpu":
+			return pods[i].cpuMilli > pods[j].cpuMilli
+		case "memory":
+			return pods[i].memBytes > pods[j].memBytes
+		}
+		return pods[i].Name < pods[j].Name
+	})
+}
+
+// render writes the status table; the selected workload is highlighted in the TUI
+func (w *healthWatcher) render(buf *bytes.Buffer, highlight bool) {
+	fmt.Fprintf(buf, "Namespace: %s    Updated: %s    Sort: %s\n\n", w.namespace, time.Now().Format("15:04:05"), watchSortKeys[w.sortKey])
+	for i, row := range w.rows() {
+		title := fmt.Sprintf("%s: %s  Desired: %d  Ready: %d  Updated: %d  Available: %d", row.Kind, row.Name, row.Desired, row.Ready, row.Updated, row.Available)
+		if highlight && i == w.selected {
+			title = "\033[7m" + title + colorReset
+		}
+		fmt.Fprintln(buf, title)
+		if row.Autoscaler != "" {
+			fmt.Fprintf(buf, "Autoscaler: %s\n", row.Autoscaler)
+		}
+		tw := tabwriter.NewWriter(buf, 0, 0, 2, ' ', 0)
+		fmt.Fprintln(tw, "POD\tSTATUS\tREADY\tRESTARTS\tAGE\tCPU (%REQ/%LIM)\tMEMORY (%REQ/%LIM)")
+		for _, pod := range row.Pods {
+			fmt.Fprintf(tw, "%s\t%s\t%s\t%d\t%s\t%s\t%s\n", pod.Name, pod.Status, pod.Ready, pod.Restarts, pod.Age, pod.CPU, pod.Memory)
+		}
+		tw.Flush()
+		fmt.Fprintln(buf)
+	}
+}
+
+// runTUI shows the dashboard on the alternate screen and handles key bindings
+func (w *healthWatcher) runTUI(ctx context.Context, interval time.Duration) error {
+	if !term.IsTerminal(int(os.Stdin.Fd())) {
+		return fmt.Errorf("--tui needs an interactive terminal")
+	}
+	keys := make(chan byte)
+	go func() {
+		buf := make([]byte, 1)
+		for {
+			if _, err := os.Stdin.Read(buf); err != nil {
+				close(keys)
+				return
+			}
+			keys <- buf[0]
+		}
+	}()
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		logsFor, err := w.dashboard(ctx, ticker, keys)
+		if err != nil || logsFor == "" {
+			return err
+		}
+		// Follow the logs of the selected workload until Ctrl-C, then come back
+		fmt.Printf("Streaming logs of %s, press Ctrl-C to return to the dashboard\n", logsFor)
+		logsCtx, stopLogs := signal.NotifyContext(ctx, os.Interrupt)
+		wl, pods, err := getWorkloadPods(w.clientset, w.namespace, w.kind, logsFor, false)
+		if err != nil {
+			fmt.Println(err)
+			<-logsCtx.Done()
+		} else {
+			tail := int64(20)
+			newLogStreamer(w.clientset, w.namespace, "", corev1.PodLogOptions{Follow: true, TailLines: &tail}).run(logsCtx, wl, pods, "text", nil)
+		}
+		stopLogs()
+	}
+}
+
+// dashboard draws until the user quits or asks for logs, returning the workload to show logs for
+func (w *healthWatcher) dashboard(ctx context.Context, ticker *time.Ticker, keys <-chan byte) (string, error) {
+	oldState, err := term.MakeRaw(int(os.Stdin.Fd()))
+	if err != nil {
+		return "", err
+	}
+	fmt.Print("\033[?1049h\033[?25l")
+	defer func() {
+		fmt.Print("\033[?25h\033[?1049l")
+		term.Restore(int(os.Stdin.Fd()), oldState)
+	}()
+
+	escape := 0
+	for {
+		w.refreshMetrics(ctx)
+		var buf bytes.Buffer
+		w.render(&buf, true)
+		fmt.Fprintln(&buf, "q: quit  s: sort  j/k or arrows: select workload  l: logs")
+		// Raw mode does not translate newlines
+		fmt.Print("\033[H\033[2J" + strings.ReplaceAll(buf.String(), "\n", "\r\n"))
+
+		select {
+		case <-ctx.Done():
+			return "", nil
+		case <-ticker.C:
+		case <-w.changed:
+		case key, ok := <-keys:
+			if !ok {
+				return "", nil
+			}
+			// Arrow keys arrive as ESC [ A / ESC [ B
+			if escape == 0 && key == 27 {
+				escape = 1
+				continue
+			}
+			if escape == 1 && key == '[' {
+				escape = 2
+				continue
+			}
+			if escape == 2 {
+				key = map[byte]byte{'A': 'k', 'B': 'j'}[key]
+			}
+			escape = 0
+			switch key {
+			case 'q', 3:
+				return "", nil
+			case 's':
+				w.sortKey = (w.sortKey + 1) % len(watchSortKeys)
+			case 'j':
+				if w.selected < len(w.names)-1 {
+					w.selected++
+				}
+			case 'k':
+				if w.selected > 0 {
+					w.selected--
+				}
+			case 'l':
+				return w.names[w.selected], nil
+			}
+		}
+	}
+}
+
+// describeAutoscaler summarizes the HPA targeting the workload, including the one KEDA creates
+func describeAutoscaler(kind, name string, hpas []*autoscalingv2.HorizontalPodAutoscaler) string {
+	for _, hpa := range hpas {
+		ref := hpa.Spec.ScaleTargetRef
+		if ref.Kind != kind || ref.Name != name {
+			continue
+		}
+		var minReplicas int32 = 1
+		if hpa.Spec.MinReplicas != nil {
+			minReplicas = *hpa.Spec.MinReplicas
+		}
+		metrics := []string{}
+		for i, current := range hpa.Status.CurrentMetrics {
+			target := ""
+			if i < len(hpa.Spec.Metrics) {
+				target = formatMetricTarget(hpa.Spec.Metrics[i])
+			}
+			metrics = append(metrics, fmt.Sprintf("%s %s/%s", metricName(current), formatMetricValue(current), target))
+		}
+		return fmt.Sprintf("%s replicas %d-%d (current %d, desired %d) %s", hpa.Name, minReplicas, hpa.Spec.MaxReplicas,
+			hpa.Status.CurrentReplicas, hpa.Status.DesiredReplicas, strings.Join(metrics, ", "))
+	}
+	return ""
+}
+
+func metricName(m autoscalingv2.MetricStatus) string {
+	switch m.Type {
+	case autoscalingv2.ResourceMetricSourceType:
+		if m.Resource != nil {
+			return string(m.Resource.Name)
+		}
+	case autoscalingv2.ContainerResourceMetricSourceType:
+		if m.ContainerResource != nil {
+			return string(m.ContainerResource.Name)
+		}
+	case autoscalingv2.ExternalMetricSourceType:
+		if m.External != nil {
+			return m.External.Metric.Name
+		}
+	case autoscalingv2.PodsMetricSourceType:
+		if m.Pods != nil {
+			return m.Pods.Metric.Name
+		}
+	case autoscalingv2.ObjectMetricSourceType:
+		if m.Object != nil {
+			return m.Object.Metric.Name
+		}
+	}
+	return string(m.Type)
+}
+
+func formatMetricValue(m autoscalingv2.MetricStatus) string {
+	var value autoscalingv2.MetricValueStatus
+	switch {
+	case m.Resource != nil:
+		value = m.Resource.Current
+	case m.ContainerResource != nil:
+		value = m.ContainerResource.Current
+	case m.External != nil:
+		value = m.External.Current
+	case m.Pods != nil:
+		value = m.Pods.Current
+	case m.Object != nil:
+		value = m.Object.Current
+	}
+	switch {
+	case value.AverageUtilization != nil:
+		return fmt.Sprintf("%d%%", *value.AverageUtilization)
+	case value.AverageValue != nil:
+		return value.AverageValue.String()
+	case value.Value != nil:
+		return value.Value.String()
+	}
+	return "<unknown>"
+}
+
+func formatMetricTarget(m autoscalingv2.MetricSpec) string {
+	var target autoscalingv2.MetricTarget
+	switch {
+	case m.Resource != nil:
+		target = m.Resource.Target
+	case m.ContainerResource != nil:
+		target = m.ContainerResource.Target
+	case m.External != nil:
+		target = m.External.Target
+	case m.Pods != nil:
+		target = m.Pods.Target
+	case m.Object != nil:
+		target = m.Object.Target
+	}
+	switch {
+	case target.AverageUtilization != nil:
+		return fmt.Sprintf("%d%%", *target.AverageUtilization)
+	case target.AverageValue != nil:
+		return target.AverageValue.String()
+	case target.Value != nil:
+		return target.Value.String()
+	}
+	return "<unknown>"
+}
+
+func addResources(total, add corev1.ResourceList) {
+	for name, quantity := range add {
+		sum := total[name]
+		sum.Add(quantity)
+		total[name] = sum
+	}
+}
+
+// formatUsage prints usage with its share of the request and limit
+func formatUsage(usage, request, limit resource.Quantity, milli bool) string {
+	percent := func(of resource.Quantity) string {
+		if of.IsZero() {
+			return "-"
+		}
+		if milli {
+			return fmt.Sprintf("%d%%", usage.MilliValue()*100/of.MilliValue())
+		}
+		return fmt.Sprintf("%d%%", usage.Value()*100/of.Value())
+	}
+	var value string
+	if milli {
+		value = fmt.Sprintf("%dm", usage.MilliValue())
+	} else {
+		value = fmt.Sprintf("%dMi", usage.Value()/(1024*1024))
+	}
+	return fmt.Sprintf("%s (%s/%s)", value, percent(request), percent(limit))
+}